@@ -0,0 +1,737 @@
+package ortfodb
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/tcolgate/mp3"
+)
+
+// AudioMetadata is information about an audio file, from its tags.
+type AudioMetadata struct {
+	Title  string `json:"title"`
+	Artist string `json:"artist"`
+	Album  string `json:"album"`
+	// Cover art embedded in the file, extracted next to the media in the media directory.
+	// Empty if the file has none, or if the media is not copied to the media directory.
+	Cover FilePathInsideMediaRoot `json:"cover"`
+}
+
+// AudioTags are the tags of an audio file, as read by AnalyzeAudio.
+type AudioTags struct {
+	Title  string
+	Artist string
+	Album  string
+	// Image data of the cover art, nil if there's none
+	Cover []byte
+	// Content type of Cover, such as image/jpeg
+	CoverContentType string
+}
+
+// Metadata returns the tags as they are stored in the database, without the cover art itself.
+func (t AudioTags) Metadata() AudioMetadata {
+	return AudioMetadata{Title: t.Title, Artist: t.Artist, Album: t.Album}
+}
+
+// AnalyzeAudio returns the duration in seconds and the tags of the audio file.
+// MP3 (with ID3v2 tags), WAV, FLAC, Ogg (Vorbis and Opus) and MP4 (M4A) files are supported.
+func AnalyzeAudio(file io.ReadSeeker) (duration float64, tags AudioTags, err error) {
+	header := make([]byte, 12)
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		return 0, AudioTags{}, err
+	}
+	if _, err = io.ReadFull(file, header); err != nil {
+		return 0, AudioTags{}, fmt.Errorf("while reading header: %w", err)
+	}
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		return 0, AudioTags{}, err
+	}
+
+	switch audioContainer(header) {
+	case "wav":
+		return analyzeWAV(file)
+	case "flac":
+		return analyzeFLAC(file)
+	case "ogg":
+		return analyzeOgg(file)
+	case "mp4":
+		return analyzeMP4(file)
+	case "mp3":
+		return analyzeMP3(file)
+	}
+	return 0, AudioTags{}, fmt.Errorf("unsupported audio format")
+}
+
+// audioContainer returns the format of an audio file given its first bytes: mp3, wav, flac, ogg, mp4, or an empty string if it's not recognized.
+func audioContainer(header []byte) string {
+	switch {
+	case len(header) >= 12 && string(header[:4]) == "RIFF" && string(header[8:12]) == "WAVE":
+		return "wav"
+	case bytes.HasPrefix(header, []byte("fLaC")):
+		return "flac"
+	case bytes.HasPrefix(header, []byte("OggS")):
+		return "ogg"
+	case len(header) >= 8 && string(header[4:8]) == "ftyp":
+		return "mp4"
+	case bytes.HasPrefix(header, []byte("ID3")), len(header) >= 2 && header[0] == 0xFF && header[1]&0xE0 == 0xE0:
+		return "mp3"
+	}
+	return ""
+}
+
+func analyzeMP3(file io.ReadSeeker) (duration float64, tags AudioTags, err error) {
+	header := make([]byte, 10)
+	if _, err := io.ReadFull(file, header); err == nil && string(header[:3]) == "ID3" {
+		tag := make([]byte, synchsafe(header[6:10]))
+		if _, err := io.ReadFull(file, tag); err == nil {
+			tags = parseID3v2(header, tag)
+		}
+	}
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		return 0, tags, err
+	}
+
+	// The decoder skips the ID3 tag by itself
+	decoder := mp3.NewDecoder(file)
+	skipped := 0
+	var frame mp3.Frame
+	for decoder.Decode(&frame, &skipped) == nil {
+		duration += frame.Duration().Seconds()
+	}
+	return duration, tags, nil
+}
+
+func analyzeWAV(file io.ReadSeeker) (duration float64, tags AudioTags, err error) {
+	format, _, dataSize, err := readWAVChunks(file, func(id string, content []byte) {
+		switch id {
+		case "LIST":
+			if bytes.HasPrefix(content, []byte("INFO")) {
+				tags = parseRIFFInfo(content[4:])
+			}
+		case "id3 ", "ID3 ":
+			if len(content) >= 10 && string(content[:3]) == "ID3" {
+				tags = parseID3v2(content[:10], content[10:])
+			}
+		}
+	})
+	if err != nil {
+		return 0, tags, err
+	}
+	if format.byteRate == 0 {
+		return 0, tags, fmt.Errorf("WAV file has no valid fmt chunk")
+	}
+	return float64(dataSize) / float64(format.byteRate), tags, nil
+}
+
+// wavFormat is the content of the fmt chunk of WAV files.
+type wavFormat struct {
+	encoding      uint16
+	channels      uint16
+	sampleRate    uint32
+	byteRate      uint32
+	bitsPerSample uint16
+}
+
+// readWAVChunks reads the chunks of a WAV file, calling onChunk with the ones that are not the fmt or data chunks.
+// It returns the offset and size of the data chunk, leaving the file positioned at the end of the last chunk read.
+func readWAVChunks(file io.ReadSeeker, onChunk func(id string, content []byte)) (format wavFormat, dataOffset int64, dataSize int64, err error) {
+	if _, err = file.Seek(12, io.SeekStart); err != nil {
+		return
+	}
+	chunkHeader := make([]byte, 8)
+	for {
+		if _, readErr := io.ReadFull(file, chunkHeader); readErr != nil {
+			break
+		}
+		id, size := string(chunkHeader[:4]), int64(binary.LittleEndian.Uint32(chunkHeader[4:]))
+		// Chunks are padded to an even size
+		padded := size + size%2
+		switch id {
+		case "data":
+			dataOffset, _ = file.Seek(0, io.SeekCurrent)
+			dataSize = size
+			if _, err = file.Seek(padded, io.SeekCurrent); err != nil {
+				return
+			}
+		case "fmt ", "LIST", "id3 ", "ID3 ":
+			content := make([]byte, padded)
+			read, readErr := io.ReadFull(file, content)
+			if readErr != nil && !errors.Is(readErr, io.ErrUnexpectedEOF) {
+				return format, dataOffset, dataSize, fmt.Errorf("while reading %q chunk: %w", id, readErr)
+			}
+			content = content[:min(int64(read), size)]
+			if id == "fmt " && len(content) >= 16 {
+				format = wavFormat{
+					encoding:      binary.LittleEndian.Uint16(content),
+					channels:      binary.LittleEndian.Uint16(content[2:]),
+					sampleRate:    binary.LittleEndian.Uint32(content[4:]),
+					byteRate:      binary.LittleEndian.Uint32(content[8:]),
+					bitsPerSample: binary.LittleEndian.Uint16(content[14:]),
+				}
+			} else if id != "fmt " {
+				onChunk(id, content)
+			}
+		default:
+			if _, err = file.Seek(padded, io.SeekCurrent); err != nil {
+				return
+			}
+		}
+	}
+	return format, dataOffset, dataSize, nil
+}
+
+// parseRIFFInfo parses the subchunks of a LIST INFO chunk of a WAV file.
+func parseRIFFInfo(content []byte) (tags AudioTags) {
+	for len(content) >= 8 {
+		id, size := string(content[:4]), int(binary.LittleEndian.Uint32(content[4:]))
+		if 8+size > len(content) {
+			break
+		}
+		value := strings.TrimSpace(strings.TrimRight(string(content[8:8+size]), "\x00"))
+		switch id {
+		case "INAM":
+			tags.Title = value
+		case "IART":
+			tags.Artist = value
+		case "IPRD":
+			tags.Album = value
+		}
+		content = content[min(len(content), 8+size+size%2):]
+	}
+	return tags
+}
+
+func analyzeFLAC(file io.ReadSeeker) (duration float64, tags AudioTags, err error) {
+	if _, err = file.Seek(4, io.SeekStart); err != nil {
+		return
+	}
+	blockHeader := make([]byte, 4)
+	for {
+		if _, err = io.ReadFull(file, blockHeader); err != nil {
+			return duration, tags, fmt.Errorf("while reading FLAC metadata block: %w", err)
+		}
+		last, blockType := blockHeader[0]&0x80 != 0, blockHeader[0]&0x7F
+		size := int(blockHeader[1])<<16 | int(blockHeader[2])<<8 | int(blockHeader[3])
+		block := make([]byte, size)
+		if _, err = io.ReadFull(file, block); err != nil {
+			return duration, tags, fmt.Errorf("while reading FLAC metadata block: %w", err)
+		}
+
+		switch blockType {
+		case 0: // STREAMINFO
+			if len(block) >= 18 {
+				sampleRate := int(block[10])<<12 | int(block[11])<<4 | int(block[12])>>4
+				samples := int64(block[13]&0x0F)<<32 | int64(binary.BigEndian.Uint32(block[14:]))
+				if sampleRate > 0 {
+					duration = float64(samples) / float64(sampleRate)
+				}
+			}
+		case 4: // VORBIS_COMMENT
+			comments := parseVorbisComment(block)
+			tags.Title, tags.Artist, tags.Album = comments.Title, comments.Artist, comments.Album
+			if tags.Cover == nil {
+				tags.Cover, tags.CoverContentType = comments.Cover, comments.CoverContentType
+			}
+		case 6: // PICTURE
+			if pictureType, contentType, data, ok := parseFLACPicture(block); ok && (tags.Cover == nil || pictureType == frontCoverPictureType) {
+				tags.Cover, tags.CoverContentType = data, contentType
+			}
+		}
+
+		if last {
+			return duration, tags, nil
+		}
+	}
+}
+
+// frontCoverPictureType is the type of front cover pictures in FLAC PICTURE blocks and ID3v2 APIC frames. Other pictures are only used if there's no front cover.
+const frontCoverPictureType = 3
+
+// parseFLACPicture parses a FLAC PICTURE metadata block, also used as the (base64-encoded) value of METADATA_BLOCK_PICTURE Vorbis comments.
+func parseFLACPicture(block []byte) (pictureType uint32, contentType string, data []byte, ok bool) {
+	reader := bytes.NewReader(block)
+	var header struct{ PictureType, ContentTypeLength uint32 }
+	if binary.Read(reader, binary.BigEndian, &header) != nil || int(header.ContentTypeLength) > reader.Len() {
+		return 0, "", nil, false
+	}
+	contentTypeBytes := make([]byte, header.ContentTypeLength)
+	reader.Read(contentTypeBytes)
+	var descriptionLength uint32
+	if binary.Read(reader, binary.BigEndian, &descriptionLength) != nil || int(descriptionLength) > reader.Len() {
+		return 0, "", nil, false
+	}
+	// Skip the description, width, height, color depth and number of colors
+	reader.Seek(int64(descriptionLength)+16, io.SeekCurrent)
+	var dataLength uint32
+	if binary.Read(reader, binary.BigEndian, &dataLength) != nil || int(dataLength) > reader.Len() {
+		return 0, "", nil, false
+	}
+	data = make([]byte, dataLength)
+	reader.Read(data)
+	return header.PictureType, string(contentTypeBytes), data, true
+}
+
+// parseVorbisComment parses Vorbis comments, used by FLAC, Ogg Vorbis and Opus files.
+func parseVorbisComment(content []byte) (tags AudioTags) {
+	next := func() ([]byte, bool) {
+		if len(content) < 4 {
+			return nil, false
+		}
+		length := int(binary.LittleEndian.Uint32(content))
+		if length > len(content)-4 {
+			return nil, false
+		}
+		value := content[4 : 4+length]
+		content = content[4+length:]
+		return value, true
+	}
+
+	// Skip the vendor string
+	if _, ok := next(); !ok || len(content) < 4 {
+		return
+	}
+	count := binary.LittleEndian.Uint32(content)
+	content = content[4:]
+	var legacyCover, legacyCoverContentType string
+	for i := uint32(0); i < count; i++ {
+		comment, ok := next()
+		if !ok {
+			break
+		}
+		key, value, found := strings.Cut(string(comment), "=")
+		if !found {
+			continue
+		}
+		switch strings.ToUpper(key) {
+		case "TITLE":
+			tags.Title = firstNonEmpty(tags.Title, value)
+		case "ARTIST":
+			tags.Artist = firstNonEmpty(tags.Artist, value)
+		case "ALBUM":
+			tags.Album = firstNonEmpty(tags.Album, value)
+		case "METADATA_BLOCK_PICTURE":
+			decoded, err := base64.StdEncoding.DecodeString(value)
+			if err != nil {
+				continue
+			}
+			if pictureType, contentType, data, ok := parseFLACPicture(decoded); ok && (tags.Cover == nil || pictureType == frontCoverPictureType) {
+				tags.Cover, tags.CoverContentType = data, contentType
+			}
+		case "COVERART":
+			legacyCover = value
+		case "COVERARTMIME":
+			legacyCoverContentType = value
+		}
+	}
+	if tags.Cover == nil && legacyCover != "" {
+		if decoded, err := base64.StdEncoding.DecodeString(legacyCover); err == nil {
+			tags.Cover, tags.CoverContentType = decoded, legacyCoverContentType
+		}
+	}
+	return tags
+}
+
+func analyzeOgg(file io.ReadSeeker) (duration float64, tags AudioTags, err error) {
+	// The first packet identifies the codec, the second one has the comments
+	packets, serial, err := readOggPackets(file, 2)
+	if err != nil {
+		return 0, tags, fmt.Errorf("while reading Ogg packets: %w", err)
+	}
+	if len(packets) < 2 {
+		return 0, tags, fmt.Errorf("Ogg file has no comment header")
+	}
+
+	var sampleRate, preSkip int64
+	switch identification, comments := packets[0], packets[1]; {
+	case bytes.HasPrefix(identification, []byte("\x01vorbis")) && len(identification) >= 16:
+		sampleRate = int64(binary.LittleEndian.Uint32(identification[12:]))
+		if bytes.HasPrefix(comments, []byte("\x03vorbis")) {
+			tags = parseVorbisComment(comments[7:])
+		}
+	case bytes.HasPrefix(identification, []byte("OpusHead")) && len(identification) >= 12:
+		// Opus granule positions are always at 48 kHz, whatever the input sample rate was
+		sampleRate = 48000
+		preSkip = int64(binary.LittleEndian.Uint16(identification[10:]))
+		if bytes.HasPrefix(comments, []byte("OpusTags")) {
+			tags = parseVorbisComment(comments[8:])
+		}
+	default:
+		return 0, tags, fmt.Errorf("unsupported Ogg codec")
+	}
+
+	granule, err := lastOggGranulePosition(file, serial)
+	if err != nil {
+		return 0, tags, err
+	}
+	if sampleRate > 0 && granule > preSkip {
+		duration = float64(granule-preSkip) / float64(sampleRate)
+	}
+	return duration, tags, nil
+}
+
+// oggPageHeader is the fixed-size part of the header of Ogg pages, followed by the segments table.
+type oggPageHeader struct {
+	Capture         [4]byte
+	Version         byte
+	Flags           byte
+	GranulePosition int64
+	Serial          uint32
+	Sequence        uint32
+	Checksum        uint32
+	SegmentsCount   byte
+}
+
+// readOggPackets returns the first count packets of the first logical stream of an Ogg file, and its serial number.
+func readOggPackets(file io.ReadSeeker, count int) (packets [][]byte, serial uint32, err error) {
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		return
+	}
+	var packet []byte
+	firstPage := true
+	for len(packets) < count {
+		var header oggPageHeader
+		if err := binary.Read(file, binary.LittleEndian, &header); err != nil {
+			return packets, serial, nil
+		}
+		if string(header.Capture[:]) != "OggS" {
+			return packets, serial, fmt.Errorf("invalid Ogg page")
+		}
+		segments := make([]byte, header.SegmentsCount)
+		if _, err := io.ReadFull(file, segments); err != nil {
+			return packets, serial, err
+		}
+		if firstPage {
+			serial, firstPage = header.Serial, false
+		}
+		for _, size := range segments {
+			segment := make([]byte, size)
+			if _, err := io.ReadFull(file, segment); err != nil {
+				return packets, serial, err
+			}
+			if header.Serial != serial {
+				continue
+			}
+			packet = append(packet, segment...)
+			// Packets end with a segment shorter than 255 bytes
+			if size < 255 {
+				packets = append(packets, packet)
+				packet = nil
+				if len(packets) == count {
+					break
+				}
+			}
+		}
+	}
+	return packets, serial, nil
+}
+
+// lastOggGranulePosition returns the granule position of the last page of the logical stream serial, which is the number of samples of the stream.
+func lastOggGranulePosition(file io.ReadSeeker, serial uint32) (int64, error) {
+	size, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	// Pages are at most 65307 bytes long, so the last one starts in the last 64 KiB
+	start := max(0, size-65536)
+	if _, err := file.Seek(start, io.SeekStart); err != nil {
+		return 0, err
+	}
+	tail, err := io.ReadAll(file)
+	if err != nil {
+		return 0, err
+	}
+	for position := bytes.LastIndex(tail, []byte("OggS")); position != -1; position = bytes.LastIndex(tail[:position], []byte("OggS")) {
+		if position+27 > len(tail) {
+			continue
+		}
+		granule := int64(binary.LittleEndian.Uint64(tail[position+6:]))
+		if binary.LittleEndian.Uint32(tail[position+14:]) == serial && granule != -1 {
+			return granule, nil
+		}
+	}
+	return 0, fmt.Errorf("no Ogg page with a granule position found at the end of the file")
+}
+
+func analyzeMP4(file io.ReadSeeker) (duration float64, tags AudioTags, err error) {
+	size, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, tags, err
+	}
+	// The moov atom has the metadata, it can be before or after the (large) audio data, which is skipped without reading it
+	moov, err := findMP4Atom(file, 0, size, "moov")
+	if err != nil {
+		return 0, tags, err
+	}
+	atoms := mp4Atoms(moov)
+
+	mvhd := atoms["mvhd"]
+	if len(mvhd) >= 20 && mvhd[0] == 0 {
+		timescale, length := binary.BigEndian.Uint32(mvhd[12:]), binary.BigEndian.Uint32(mvhd[16:])
+		if timescale > 0 {
+			duration = float64(length) / float64(timescale)
+		}
+	} else if len(mvhd) >= 32 && mvhd[0] == 1 {
+		timescale, length := binary.BigEndian.Uint32(mvhd[20:]), binary.BigEndian.Uint64(mvhd[24:])
+		if timescale > 0 {
+			duration = float64(length) / float64(timescale)
+		}
+	}
+
+	// Tags are in moov > udta > meta > ilst, meta having 4 bytes of version and flags before its children
+	meta := mp4Atoms(atoms["udta"])["meta"]
+	if len(meta) < 4 {
+		return duration, tags, nil
+	}
+	for name, item := range mp4Atoms(mp4Atoms(meta[4:])["ilst"]) {
+		data := mp4Atoms(item)["data"]
+		// data atoms start with the type of the value and a locale
+		if len(data) < 8 {
+			continue
+		}
+		dataType, value := binary.BigEndian.Uint32(data), data[8:]
+		switch name {
+		case "\xa9nam":
+			tags.Title = string(value)
+		case "\xa9ART":
+			tags.Artist = string(value)
+		case "\xa9alb":
+			tags.Album = string(value)
+		case "covr":
+			tags.Cover = value
+			tags.CoverContentType = "image/jpeg"
+			if dataType == 14 {
+				tags.CoverContentType = "image/png"
+			}
+		}
+	}
+	return duration, tags, nil
+}
+
+// findMP4Atom returns the content of the first atom named name between offsets start and end of the file.
+func findMP4Atom(file io.ReadSeeker, start int64, end int64, name string) ([]byte, error) {
+	header := make([]byte, 16)
+	for position := start; position+8 <= end; {
+		if _, err := file.Seek(position, io.SeekStart); err != nil {
+			return nil, err
+		}
+		if _, err := io.ReadFull(file, header[:8]); err != nil {
+			return nil, err
+		}
+		size, headerSize := int64(binary.BigEndian.Uint32(header)), int64(8)
+		switch size {
+		case 0:
+			// The atom extends to the end of the file
+			size = end - position
+		case 1:
+			// 64-bit size
+			if _, err := io.ReadFull(file, header[8:]); err != nil {
+				return nil, err
+			}
+			size, headerSize = int64(binary.BigEndian.Uint64(header[8:])), 16
+		}
+		if size < headerSize {
+			return nil, fmt.Errorf("invalid MP4 atom size %d at offset %d", size, position)
+		}
+		if string(header[4:8]) == name {
+			content := make([]byte, size-headerSize)
+			if _, err := io.ReadFull(file, content); err != nil {
+				return nil, fmt.Errorf("while reading MP4 atom %q: %w", name, err)
+			}
+			return content, nil
+		}
+		position += size
+	}
+	return nil, fmt.Errorf("no %q atom found", name)
+}
+
+// mp4Atoms returns the content of atoms directly inside content, keyed by name. When there are multiple atoms with the same name, the first one is kept.
+func mp4Atoms(content []byte) map[string][]byte {
+	atoms := make(map[string][]byte)
+	for len(content) >= 8 {
+		size, headerSize := uint64(binary.BigEndian.Uint32(content)), uint64(8)
+		if size == 1 && len(content) >= 16 {
+			size, headerSize = binary.BigEndian.Uint64(content[8:]), 16
+		} else if size == 0 {
+			size = uint64(len(content))
+		}
+		if size < headerSize || size > uint64(len(content)) {
+			break
+		}
+		if name := string(content[4:8]); atoms[name] == nil {
+			atoms[name] = content[headerSize:size]
+		}
+		content = content[size:]
+	}
+	return atoms
+}
+
+// synchsafe decodes ID3v2 sizes, that use 7 bits per byte.
+func synchsafe(b []byte) int {
+	size := 0
+	for _, c := range b {
+		size = size<<7 | int(c&0x7F)
+	}
+	return size
+}
+
+// parseID3v2 parses the frames of an ID3v2 tag, given its 10-byte header and its content.
+func parseID3v2(header []byte, tag []byte) (tags AudioTags) {
+	version, flags := header[3], header[5]
+	// Unsynchronisation inserts a null byte after 0xFF bytes, in the whole tag for ID3v2.2 and ID3v2.3
+	if flags&0x80 != 0 && version < 4 {
+		tag = bytes.ReplaceAll(tag, []byte{0xFF, 0x00}, []byte{0xFF})
+	}
+	// Skip the extended header
+	if flags&0x40 != 0 && len(tag) >= 4 {
+		if version == 4 {
+			tag = tag[min(len(tag), synchsafe(tag[:4])):]
+		} else {
+			tag = tag[min(len(tag), 4+int(binary.BigEndian.Uint32(tag))):]
+		}
+	}
+
+	idLength, headerLength := 4, 10
+	if version == 2 {
+		idLength, headerLength = 3, 6
+	}
+	coverType := -1
+	for len(tag) >= headerLength && tag[0] != 0 {
+		id := string(tag[:idLength])
+		var size int
+		switch version {
+		case 2:
+			size = int(tag[3])<<16 | int(tag[4])<<8 | int(tag[5])
+		case 3:
+			size = int(binary.BigEndian.Uint32(tag[4:]))
+		default:
+			size = synchsafe(tag[4:8])
+		}
+		if size > len(tag)-headerLength {
+			break
+		}
+		frame := tag[headerLength : headerLength+size]
+		tag = tag[headerLength+size:]
+		if len(frame) == 0 {
+			continue
+		}
+
+		switch id {
+		case "TIT2", "TT2":
+			tags.Title = id3Text(frame[0], frame[1:])
+		case "TPE1", "TP1":
+			tags.Artist = id3Text(frame[0], frame[1:])
+		case "TALB", "TAL":
+			tags.Album = id3Text(frame[0], frame[1:])
+		case "APIC", "PIC":
+			pictureType, contentType, data, ok := parseID3Picture(frame, id == "PIC")
+			// Prefer the front cover, then the first picture
+			if ok && (coverType == -1 || (pictureType == frontCoverPictureType && coverType != frontCoverPictureType)) {
+				tags.Cover, tags.CoverContentType, coverType = data, contentType, pictureType
+			}
+		}
+	}
+	return tags
+}
+
+// parseID3Picture parses APIC frames (ID3v2.3 and ID3v2.4), or PIC frames (ID3v2.2) if legacy is true.
+func parseID3Picture(frame []byte, legacy bool) (pictureType int, contentType string, data []byte, ok bool) {
+	encoding, rest := frame[0], frame[1:]
+	if legacy {
+		if len(rest) < 3 {
+			return 0, "", nil, false
+		}
+		contentType, rest = "image/"+strings.ToLower(string(rest[:3])), rest[3:]
+		if contentType == "image/jpg" {
+			contentType = "image/jpeg"
+		}
+	} else {
+		end := bytes.IndexByte(rest, 0)
+		if end == -1 {
+			return 0, "", nil, false
+		}
+		contentType, rest = string(rest[:end]), rest[end+1:]
+		if !strings.Contains(contentType, "/") {
+			contentType = "image/" + strings.ToLower(contentType)
+		}
+	}
+	if len(rest) < 1 {
+		return 0, "", nil, false
+	}
+	pictureType, rest = int(rest[0]), rest[1:]
+	// Skip the description, terminated by one or two null bytes depending on the encoding
+	if encoding == 1 || encoding == 2 {
+		for i := 0; i+1 < len(rest); i += 2 {
+			if rest[i] == 0 && rest[i+1] == 0 {
+				return pictureType, contentType, rest[i+2:], true
+			}
+		}
+		return 0, "", nil, false
+	}
+	end := bytes.IndexByte(rest, 0)
+	if end == -1 {
+		return 0, "", nil, false
+	}
+	return pictureType, contentType, rest[end+1:], true
+}
+
+// id3Text decodes the value of an ID3v2 text frame. When there are multiple values, the first one is returned.
+func id3Text(encoding byte, value []byte) string {
+	var text string
+	switch encoding {
+	case 0: // ISO-8859-1
+		runes := make([]rune, len(value))
+		for i, b := range value {
+			runes[i] = rune(b)
+		}
+		text = string(runes)
+	case 1, 2: // UTF-16 with a byte order mark, UTF-16BE
+		var order binary.ByteOrder = binary.BigEndian
+		if len(value) >= 2 && value[0] == 0xFF && value[1] == 0xFE {
+			order, value = binary.LittleEndian, value[2:]
+		} else if len(value) >= 2 && value[0] == 0xFE && value[1] == 0xFF {
+			value = value[2:]
+		}
+		units := make([]uint16, len(value)/2)
+		for i := range units {
+			units[i] = order.Uint16(value[2*i:])
+		}
+		text = string(utf16.Decode(units))
+	default: // UTF-8
+		text = string(value)
+	}
+	text, _, _ = strings.Cut(text, "\x00")
+	return strings.TrimSpace(text)
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// writeAudioCover writes the cover art of the audio file at filename to saveTo.
+func writeAudioCover(filename string, saveTo string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, tags, err := AnalyzeAudio(file)
+	if err != nil {
+		return fmt.Errorf("while reading tags of %s: %w", filename, err)
+	}
+	if tags.Cover == nil {
+		return fmt.Errorf("%s has no cover art", filename)
+	}
+	return os.WriteFile(saveTo, tags.Cover, 0o644)
+}
@@ -0,0 +1,535 @@
+package ortfodb
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/anaskhan96/soup"
+	"github.com/metal3d/go-slugify"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/text"
+	"golang.org/x/net/html"
+)
+
+// BlockType describes a type of content block: how to detect it in the HTML converted from a description.md file, and how to turn it back into markdown.
+// Register your own with RegisterBlockType.
+type BlockType struct {
+	// Name is the value of ContentBlock.Type for blocks of this type.
+	Name ContentBlockType
+	// Shorthand is used to refer to blocks of this type in layouts, followed by a 1-based index (e.g. "p" for p1, p2, etc.). Must only contain letters.
+	Shorthand string
+	// Detect is called on every top-level HTML element of a description, and returns ok = false if the element is not a block of this type.
+	// The block's Type and ID are set automatically if left empty.
+	// Use ContentBlock.Data to store fields that don't fit in ContentBlock's.
+	Detect func(ctx *RunContext, element soup.Root) (block ContentBlock, ok bool, err error)
+	// Replicate returns the markdown that Detect would detect as block. Used by ReplicateDescription.
+	Replicate func(ctx *RunContext, block ContentBlock) (string, error)
+}
+
+// CodeBlock represents a code listing in a description.md file.
+type CodeBlock struct {
+	Code         string `json:"code"`
+	CodeLanguage string `json:"codeLanguage"` // empty if the listing doesn't declare a language
+}
+
+// Quote represents a blockquote in a description.md file. Its content is stored in Paragraph.Content.
+type Quote struct {
+	// Citation is taken from the quote's last paragraph if it starts with a dash, e.g. "— Someone"
+	Citation string `json:"citation"`
+}
+
+// Heading represents a heading (from level 2 to 6, level 1 being the work's title) in a description.md file. Its content is stored in Paragraph.Content.
+type Heading struct {
+	Level int `json:"level"`
+}
+
+// Embed represents an embedded external page in a description.md file: either a raw <iframe>, or a media embed declaration pointing to a YouTube or Vimeo video.
+// Its alt text, caption and original URL are stored in Link.Text, Link.Title and Link.URL.
+type Embed struct {
+	Provider string `json:"provider"` // one of "youtube", "vimeo" or "iframe"
+	EmbedURL string `json:"embedURL"` // URL to use as the src of an <iframe>
+}
+
+// Gallery represents several media embed declarations in the same paragraph of a description.md file.
+type Gallery struct {
+	Items []Media `json:"items"`
+}
+
+var blockTypesLock sync.RWMutex
+
+// blockTypes are tried in order, so that the first one that detects an element wins. Paragraphs are the catch-all, so they come last.
+var blockTypes = []BlockType{
+	{Name: "heading", Shorthand: "h", Detect: detectHeading, Replicate: replicateHeading},
+	{Name: "code", Shorthand: "c", Detect: detectCode, Replicate: replicateCode},
+	{Name: "quote", Shorthand: "q", Detect: detectQuote, Replicate: replicateQuote},
+	{Name: "gallery", Shorthand: "g", Detect: detectGallery, Replicate: replicateGallery},
+	{Name: "embed", Shorthand: "e", Detect: detectEmbed, Replicate: replicateEmbed},
+	{Name: "media", Shorthand: "m", Detect: detectMedia, Replicate: func(ctx *RunContext, block ContentBlock) (string, error) {
+		return ctx.replicateMediaEmbed(block.Media), nil
+	}},
+	{Name: "link", Shorthand: "l", Detect: detectLink, Replicate: func(ctx *RunContext, block ContentBlock) (string, error) {
+		return ctx.replicateLink(block.Link), nil
+	}},
+	{Name: "paragraph", Shorthand: "p", Detect: detectParagraph, Replicate: func(ctx *RunContext, block ContentBlock) (string, error) {
+		return ctx.replicateParagraph(block.Anchor, block.Paragraph)
+	}},
+}
+
+// RegisterBlockType adds a block type, that will be detected before all previously registered ones.
+// Registering a block type with the same name as an existing one replaces it, keeping its detection priority.
+// This is not safe to call while descriptions are being parsed.
+func RegisterBlockType(blockType BlockType) error {
+	if blockType.Name == "" {
+		return fmt.Errorf("block type has no name")
+	}
+	if blockType.Detect == nil || blockType.Replicate == nil {
+		return fmt.Errorf("block type %s must have both a Detect and a Replicate function", blockType.Name)
+	}
+	if blockType.Shorthand == "" || strings.IndexFunc(blockType.Shorthand, func(r rune) bool { return !unicode.IsLetter(r) }) != -1 {
+		return fmt.Errorf("shorthand %q of block type %s must be made of letters only", blockType.Shorthand, blockType.Name)
+	}
+
+	blockTypesLock.Lock()
+	defer blockTypesLock.Unlock()
+
+	for _, other := range blockTypes {
+		if other.Name != blockType.Name && other.Shorthand == blockType.Shorthand {
+			return fmt.Errorf("shorthand %q of block type %s is already used by block type %s", blockType.Shorthand, blockType.Name, other.Name)
+		}
+	}
+
+	for i, other := range blockTypes {
+		if other.Name == blockType.Name {
+			blockTypes[i] = blockType
+			return nil
+		}
+	}
+	blockTypes = append([]BlockType{blockType}, blockTypes...)
+	return nil
+}
+
+// BlockTypes returns all registered block types, in the order they are detected in.
+func BlockTypes() []BlockType {
+	blockTypesLock.RLock()
+	defer blockTypesLock.RUnlock()
+	return append([]BlockType{}, blockTypes...)
+}
+
+// FindBlockType returns the registered block type with the given name.
+func FindBlockType(name ContentBlockType) (BlockType, bool) {
+	for _, blockType := range BlockTypes() {
+		if blockType.Name == name {
+			return blockType, true
+		}
+	}
+	return BlockType{}, false
+}
+
+func findBlockTypeByShorthand(shorthand string) (BlockType, bool) {
+	for _, blockType := range BlockTypes() {
+		if blockType.Shorthand == shorthand {
+			return blockType, true
+		}
+	}
+	return BlockType{}, false
+}
+
+// detectBlock returns the content block the given element represents, trying every registered block type in order.
+func (ctx *RunContext) detectBlock(element soup.Root) (block ContentBlock, ok bool, err error) {
+	for _, blockType := range BlockTypes() {
+		block, ok, err = blockType.Detect(ctx, element)
+		if err != nil {
+			return ContentBlock{}, false, fmt.Errorf("while detecting %s block: %w", blockType.Name, err)
+		}
+		if !ok {
+			continue
+		}
+		if block.Type == "" {
+			block.Type = blockType.Name
+		}
+		if block.ID == "" {
+			block.ID = block.generateID()
+		}
+		return block, true, nil
+	}
+	return ContentBlock{}, false, nil
+}
+
+// Mediae returns the media of a media block, or the items of a gallery block. Other blocks have none.
+func (b ContentBlock) Mediae() []Media {
+	switch {
+	case b.Type.IsMedia():
+		return []Media{b.Media}
+	case b.Type.IsGallery():
+		return b.Items
+	}
+	return nil
+}
+
+// isElement returns true if the given node is an HTML element (and not a text node or a comment, for example).
+func isElement(node soup.Root) bool {
+	return node.Pointer != nil && node.Pointer.Type == html.ElementNode
+}
+
+// isBlank returns true if the given node is a text node that only contains whitespace.
+func isBlank(node soup.Root) bool {
+	return node.Pointer != nil && node.Pointer.Type == html.TextNode && strings.TrimSpace(node.Pointer.Data) == ""
+}
+
+// elementChildren returns the children of element that are elements.
+func elementChildren(element soup.Root) []soup.Root {
+	children := make([]soup.Root, 0)
+	for _, child := range element.Children() {
+		if isElement(child) {
+			children = append(children, child)
+		}
+	}
+	return children
+}
+
+// mediaFromImage returns the (unanalyzed) media an <img> element declares.
+func mediaFromImage(img soup.Root) (Media, error) {
+	alt, attributes := ExtractAttributesFromAlt(img.Attrs()["alt"])
+	rawSrc, found := img.Attrs()["src"]
+	if !found {
+		return Media{}, fmt.Errorf("media block %s has no source URL", img.HTML())
+	}
+	src, err := url.QueryUnescape(rawSrc)
+	if err != nil {
+		return Media{}, fmt.Errorf("while unescaping media source URL %q: %w", rawSrc, err)
+	}
+	src, attributes.Page = ExtractPageFromSource(src)
+	return Media{
+		Alt:            alt,
+		Caption:        img.Attrs()["title"],
+		RelativeSource: FilePathInsidePortfolioFolder(src),
+		Attributes:     attributes,
+	}, nil
+}
+
+func detectParagraph(ctx *RunContext, element soup.Root) (ContentBlock, bool, error) {
+	if !stringInSlice(strings.Fields("p ol ul h2 h3 h4 h5 h6 dl blockquote hr pre"), element.NodeValue) {
+		return ContentBlock{}, false, nil
+	}
+	return ContentBlock{
+		Anchor: element.Attrs()["id"],
+		Paragraph: Paragraph{
+			Content: HTMLString(element.HTML()),
+		},
+	}, true, nil
+}
+
+func detectMedia(ctx *RunContext, element soup.Root) (ContentBlock, bool, error) {
+	children := element.Children()
+	if element.NodeValue != "p" || len(children) != 1 || children[0].NodeValue != "img" {
+		return ContentBlock{}, false, nil
+	}
+	media, err := mediaFromImage(children[0])
+	if err != nil {
+		return ContentBlock{}, false, err
+	}
+	return ContentBlock{
+		Anchor: slugify.Marshal(string(media.RelativeSource)),
+		Media:  media,
+	}, true, nil
+}
+
+func detectLink(ctx *RunContext, element soup.Root) (ContentBlock, bool, error) {
+	children := element.Children()
+	if element.NodeValue != "p" || len(children) != 1 || children[0].NodeValue != "a" {
+		return ContentBlock{}, false, nil
+	}
+	a := children[0]
+	block := ContentBlock{
+		Anchor: slugify.Marshal(a.FullText(), true),
+		Link: Link{
+			Text:  innerHTML(a),
+			Title: a.Attrs()["title"],
+			URL:   a.Attrs()["href"],
+		},
+	}
+	if block.URL == "" {
+		return ContentBlock{}, false, fmt.Errorf("link block %s has no URL", a.HTML())
+	}
+	return block, true, nil
+}
+
+func detectHeading(ctx *RunContext, element soup.Root) (ContentBlock, bool, error) {
+	if !stringInSlice([]string{"h2", "h3", "h4", "h5", "h6"}, element.NodeValue) {
+		return ContentBlock{}, false, nil
+	}
+	anchor := element.Attrs()["id"]
+	if anchor == "" {
+		anchor = slugify.Marshal(element.FullText(), true)
+	}
+	return ContentBlock{
+		Anchor:    anchor,
+		Paragraph: Paragraph{Content: trimHTMLWhitespace(innerHTML(element))},
+		Heading:   Heading{Level: int(element.NodeValue[1] - '0')},
+	}, true, nil
+}
+
+func detectCode(ctx *RunContext, element soup.Root) (ContentBlock, bool, error) {
+	if element.NodeValue != "pre" {
+		return ContentBlock{}, false, nil
+	}
+	block := ContentBlock{
+		Anchor:    element.Attrs()["id"],
+		CodeBlock: CodeBlock{Code: strings.TrimSuffix(element.FullText(), "\n")},
+	}
+	// Only present when the listing was not syntax-highlighted. Otherwise, see fillCodeLanguages
+	for _, code := range element.FindAll("code") {
+		for _, class := range strings.Fields(code.Attrs()["class"]) {
+			if strings.HasPrefix(class, "language-") {
+				block.CodeLanguage = strings.TrimPrefix(class, "language-")
+			}
+		}
+	}
+	return block, true, nil
+}
+
+func detectQuote(ctx *RunContext, element soup.Root) (ContentBlock, bool, error) {
+	if element.NodeValue != "blockquote" {
+		return ContentBlock{}, false, nil
+	}
+	children := element.Children()
+	block := ContentBlock{Anchor: element.Attrs()["id"]}
+
+	// Find the citation, if any
+	paragraphs := elementChildren(element)
+	if len(paragraphs) > 0 {
+		last := paragraphs[len(paragraphs)-1]
+		citation := strings.TrimSpace(last.FullText())
+		for _, dash := range []string{"—", "–", "―", "--"} {
+			if last.NodeValue == "p" && strings.HasPrefix(citation, dash) {
+				block.Citation = strings.TrimSpace(strings.TrimPrefix(citation, dash))
+				children = children[:indexOfNode(children, last)]
+				break
+			}
+		}
+	}
+
+	var content string
+	for _, child := range children {
+		content += child.HTML()
+	}
+	block.Content = trimHTMLWhitespace(HTMLString(content))
+	return block, true, nil
+}
+
+func indexOfNode(nodes []soup.Root, node soup.Root) int {
+	for i, other := range nodes {
+		if other.Pointer == node.Pointer {
+			return i
+		}
+	}
+	return len(nodes)
+}
+
+func detectGallery(ctx *RunContext, element soup.Root) (ContentBlock, bool, error) {
+	if element.NodeValue != "p" {
+		return ContentBlock{}, false, nil
+	}
+	images := make([]soup.Root, 0)
+	for _, child := range element.Children() {
+		if child.NodeValue == "img" {
+			images = append(images, child)
+		} else if !isBlank(child) {
+			return ContentBlock{}, false, nil
+		}
+	}
+	if len(images) < 2 {
+		return ContentBlock{}, false, nil
+	}
+
+	block := ContentBlock{Anchor: element.Attrs()["id"]}
+	for _, img := range images {
+		media, err := mediaFromImage(img)
+		if err != nil {
+			return ContentBlock{}, false, err
+		}
+		block.Items = append(block.Items, media)
+	}
+	return block, true, nil
+}
+
+func detectEmbed(ctx *RunContext, element soup.Root) (ContentBlock, bool, error) {
+	target := element
+	if children := element.Children(); element.NodeValue == "p" && len(children) == 1 {
+		target = children[0]
+	}
+
+	switch target.NodeValue {
+	case "iframe":
+		src := target.Attrs()["src"]
+		if src == "" {
+			return ContentBlock{}, false, fmt.Errorf("embed block %s has no source URL", target.HTML())
+		}
+		return ContentBlock{
+			Anchor: slugify.Marshal(src),
+			Link:   Link{Title: target.Attrs()["title"], URL: src},
+			Embed:  Embed{Provider: "iframe", EmbedURL: src},
+		}, true, nil
+	case "img":
+		src := target.Attrs()["src"]
+		provider, embedURL, ok := embedURLOf(src)
+		if !ok {
+			return ContentBlock{}, false, nil
+		}
+		return ContentBlock{
+			Anchor: slugify.Marshal(src),
+			Link:   Link{Text: HTMLString(target.Attrs()["alt"]), Title: target.Attrs()["title"], URL: src},
+			Embed:  Embed{Provider: provider, EmbedURL: embedURL},
+		}, true, nil
+	}
+	return ContentBlock{}, false, nil
+}
+
+var embeddableVideoID = regexp.MustCompile(`^[\w-]+$`)
+
+// embedURLOf returns the provider and the URL to embed in an <iframe> for the given YouTube or Vimeo video URL.
+// ok is false if rawURL is not the URL of a video from one of those.
+func embedURLOf(rawURL string) (provider string, embedURL string, ok bool) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return "", "", false
+	}
+	host := strings.TrimPrefix(strings.TrimPrefix(parsed.Hostname(), "www."), "m.")
+	segments := strings.Split(strings.Trim(parsed.Path, "/"), "/")
+
+	var id string
+	switch host {
+	case "youtube.com", "youtube-nocookie.com":
+		provider = "youtube"
+		if segments[0] == "watch" {
+			id = parsed.Query().Get("v")
+		} else if len(segments) == 2 && (segments[0] == "embed" || segments[0] == "shorts") {
+			id = segments[1]
+		}
+	case "youtu.be":
+		provider = "youtube"
+		id = segments[0]
+	case "vimeo.com":
+		provider = "vimeo"
+		id = segments[0]
+	case "player.vimeo.com":
+		provider = "vimeo"
+		if len(segments) == 2 && segments[0] == "video" {
+			id = segments[1]
+		}
+	}
+
+	if !embeddableVideoID.MatchString(id) {
+		return "", "", false
+	}
+	switch provider {
+	case "youtube":
+		return provider, "https://www.youtube-nocookie.com/embed/" + id, true
+	case "vimeo":
+		if strings.IndexFunc(id, func(r rune) bool { return !unicode.IsDigit(r) }) != -1 {
+			return "", "", false
+		}
+		return provider, "https://player.vimeo.com/video/" + id, true
+	}
+	return "", "", false
+}
+
+type codeListing struct {
+	language string
+	code     string
+}
+
+// codeListings returns the top-level code listings in the given markdown, with their declared language.
+// Syntax highlighting drops the language from the HTML, so we get it from the markdown itself.
+func codeListings(markdownRaw string) []codeListing {
+	source := []byte(markdownRaw)
+	listings := make([]codeListing, 0)
+	document := markdownParser.Parser().Parse(text.NewReader(source))
+	for node := document.FirstChild(); node != nil; node = node.NextSibling() {
+		var listing codeListing
+		switch node := node.(type) {
+		case *ast.FencedCodeBlock:
+			listing.language = string(node.Language(source))
+		case *ast.CodeBlock:
+		default:
+			continue
+		}
+		lines := node.Lines()
+		for i := 0; i < lines.Len(); i++ {
+			segment := lines.At(i)
+			listing.code += string(segment.Value(source))
+		}
+		listings = append(listings, listing)
+	}
+	return listings
+}
+
+// fillCodeLanguages sets the language and exact code of code blocks from the code listings they were rendered from.
+func fillCodeLanguages(blocks []ContentBlock, listings []codeListing) {
+	used := make([]bool, len(listings))
+	for i, block := range blocks {
+		if !block.Type.IsCode() {
+			continue
+		}
+		for j, listing := range listings {
+			if used[j] || strings.TrimSpace(listing.code) != strings.TrimSpace(block.Code) {
+				continue
+			}
+			used[j] = true
+			blocks[i].Code = strings.TrimSuffix(listing.code, "\n")
+			if listing.language != "" {
+				blocks[i].CodeLanguage = listing.language
+			}
+			break
+		}
+	}
+}
+
+func replicateHeading(ctx *RunContext, block ContentBlock) (string, error) {
+	return strings.Repeat("#", block.Level) + " " + block.Content.Markdown(), nil
+}
+
+func replicateCode(ctx *RunContext, block ContentBlock) (string, error) {
+	// The fence needs to be longer than any run of backticks in the code
+	fence := "```"
+	for strings.Contains(block.Code, fence) {
+		fence += "`"
+	}
+	return fence + block.CodeLanguage + "\n" + block.Code + "\n" + fence, nil
+}
+
+func replicateQuote(ctx *RunContext, block ContentBlock) (string, error) {
+	lines := strings.Split(block.Content.Markdown(), "\n")
+	if block.Citation != "" {
+		lines = append(lines, "", "— "+block.Citation)
+	}
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace("> " + line)
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+func replicateEmbed(ctx *RunContext, block ContentBlock) (string, error) {
+	if block.Provider == "iframe" {
+		if block.Link.Title != "" {
+			return fmt.Sprintf(`<iframe src="%s" title="%s"></iframe>`, html.EscapeString(block.URL), html.EscapeString(block.Link.Title)), nil
+		}
+		return fmt.Sprintf(`<iframe src="%s"></iframe>`, html.EscapeString(block.URL)), nil
+	}
+	if block.Link.Title != "" {
+		return fmt.Sprintf(`![%s](%s "%s")`, block.Text, block.URL, block.Link.Title), nil
+	}
+	return fmt.Sprintf(`![%s](%s)`, block.Text, block.URL), nil
+}
+
+func replicateGallery(ctx *RunContext, block ContentBlock) (string, error) {
+	embeds := make([]string, 0, len(block.Items))
+	for _, media := range block.Items {
+		embeds = append(embeds, ctx.replicateMediaEmbed(media))
+	}
+	return strings.Join(embeds, "\n"), nil
+}
@@ -4,14 +4,16 @@
 package ortfodb
 
 import (
-	"crypto/md5"
-	"encoding/base64"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io/fs"
+	"maps"
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
@@ -157,11 +159,10 @@ func (ctx *RunContext) PreviouslyBuiltMedia(workID string, embedDeclaration Medi
 	}
 	for _, localizedContent := range work.Content {
 		for _, block := range localizedContent.Blocks {
-			if block.Type != "media" {
-				continue
-			}
-			if block.Media.RelativeSource == embedDeclaration.RelativeSource {
-				return block.Media, work, true
+			for _, blockMedia := range block.Mediae() {
+				if blockMedia.RelativeSource == embedDeclaration.RelativeSource {
+					return blockMedia, work, true
+				}
 			}
 		}
 	}
@@ -180,12 +181,18 @@ type RunContext struct {
 	Flags                 Flags
 	ProgressInfoFile      string
 	Exporters             []Exporter
+	// Changes of the database (of the build profile) since the previous build, set once BuildSome is done.
+	Changes Changeset
 
 	// Number of concurrent goroutines to use to create thumbnails per work
 	thumbnailersPerWork int
 
 	TagsRepository         []Tag
 	TechnologiesRepository []Technology
+
+	remoteMediaOnce  sync.Once
+	remoteMediaCache *remoteMediaCache
+	remoteMediaErr   error
 }
 
 type Flags struct {
@@ -197,6 +204,10 @@ type Flags struct {
 	WorkersCount     int
 	ProgressInfoFile string
 	ExportersToUse   []string
+	Watch            bool
+	Offline          bool
+	Profile          string
+	Environment      string
 }
 
 // Project represents a project.
@@ -225,6 +236,22 @@ func AcquireBuildLock(outputFilename string) error {
 	}
 }
 
+// BuildCacheFilepath returns the path to the file that keeps every built work for the given output database file, when its profile leaves some of them out.
+// It is used instead of the database file as the cache of the next build.
+// The file is in the user's cache directory, and not next to the database file: that directory is usually deployed, and the file has the works the profile left out, such as private ones.
+func BuildCacheFilepath(outputFilename string) (string, error) {
+	absoluteOutputFilename, err := filepath.Abs(outputFilename)
+	if err != nil {
+		return "", fmt.Errorf("while getting the absolute path of %s: %w", outputFilename, err)
+	}
+	userCache, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("while getting the user's cache directory: %w", err)
+	}
+	hash := sha256.Sum256([]byte(absoluteOutputFilename))
+	return filepath.Join(userCache, "ortfodb", "builds", hex.EncodeToString(hash[:8])+"-"+filepath.Base(outputFilename)), nil
+}
+
 func ReleaseBuildLock(outputFilename string) error {
 	err := os.Remove(BuildLockFilepath(outputFilename))
 	if err != nil {
@@ -246,14 +273,14 @@ func PrepareBuild(databaseDirectory string, outputFilename string, flags Flags,
 		},
 	}
 
-	thumbnailSizesCount := len(ctx.Config.MakeThumbnails.Sizes)
+	thumbnailSizesCount := len(ctx.Config.MakeThumbnails.Sizes) * len(ctx.ThumbnailFormats())
 
 	if thumbnailSizesCount/2 > flags.WorkersCount {
 		ll.Debug("ThumbnailSizesCount/2 (%d) > flags.WorkersCount (%d). Using 2 thumbnailers per work.", thumbnailSizesCount/2, flags.WorkersCount)
 		ctx.thumbnailersPerWork = 2
 	} else {
-		ll.Debug("Configuration asks for %d thumbnail sizes. setting thumbnail workers count per work to half of that.", thumbnailSizesCount)
-		ctx.thumbnailersPerWork = thumbnailSizesCount / 2
+		ll.Debug("Configuration asks for %d thumbnails (sizes × formats) per media. setting thumbnail workers count per work to half of that.", thumbnailSizesCount)
+		ctx.thumbnailersPerWork = max(1, thumbnailSizesCount/2)
 	}
 
 	ll.Debug("Using %d thumbnailers threads per work", ctx.thumbnailersPerWork)
@@ -265,6 +292,10 @@ func PrepareBuild(databaseDirectory string, outputFilename string, flags Flags,
 		}
 	}
 
+	if _, err := config.Profile(flags.Profile); err != nil {
+		return &ctx, err
+	}
+
 	exportersToUse := flags.ExportersToUse
 	if len(exportersToUse) == 0 {
 		exportersToUse = mapKeys(config.Exporters)
@@ -281,16 +312,21 @@ func PrepareBuild(databaseDirectory string, outputFilename string, flags Flags,
 
 	ll.Debug("Running with configuration %#v", &config)
 
-	previousBuiltDatabaseRaw, err := os.ReadFile(outputFilename)
+	// Works left out of the database file by its profile are kept in the build cache file
+	previousBuiltDatabaseFilename := outputFilename
+	if buildCacheFilename, err := BuildCacheFilepath(outputFilename); err == nil && fileExists(outputFilename) && fileExists(buildCacheFilename) {
+		previousBuiltDatabaseFilename = buildCacheFilename
+	}
+	previousBuiltDatabaseRaw, err := os.ReadFile(previousBuiltDatabaseFilename)
 	if err != nil {
 		if !os.IsNotExist(err) {
-			ll.ErrorDisplay("No previously built database file %s to use", err, outputFilename)
+			ll.ErrorDisplay("No previously built database file %s to use", err, previousBuiltDatabaseFilename)
 		}
 	} else {
 		previousDb := Database{}
 		err = json.Unmarshal(previousBuiltDatabaseRaw, &previousDb)
 		if err != nil {
-			ll.ErrorDisplay("Couldn't use previous built database file %s", err, outputFilename)
+			ll.ErrorDisplay("Couldn't use previous built database file %s", err, previousBuiltDatabaseFilename)
 		}
 		ctx.previousBuiltDatabase = PreviouslyBuiltDatabase{Database: previousDb}
 	}
@@ -351,6 +387,10 @@ func (ctx *RunContext) RunExporters(work *Work) error {
 		if debugging {
 			ll.Log("Exporting", "magenta", "%s to %s", work.ID, exporter.Name())
 		}
+		if profile := ctx.ExporterProfile(exporter); profile != nil && !profile.Includes(*work, time.Now()) {
+			ll.Debug("Not exporting %s to %s: not part of its profile", work.ID, exporter.Name())
+			continue
+		}
 		options := ctx.Config.Exporters[exporter.Name()]
 		err := exporter.Export(ctx, options, work)
 		if err != nil {
@@ -360,8 +400,13 @@ func (ctx *RunContext) RunExporters(work *Work) error {
 	return nil
 }
 
+// BuildSome builds the works matching the include pattern (a filepath.Match pattern, or "*" for all works).
+// The build lock is released and exporters are closed (see CloseExporters) once the build is done, except when flags.Watch is set: they are then kept for Watch.
 func (ctx *RunContext) BuildSome(include string, databaseDirectory string, outputFilename string, flags Flags, config Configuration) (Database, error) {
-	defer ReleaseBuildLock(outputFilename)
+	if !flags.Watch {
+		defer ReleaseBuildLock(outputFilename)
+		defer ctx.CloseExporters()
+	}
 
 	type builtItem struct {
 		err      error
@@ -372,6 +417,8 @@ func (ctx *RunContext) BuildSome(include string, databaseDirectory string, outpu
 
 	// Initialize stuff
 	works := ctx.PreviouslyBuiltDatabase()
+	// works is updated as works are built, keep the previous database around to compute changes
+	previous := maps.Clone(works)
 	// ll.Debug("initialized works@%p from previous@%p", works, ctx.previousBuiltDatabase.Database)
 	workDirectories, err := ctx.ComputeProgressTotal()
 	if err != nil {
@@ -407,15 +454,10 @@ func (ctx *RunContext) BuildSome(include string, databaseDirectory string, outpu
 				workID := dirEntry.Name()
 				ll.Debug("worker #%d: starting with work %s", i, workID)
 				_, presentBefore := ctx.PreviouslyBuiltWork(workID)
-				var included bool
-				if include == "*" {
-					included = true
-				} else {
-					included, err = filepath.Match(include, workID)
-					if err != nil {
-						builtChannel <- builtItem{err: fmt.Errorf("while testing include-works pattern %q: %w", include, err)}
-						continue
-					}
+				included, err := matchesIncludePattern(include, workID)
+				if err != nil {
+					builtChannel <- builtItem{err: err}
+					continue
 				}
 				if included {
 					// Get description file name
@@ -487,26 +529,65 @@ func (ctx *RunContext) BuildSome(include string, databaseDirectory string, outpu
 		ll.Debug("main: left to build: %v", directoriesLeftToBuild(workDirectoriesNames, builtDirectories))
 	}
 
+	// Works whose directory was removed are not part of the database anymore
+	ctx.previousBuiltDatabase.mu.Lock()
+	for id := range works {
+		if !slices.Contains(workDirectoriesNames, id) {
+			ll.Debug("main: removing work %s, its directory does not exist anymore", id)
+			delete(works, id)
+		}
+	}
+	ctx.previousBuiltDatabase.mu.Unlock()
+
+	ctx.RunAfterHooks(previous, works)
+
+	return works, nil
+}
+
+// RunAfterHooks sets ctx.Changes to the changes from previous to works, and runs the After hook of every exporter (AfterChanges for exporters that implement ChangesetExporter) with the works of its profile.
+// Errors are displayed but do not stop the other exporters.
+func (ctx *RunContext) RunAfterHooks(previous Database, works Database) {
+	ctx.Changes = ComputeChangeset(previous.InProfile(ctx.BuildProfile()), works.InProfile(ctx.BuildProfile()))
+	ll.Debug("Changes since the previous build: %#v", ctx.Changes)
+
 	for _, exporter := range ctx.Exporters {
 		options := ctx.Config.Exporters[exporter.Name()]
 		ll.Debug("Running exporter %s's after hook with options %#v", exporter.Name(), options)
-		err := exporter.After(ctx, options, &works)
+		profile := ctx.ExporterProfile(exporter)
+		exported := works.InProfile(profile)
+		var err error
+		if changesetExporter, ok := exporter.(ChangesetExporter); ok {
+			err = changesetExporter.AfterChanges(ctx, options, &exported, ComputeChangeset(previous.InProfile(profile), exported))
+		} else {
+			err = exporter.After(ctx, options, &exported)
+		}
 		if err != nil {
 			ll.ErrorDisplay("while running exporter %s's after hook: %s", err, exporter.Name())
 		}
 
 	}
+}
 
-	return works, nil
+// matchesIncludePattern returns true if workID is matched by the include-works pattern include.
+func matchesIncludePattern(include string, workID string) (bool, error) {
+	if include == "*" {
+		return true, nil
+	}
+	included, err := filepath.Match(include, workID)
+	if err != nil {
+		return false, fmt.Errorf("while testing include-works pattern %q: %w", include, err)
+	}
+	return included, nil
 }
 
 func (ctx *RunContext) WriteDatabase(works Database, flags Flags, outputFilename string, partial bool) {
 	ll.Debug("Writing database (partial=%v) to %s", partial, outputFilename)
-	worksWithDatabaseMetadata := make(Database, 0)
+	allWorksWithDatabaseMetadata := make(Database, 0)
 	for id, work := range works {
 		work.Metadata.DatabaseMetadata = DatabaseMeta{Partial: partial}
-		worksWithDatabaseMetadata[id] = work
+		allWorksWithDatabaseMetadata[id] = work
 	}
+	worksWithDatabaseMetadata := allWorksWithDatabaseMetadata.InProfile(ctx.BuildProfile())
 
 	// Compile the database
 	var worksJSON []byte
@@ -525,6 +606,32 @@ func (ctx *RunContext) WriteDatabase(works Database, flags Flags, outputFilename
 		if err != nil {
 			println(err.Error())
 		}
+		ctx.writeBuildCache(allWorksWithDatabaseMetadata, len(worksWithDatabaseMetadata) < len(allWorksWithDatabaseMetadata), outputFilename)
+	}
+}
+
+// writeBuildCache writes every work to the build cache file if the profile left some of them out of the database file, so that they are not rebuilt by the next build. Otherwise, the database file is the cache, and the build cache file is removed.
+func (ctx *RunContext) writeBuildCache(works Database, needed bool, outputFilename string) {
+	buildCacheFilename, err := BuildCacheFilepath(outputFilename)
+	if err != nil {
+		ll.ErrorDisplay("could not find where to write the build cache file, works left out by the profile will be rebuilt every time", err)
+		return
+	}
+	if !needed {
+		if err := os.Remove(buildCacheFilename); err != nil && !os.IsNotExist(err) {
+			ll.ErrorDisplay("could not remove build cache file %s", err, buildCacheFilename)
+		}
+		return
+	}
+	worksJSON, err := jsoniter.ConfigFastest.Marshal(works)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(buildCacheFilename), 0o755)
+	}
+	if err == nil {
+		err = writeFile(buildCacheFilename, worksJSON)
+	}
+	if err != nil {
+		ll.ErrorDisplay("could not write build cache file %s", err, buildCacheFilename)
 	}
 }
 
@@ -591,8 +698,7 @@ func (ctx *RunContext) DescriptionFilename(databaseDirectory string, workID stri
 // Build builds a single work given the database & output folders, as wells as a work ID.
 // BuiltAt is set and DescriptionHash are set.
 func (ctx *RunContext) Build(descriptionRaw string, outputFilename string, workID string) (work Work, usedCache bool, err error) {
-	hash := md5.Sum([]byte(descriptionRaw))
-	newDescriptionHash := base64.StdEncoding.EncodeToString(hash[:])
+	newDescriptionHash := DescriptionHash(descriptionRaw)
 
 	if oldWork, found := ctx.PreviouslyBuiltWork(workID); found && oldWork.DescriptionHash == newDescriptionHash && !ctx.Flags.NoCache {
 		ll.Debug("parsing description for %s: using cached work", workID)
@@ -607,10 +713,28 @@ func (ctx *RunContext) Build(descriptionRaw string, outputFilename string, workI
 		work.DescriptionHash = newDescriptionHash
 	}
 
+	if _, err := work.Metadata.PublishDate(); err != nil {
+		return Work{}, false, fmt.Errorf("while reading metadata of %s: %w", workID, err)
+	}
+
 	// Handle mediae
 	analyzedMediae := make([]Media, 0)
 	for lang, localizedContent := range work.Content {
 		for i, block := range localizedContent.Blocks {
+			if block.Type.IsGallery() {
+				for j, item := range block.Items {
+					ll.Debug("Handling gallery item %#v", item)
+					analyzed, _, usedCacheForMedia, err := ctx.HandleMedia(workID, fmt.Sprintf("%s-%d", block.ID, j+1), item, lang)
+					if err != nil {
+						return Work{}, false, err
+					}
+
+					usedCache = usedCache && usedCacheForMedia
+					work.Content[lang].Blocks[i].Items[j] = analyzed
+					analyzedMediae = append(analyzedMediae, analyzed)
+				}
+				continue
+			}
 			if block.Type != "media" {
 				continue
 			}
@@ -0,0 +1,139 @@
+package ortfodb
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+// Changeset describes how the database changed since the previous build.
+// Works are referred to by their ID.
+type Changeset struct {
+	Added     []string `json:"added"`
+	Modified  []string `json:"modified"`
+	Removed   []string `json:"removed"`
+	Unchanged []string `json:"unchanged"`
+	// Files of the media directory that were added or changed: media files, and the thumbnails, animated previews, cover art and waveform images made from them.
+	ChangedMedia []FilePathInsideMediaRoot `json:"changedMedia"`
+	// Files of the media directory that the database does not refer to anymore.
+	RemovedMedia []FilePathInsideMediaRoot `json:"removedMedia"`
+}
+
+// ChangesetExporter is implemented by exporters that want to know what changed since the previous build, for example to only upload changed works and media files.
+type ChangesetExporter interface {
+	Exporter
+	// AfterChanges is called instead of After, with the changes of the works the exporter receives.
+	AfterChanges(ctx *RunContext, opts ExporterOptions, built *Database, changes Changeset) error
+}
+
+// Empty returns true if nothing changed.
+func (c Changeset) Empty() bool {
+	return len(c.Added) == 0 && len(c.Modified) == 0 && len(c.Removed) == 0 && len(c.ChangedMedia) == 0 && len(c.RemovedMedia) == 0
+}
+
+// Changed returns the IDs of works that were added or modified.
+func (c Changeset) Changed() []string {
+	return append(slices.Clone(c.Added), c.Modified...)
+}
+
+// ComputeChangeset compares the current database to the previous one.
+// Works are modified when anything but their build date changed.
+func ComputeChangeset(previous Database, current Database) Changeset {
+	changes := Changeset{
+		Added:        make([]string, 0),
+		Modified:     make([]string, 0),
+		Removed:      make([]string, 0),
+		Unchanged:    make([]string, 0),
+		ChangedMedia: make([]FilePathInsideMediaRoot, 0),
+		RemovedMedia: make([]FilePathInsideMediaRoot, 0),
+	}
+
+	for id, work := range current {
+		previousWork, found := previous[id]
+		switch {
+		case !found:
+			changes.Added = append(changes.Added, id)
+		case sameWork(previousWork, work):
+			changes.Unchanged = append(changes.Unchanged, id)
+		default:
+			changes.Modified = append(changes.Modified, id)
+		}
+	}
+	for id := range previous {
+		if _, found := current[id]; !found {
+			changes.Removed = append(changes.Removed, id)
+		}
+	}
+
+	previousFiles, currentFiles := mediaFileVersions(previous), mediaFileVersions(current)
+	for file, version := range currentFiles {
+		if previousVersion, found := previousFiles[file]; !found || previousVersion != version {
+			changes.ChangedMedia = append(changes.ChangedMedia, file)
+		}
+	}
+	for file := range previousFiles {
+		if _, found := currentFiles[file]; !found {
+			changes.RemovedMedia = append(changes.RemovedMedia, file)
+		}
+	}
+
+	for _, ids := range [][]string{changes.Added, changes.Modified, changes.Removed, changes.Unchanged} {
+		slices.Sort(ids)
+	}
+	slices.Sort(changes.ChangedMedia)
+	slices.Sort(changes.RemovedMedia)
+	return changes
+}
+
+// sameWork returns true if the works only differ by their build date.
+func sameWork(a Work, b Work) bool {
+	for _, work := range []*Work{&a, &b} {
+		work.BuiltAt = time.Time{}
+		work.Partial = false
+		work.Metadata.DatabaseMetadata = DatabaseMeta{}
+	}
+	json := jsoniter.ConfigCompatibleWithStandardLibrary
+	encodedA, errA := json.Marshal(a)
+	encodedB, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(encodedA, encodedB)
+}
+
+// mediaFileVersions returns the files of the media directory the database refers to, with a string that changes when the file changes.
+func mediaFileVersions(db Database) map[FilePathInsideMediaRoot]string {
+	versions := make(map[FilePathInsideMediaRoot]string)
+	add := func(file FilePathInsideMediaRoot, version string) {
+		if file != "" {
+			versions[file] = version
+		}
+	}
+	for _, work := range db {
+		for _, content := range work.Content {
+			for _, block := range content.Blocks {
+				for _, media := range block.Mediae() {
+					add(media.DistSource, media.Hash)
+					// Files made from the media change when it does, or when they are made again
+					derived := fmt.Sprintf("%s@%s", media.Hash, media.ThumbnailsBuiltAt.Format(time.RFC3339Nano))
+					for _, thumbnail := range media.Thumbnails {
+						add(thumbnail, derived)
+					}
+					for _, sources := range media.ThumbnailSources {
+						for _, thumbnail := range sources {
+							add(thumbnail, derived)
+						}
+					}
+					for _, gif := range media.GIFs {
+						add(gif, derived)
+					}
+					if media.Audio != nil {
+						add(media.Audio.Cover, media.Hash)
+					}
+					add(media.WaveformImage, fmt.Sprintf("%s@%d", media.Hash, len(media.Waveform)))
+				}
+			}
+		}
+	}
+	return versions
+}
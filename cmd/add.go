@@ -25,7 +25,7 @@ var addCmd = &cobra.Command{
 	Long:  heredoc.Doc(`Create a new project in the appropriate folder. ID is the work's slug.`),
 	Args:  cobra.MinimumNArgs(1),
 	ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
-		config, err := ortfodb.NewConfiguration(flags.Config)
+		config, err := ortfodb.NewConfigurationForEnvironment(flags.Config, flags.Environment)
 		if err != nil {
 			handleError(err)
 		}
@@ -43,7 +43,7 @@ var addCmd = &cobra.Command{
 		return validWorkIds, cobra.ShellCompDirectiveNoFileComp
 	},
 	Run: func(cmd *cobra.Command, args []string) {
-		config, err := ortfodb.NewConfiguration(flags.Config)
+		config, err := ortfodb.NewConfigurationForEnvironment(flags.Config, flags.Environment)
 		if err != nil {
 			handleError(err)
 		}
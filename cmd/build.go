@@ -15,15 +15,25 @@ func init() {
 	buildCmd.PersistentFlags().StringVar(&flags.ProgressInfoFile, "write-progress", "", "Write progress information to a file. See https://pkg.go.dev/github.com/ortfo/db#ProgressInfoEvent for more information.")
 	buildCmd.PersistentFlags().BoolVar(&flags.NoCache, "no-cache", false, "Disable usage of previous database build as cache for this build (used for media analysis among other things).")
 	buildCmd.PersistentFlags().IntVar(&flags.WorkersCount, "workers", runtime.NumCPU(), "Choose the number of workers to build the database. Defaults to the number of CPU cores.")
+	buildCmd.PersistentFlags().BoolVarP(&flags.Watch, "watch", "w", false, "Keep running after the build, and rebuild works as their description files or media change.")
+	buildCmd.PersistentFlags().BoolVar(&flags.Offline, "offline", false, "Don't download remote media (with an http:// or https:// source): only use the ones in the remote media cache.")
 	buildCmd.PersistentFlags().StringArrayVarP(&flags.ExportersToUse, "exporters", "e", []string{}, "Exporters to enable. If not provided, all the exporters configured in the configuration file will be enabled.")
 	buildCmd.RegisterFlagCompletionFunc("exporters", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
-		config, err := ortfodb.NewConfiguration(flags.Config)
+		config, err := ortfodb.NewConfigurationForEnvironment(flags.Config, flags.Environment)
 		if err != nil {
 			handleError(err)
 		}
 		//TODO omit already enabled exporters
 		return keys(config.Exporters), cobra.ShellCompDirectiveNoFileComp
 	})
+	buildCmd.PersistentFlags().StringVar(&flags.Profile, "profile", "", "Only include works of this profile (defined in the configuration file) in the database file. Exporters receive works of this profile too, unless they declare their own profile.")
+	buildCmd.RegisterFlagCompletionFunc("profile", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		config, err := ortfodb.NewConfigurationForEnvironment(flags.Config, flags.Environment)
+		if err != nil {
+			handleError(err)
+		}
+		return keys(config.Profiles), cobra.ShellCompDirectiveNoFileComp
+	})
 	rootCmd.AddCommand(buildCmd)
 }
 
@@ -35,11 +45,15 @@ var buildCmd = &cobra.Command{
 	If to-filepath is "-", the output will be written to stdout.
 
 	If include-works is provided, only works that match the pattern will be included in the database.
+
+	With --profile, only the works of that profile are included in the database file: see the profiles configuration option.
+
+	With --watch, the projects directory is watched after the build: works are rebuilt (and exported) as soon as their description file or one of their media files changes. The build lock is held until ortfodb is stopped.
 	`),
 	Args: cobra.RangeArgs(1, 2),
 	Run: func(cmd *cobra.Command, args []string) {
 		outputFilename := args[0]
-		config, err := ortfodb.NewConfiguration(flags.Config)
+		config, err := ortfodb.NewConfigurationForEnvironment(flags.Config, flags.Environment)
 		if err != nil {
 			handleError(err)
 		}
@@ -65,6 +79,10 @@ var buildCmd = &cobra.Command{
 			context.WriteDatabase(works, flags, outputFilename, err != nil)
 		}
 
+		if flags.Watch && err == nil {
+			err = context.Watch(works, includeWorksPattern, outputFilename, flags, make(chan struct{}))
+		}
+
 		releaseLockFileSafe(context, outputFilename)
 
 		if err != nil {
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/MakeNowJust/heredoc"
+	ortfodb "github.com/ortfo/db"
+	"github.com/spf13/cobra"
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect the configuration",
+}
+
+var configShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Show the effective configuration",
+	Long: heredoc.Doc(`Show the configuration that is used, once the files it extends and includes are merged, the environment overlay (see --env) is applied and environment variables are replaced.
+
+	Each value is followed by a comment telling where it comes from: a configuration file, with the environment it was set in and the environment variables it uses, or "default".
+	`),
+	Example: heredoc.Doc(`
+	$ ortfodb config show
+	$ ortfodb --env production config show`),
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		config, err := ortfodb.NewConfigurationForEnvironment(flags.Config, flags.Environment)
+		if err != nil {
+			handleError(fmt.Errorf("while loading configuration: %w", err))
+		}
+
+		annotated, err := config.AnnotatedYAML()
+		handleError(err)
+		fmt.Print(annotated)
+	},
+}
+
+func init() {
+	configCmd.AddCommand(configShowCmd)
+	rootCmd.AddCommand(configCmd)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/MakeNowJust/heredoc"
+	ll "github.com/ewen-lbh/label-logger-go"
+	ortfodb "github.com/ortfo/db"
+	"github.com/spf13/cobra"
+)
+
+var importCsvCmd = &cobra.Command{
+	Use:   "import-csv <spreadsheet>",
+	Short: "Apply metadata edited in a spreadsheet to description files",
+	Long: heredoc.Doc(`Apply the metadata of a spreadsheet written by the csv exporter (and edited since) back to the YAML header of the works' description files. The rest of the description files is left untouched.
+
+	Only values that differ from the description file are written, and empty cells remove the value. Titles are not imported, as they are part of the description's content.
+
+	Works whose description file changed since the spreadsheet was exported are reported as conflicts and left as-is, unless --force is given. Exits with status 1 if there are conflicts or works that don't exist.
+	`),
+	Example: heredoc.Doc(`
+	$ ortfodb import-csv database.csv
+	$ ortfodb import-csv works.tsv --dry-run`),
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		force, _ := cmd.Flags().GetBool("force")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		format, _ := cmd.Flags().GetString("format")
+		if format == "" {
+			format = ortfodb.SpreadsheetFormatCSV
+			if strings.EqualFold(filepath.Ext(args[0]), ".tsv") {
+				format = ortfodb.SpreadsheetFormatTSV
+			}
+		}
+		delimiter, err := ortfodb.SpreadsheetDelimiter(format)
+		handleError(err)
+
+		config, err := ortfodb.NewConfigurationForEnvironment(flags.Config, flags.Environment)
+		if err != nil {
+			handleError(fmt.Errorf("while loading configuration: %w", err))
+		}
+
+		file, err := os.Open(args[0])
+		if err != nil {
+			handleError(fmt.Errorf("while opening spreadsheet: %w", err))
+		}
+		defer file.Close()
+
+		ctx := ortfodb.RunContext{Config: &config, DatabaseDirectory: config.ProjectsDirectory, Flags: flags}
+		report, err := ctx.ImportSpreadsheet(file, delimiter, force, dryRun)
+		handleError(err)
+
+		verb := "Updated"
+		if dryRun {
+			verb = "Would update"
+		}
+		for _, workID := range report.Updated {
+			ll.Log(verb, "green", "%s", workID)
+		}
+		for _, workID := range report.Conflicts {
+			ll.Log("Conflict", "red", "%s: description changed since the spreadsheet was exported, use --force to overwrite", workID)
+		}
+		for _, workID := range report.Unknown {
+			ll.Log("Unknown", "red", "%s: no such work in %s", workID, config.ProjectsDirectory)
+		}
+		ll.Log("Imported", "cyan", "%d works updated, %d unchanged, %d conflicts, %d unknown", len(report.Updated), len(report.Unchanged), len(report.Conflicts), len(report.Unknown))
+
+		if len(report.Conflicts) > 0 || len(report.Unknown) > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	importCsvCmd.PersistentFlags().Bool("force", false, "Update works whose description file changed since the spreadsheet was exported")
+	importCsvCmd.PersistentFlags().Bool("dry-run", false, "Report what would be updated without writing description files")
+	importCsvCmd.PersistentFlags().String("format", "", "Format of the spreadsheet: csv or tsv. Defaults to tsv for .tsv files, csv otherwise")
+	importCsvCmd.RegisterFlagCompletionFunc("format", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return []string{"csv", "tsv"}, cobra.ShellCompDirectiveNoFileComp
+	})
+	rootCmd.AddCommand(importCsvCmd)
+}
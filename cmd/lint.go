@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/MakeNowJust/heredoc"
+	ll "github.com/ewen-lbh/label-logger-go"
+	ortfodb "github.com/ortfo/db"
+	"github.com/spf13/cobra"
+)
+
+var lintCmd = &cobra.Command{
+	Use:   "lint [works...]",
+	Short: "Check description files for mistakes",
+	Long: heredoc.Doc(`Check the description files of the given works (or of all works in the projects directory) for mistakes that would otherwise only show up during the build, or not at all:
+
+	- invalid YAML header
+	- dates (started, finished, created) that can't be parsed
+	- custom fields (declared in the configuration) that are missing or have invalid values
+	- tags and technologies that are not in their repositories
+	- media files that don't exist
+	- layout references to blocks that don't exist (e.g. m7 when there are only 6 media blocks)
+	- duplicate paragraphs, media or links
+
+	Problems are reported as file:line: message [rule]. Exits with status 1 if any problem is found.
+	`),
+	Example: heredoc.Doc(`
+	$ ortfodb lint
+	$ ortfodb lint my-work another-work --format json`),
+	Run: func(cmd *cobra.Command, args []string) {
+		format, _ := cmd.Flags().GetString("format")
+
+		config, err := ortfodb.NewConfigurationForEnvironment(flags.Config, flags.Environment)
+		if err != nil {
+			handleError(fmt.Errorf("while loading configuration: %w", err))
+		}
+
+		ctx := ortfodb.RunContext{Config: &config, DatabaseDirectory: config.ProjectsDirectory, Flags: flags}
+		problems, err := ctx.Lint(args)
+		handleError(err)
+
+		switch format {
+		case "json":
+			encoded, err := json.MarshalIndent(problems, "", "  ")
+			handleError(err)
+			fmt.Println(string(encoded))
+		case "text":
+			for _, problem := range problems {
+				fmt.Println(problem)
+			}
+			if len(problems) == 0 {
+				ll.Log("Checked", "green", "all descriptions, no problems found")
+			} else {
+				ll.Log("Found", "red", "%d problems", len(problems))
+			}
+		default:
+			handleError(fmt.Errorf("unknown output format %q, must be one of text or json", format))
+		}
+
+		if len(problems) > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	lintCmd.PersistentFlags().StringP("format", "f", "text", "Output format: text or json")
+	lintCmd.RegisterFlagCompletionFunc("format", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return []string{"text", "json"}, cobra.ShellCompDirectiveNoFileComp
+	})
+	rootCmd.AddCommand(lintCmd)
+}
@@ -27,6 +27,7 @@ var flags ortfodb.Flags
 func init() {
 	rootCmd.SetUsageFunc(customUsage)
 	rootCmd.PersistentFlags().StringVarP(&flags.Config, "config", "c", "ortfodb.yaml", "config file path")
+	rootCmd.PersistentFlags().StringVar(&flags.Environment, "env", "", "Configuration environment to use, from the environments of the configuration file. Defaults to the value of the ORTFODB_ENV environment variable.")
 	rootCmd.PersistentFlags().BoolVar(&flags.Scattered, "scattered", false, "Operate in scattered mode. In scattered mode, the description.md files are searched inside `.ortfo' folders in every folder of the database directory, instead of directly in the database directory's folders. See https://github.com/ortfo/")
 }
 
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/MakeNowJust/heredoc"
+	ortfodb "github.com/ortfo/db"
+	"github.com/spf13/cobra"
+)
+
+var queryCmd = &cobra.Command{
+	Use:   "query <database.json> <expression>",
+	Short: "Find works in a built database",
+	Long: heredoc.Doc(`Find works matching expression in the built database file, most recent works first.
+
+	Expressions are made of whitespace-separated terms, that must all match a work for it to be selected. Prefix a term with - to negate it, and use double quotes for values that contain spaces.
+
+	tag:<tag>            works tagged with <tag>
+	madewith:<tech>      works made with <tech> (also tech:<tech>)
+	year<op><year>       works created in, after or before <year>. <op> is one of : = > < >= <=
+	lang:<language>      works that have content in <language>
+	id:<pattern>         works with an ID or alias matching <pattern>, a glob pattern
+	title:<text>         works with a title containing <text>, in any language
+	wip                  works in progress
+	private              private works
+	<text>               works with an ID or title containing <text>
+
+	Tags and technologies are matched using the tags and technologies repositories declared in the configuration file, so aliases work too.
+
+	With a lang: term, JSON output contains works with their content localized to that language.
+	`),
+	Example: heredoc.Doc(`
+	$ ortfodb query database.json tag:book madewith:go year>=2020 -wip lang:fr
+	$ ortfodb query database.json '"hello world"' --format ids`),
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		format, _ := cmd.Flags().GetString("format")
+		skipValidation, _ := cmd.Flags().GetBool("no-verify")
+
+		database, err := ortfodb.LoadDatabase(args[0], skipValidation)
+		if err != nil {
+			handleError(fmt.Errorf("while loading database %s: %w", args[0], err))
+		}
+
+		query, err := ortfodb.ParseQuery(strings.Join(args[1:], " "))
+		if err != nil {
+			handleError(err)
+		}
+
+		config, err := ortfodb.NewConfigurationForEnvironment(flags.Config, flags.Environment)
+		if err != nil {
+			handleError(fmt.Errorf("while loading configuration: %w", err))
+		}
+		ctx := ortfodb.RunContext{Config: &config}
+		if config.Tags.Repository != "" {
+			query.TagsRepository, err = ctx.LoadTagsRepository()
+			handleError(err)
+		}
+		if config.Technologies.Repository != "" {
+			query.TechnologiesRepository, err = ctx.LoadTechnologiesRepository()
+			handleError(err)
+		}
+
+		works := database.Query(query)
+
+		switch format {
+		case "ids":
+			for _, work := range works {
+				fmt.Println(work.ID)
+			}
+		case "json":
+			var output any = works
+			if lang := query.Language(); lang != "" {
+				localized := make([]ortfodb.LocalizedWork, 0, len(works))
+				for _, work := range works {
+					localized = append(localized, work.Localize(lang))
+				}
+				output = localized
+			}
+			encoded, err := json.MarshalIndent(output, "", "  ")
+			handleError(err)
+			fmt.Println(string(encoded))
+		case "table":
+			table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(table, "ID\tTITLE\tYEAR\tTAGS\tMADE WITH")
+			for _, work := range works {
+				year := ""
+				if work.Metadata.Dated() {
+					year = fmt.Sprint(work.Metadata.CreatedAt().Year())
+				}
+				fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\n", work.ID, workTitle(work, query.Language()), year, strings.Join(work.Metadata.Tags, ", "), strings.Join(work.Metadata.MadeWith, ", "))
+			}
+			table.Flush()
+		default:
+			handleError(fmt.Errorf("unknown output format %q, must be one of json, table or ids", format))
+		}
+	},
+}
+
+// workTitle returns the plain-text title of the work in lang. If lang is empty, the default language is used, or the first language (alphabetically) if there's no default.
+func workTitle(work ortfodb.Work, lang string) string {
+	if lang == "" {
+		if _, ok := work.Content["default"]; !ok && len(work.Content) > 0 {
+			languages := keys(work.Content)
+			sort.Strings(languages)
+			lang = languages[0]
+		}
+	}
+	return work.Content.Localize(lang).Title.String()
+}
+
+func init() {
+	queryCmd.PersistentFlags().StringP("format", "f", "table", "Output format: json, table or ids (one work ID per line)")
+	queryCmd.PersistentFlags().BoolP("no-verify", "n", false, "Don't validate the database file against the JSON schema before querying it")
+	queryCmd.RegisterFlagCompletionFunc("format", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return []string{"json", "table", "ids"}, cobra.ShellCompDirectiveNoFileComp
+	})
+	rootCmd.AddCommand(queryCmd)
+}
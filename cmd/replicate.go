@@ -25,7 +25,7 @@ var replicateCmd = &cobra.Command{
 			handleError(fmt.Errorf("while loading given database %s: %w", args[0], err))
 		}
 
-		configuration, err := ortfodb.NewConfiguration(flags.Config)
+		configuration, err := ortfodb.NewConfigurationForEnvironment(flags.Config, flags.Environment)
 		if err != nil {
 			handleError(fmt.Errorf("while loading configuration: %w", err))
 		}
@@ -23,6 +23,7 @@ var schemasCmd = &cobra.Command{
 		- tags: the tags repository file (tags.yaml)
 		- technologies: the technologies repository file (technologies.yaml)
 		- exporter: the manifest file for an exporter
+		- front-matter: the front matter of description files, including the custom fields declared in the configuration file
 	`),
 	ValidArgs: append(ortfodb.AvailableJSONSchemas, "list"),
 	Args:      cobra.MaximumNArgs(1),
@@ -42,6 +43,14 @@ var schemasCmd = &cobra.Command{
 			printSchema(ortfodb.TechnologiesRepositoryJSONSchema())
 		case "exporter":
 			printSchema(ortfodb.ExporterManifestJSONSchema())
+		case "front-matter":
+			config := ortfodb.DefaultConfiguration()
+			// Don't write a default configuration file if there's none
+			if _, err := os.Stat(flags.Config); err == nil {
+				config, err = ortfodb.NewConfigurationForEnvironment(flags.Config, flags.Environment)
+				handleError(err)
+			}
+			printSchema(ortfodb.FrontMatterJSONSchema(config))
 		}
 	},
 }
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/MakeNowJust/heredoc"
+	ll "github.com/ewen-lbh/label-logger-go"
+	ortfodb "github.com/ortfo/db"
+	"github.com/spf13/cobra"
+)
+
+var serveCmd = &cobra.Command{
+	Use:   "serve <database.json>",
+	Short: "Serve a built database as a read-only JSON API",
+	Long: heredoc.Doc(`Serve the built database file as a read-only JSON API. The database is reloaded every time the file changes, so you can leave this running alongside "ortfodb build --watch".
+
+	Available endpoints:
+
+	GET /works                list works, most recent first
+	                          filter with ?tag=, ?madewith= (both repeatable), ?wip=, ?private= and ?year=
+	GET /works/{id}           get a work by ID or alias
+	GET /languages            list languages present in the database
+	GET /media/{path}         serve media files from the media directory (media.at in the configuration)
+
+	Add ?lang=<language> to /works and /works/{id} to get content localized to that language.
+	`),
+	Example: "ortfodb serve database.json --address localhost:4000",
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		address, _ := cmd.Flags().GetString("address")
+		mediaDirectory, _ := cmd.Flags().GetString("media")
+		skipValidation, _ := cmd.Flags().GetBool("no-verify")
+
+		if !cmd.Flags().Changed("media") {
+			config, err := ortfodb.NewConfigurationForEnvironment(flags.Config, flags.Environment)
+			if err != nil {
+				handleError(fmt.Errorf("while loading configuration: %w", err))
+			}
+			mediaDirectory = config.Media.At
+		}
+
+		server, err := ortfodb.NewDatabaseServer(args[0], mediaDirectory, skipValidation)
+		if err != nil {
+			handleError(err)
+		}
+
+		go func() {
+			if err := server.WatchDatabaseFile(make(chan struct{})); err != nil {
+				ll.WarnDisplay("database file won't be reloaded on changes", err)
+			}
+		}()
+
+		ll.Log("Serving", "cyan", "%s on http://%s", args[0], address)
+		handleError(http.ListenAndServe(address, server.Handler()))
+	},
+}
+
+func init() {
+	serveCmd.PersistentFlags().StringP("address", "a", "localhost:8080", "Address to listen on")
+	serveCmd.PersistentFlags().String("media", "", "Directory to serve media files from. Defaults to media.at from the configuration file")
+	serveCmd.PersistentFlags().BoolP("no-verify", "n", false, "Don't validate the database file against the JSON schema when (re)loading it")
+	rootCmd.AddCommand(serveCmd)
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 
 	ll "github.com/ewen-lbh/label-logger-go"
@@ -25,6 +26,16 @@ type ExtractColorsConfiguration struct {
 type MakeGIFsConfiguration struct {
 	Enabled          bool
 	FileNameTemplate string `yaml:"file name template"`
+	// Formats to make animated previews in: gif and/or webp. Defaults to the extension of the file name template, or to both if it has a <format> placeholder.
+	Formats []string `yaml:"formats,omitempty"`
+	// Duration of animated previews, in seconds. Defaults to 3.
+	Duration float64 `yaml:"duration,omitempty"`
+	// Number of frames per second of animated previews. Defaults to 10.
+	FrameRate int `yaml:"frame rate,omitempty"`
+	// Time in the video at which animated previews start, in seconds. Defaults to 0.
+	Start float64 `yaml:"start,omitempty"`
+	// Size of the largest side of animated previews, in pixels. They are never larger than the video itself. Defaults to 400.
+	Size int `yaml:"size,omitempty"`
 }
 
 type MakeThumbnailsConfiguration struct {
@@ -32,12 +43,20 @@ type MakeThumbnailsConfiguration struct {
 	Sizes            []int
 	InputFile        string `yaml:"input file"`
 	FileNameTemplate string `yaml:"file name template"`
+	// Backend to use to make thumbnails, per content type (glob patterns such as "image/*" are allowed): "native" (in-process, no external tools needed) or "external" (ImageMagick, resvg, gifsicle...). Defaults to native for JPEG, PNG, GIF, WebP, BMP and TIFF images written as JPEG, PNG, GIF or WebP thumbnails, and to external otherwise.
+	Backends map[string]string `yaml:"backends,omitempty"`
+	// Formats to make thumbnails in, as file extensions (for example avif, webp and jpeg). Every size is made in every format, the first format being the one used for the thumbnails map of media. Defaults to the extension of the file name template.
+	Formats []string `yaml:"formats,omitempty"`
+	// Quality of thumbnails in lossy formats, from 1 to 100, per format (for example jpeg: 80). Defaults to the encoder's default.
+	Quality map[string]int `yaml:"quality,omitempty"`
 }
 
 type BuildSteps struct {
-	ExtractColors  ExtractColorsConfiguration  `yaml:"extract colors"`
-	MakeGifs       MakeGIFsConfiguration       `yaml:"make gifs"`
-	MakeThumbnails MakeThumbnailsConfiguration `yaml:"make thumbnails"`
+	ExtractColors    ExtractColorsConfiguration    `yaml:"extract colors"`
+	MakeGifs         MakeGIFsConfiguration         `yaml:"make gifs"`
+	MakeThumbnails   MakeThumbnailsConfiguration   `yaml:"make thumbnails"`
+	MakePlaceholders MakePlaceholdersConfiguration `yaml:"make placeholders"`
+	MakeWaveforms    MakeWaveformsConfiguration    `yaml:"make waveforms"`
 }
 
 type TagsConfiguration struct {
@@ -53,6 +72,10 @@ type TechnologiesConfiguration struct {
 type MediaConfiguration struct {
 	// Path to the media directory.
 	At string
+	// How to handle media embeds with an http:// or https:// source.
+	Remote RemoteMediaConfiguration `yaml:"remote,omitempty"`
+	// Remove GPS coordinates and personal information (such as the photographer's name or their camera's serial number) from the metadata of JPEG photos copied to the media directory, and from the database.
+	StripPrivateMetadata bool `yaml:"strip private metadata,omitempty"`
 }
 
 // Configuration represents what the ortfodb.yaml configuration file describes.
@@ -60,22 +83,42 @@ type Configuration struct {
 	// Signals whether the configuration was instanciated by DefaultConfiguration.
 	IsDefault bool `yaml:"-"`
 
-	ExtractColors       ExtractColorsConfiguration  `yaml:"extract colors,omitempty"`
-	MakeGifs            MakeGIFsConfiguration       `yaml:"make gifs,omitempty"`
-	MakeThumbnails      MakeThumbnailsConfiguration `yaml:"make thumbnails,omitempty"`
-	Media               MediaConfiguration          `yaml:"media,omitempty"`
-	ScatteredModeFolder string                      `yaml:"scattered mode folder"`
-	Tags                TagsConfiguration           `yaml:"tags,omitempty"`
-	Technologies        TechnologiesConfiguration   `yaml:"technologies,omitempty"`
+	ExtractColors       ExtractColorsConfiguration    `yaml:"extract colors,omitempty"`
+	MakeGifs            MakeGIFsConfiguration         `yaml:"make gifs,omitempty"`
+	MakeThumbnails      MakeThumbnailsConfiguration   `yaml:"make thumbnails,omitempty"`
+	MakePlaceholders    MakePlaceholdersConfiguration `yaml:"make placeholders,omitempty"`
+	MakeWaveforms       MakeWaveformsConfiguration    `yaml:"make waveforms,omitempty"`
+	Media               MediaConfiguration            `yaml:"media,omitempty"`
+	ScatteredModeFolder string                        `yaml:"scattered mode folder"`
+	Tags                TagsConfiguration             `yaml:"tags,omitempty"`
+	Technologies        TechnologiesConfiguration     `yaml:"technologies,omitempty"`
 
 	// Path to the directory containing all projects. Must be absolute.
 	ProjectsDirectory string `yaml:"projects at"`
 
-	// Exporter-specific configuration. Maps exporter names to their configuration.
+	// Custom fields of the front matter of description files. Maps field names to their declaration. Their values are validated and coerced to their type, and stored in the additional metadata of works.
+	CustomFields CustomFields `yaml:"custom fields,omitempty"`
+
+	// Build profiles, selected with build --profile or with the profile option of exporters. Maps profile names to the works they include.
+	Profiles map[string]ProfileConfiguration `yaml:"profiles,omitempty"`
+
+	// Exporter-specific configuration. Maps exporter names to their configuration. The profile option of any exporter selects the profile of the works it receives.
 	Exporters map[string]map[string]interface{} `yaml:"exporters,omitempty"`
 
+	// Path to a configuration file this one is based on: its values are overridden by the ones of this file. Relative to this file.
+	Extends string `yaml:"extends,omitempty"`
+
+	// Paths to configuration files merged on top of this one, in order. Relative to this file.
+	Include []string `yaml:"include,omitempty"`
+
+	// Overlays merged on top of the configuration, selected with --env or the ORTFODB_ENV environment variable. Maps environment names to configuration values.
+	Environments map[string]map[string]interface{} `yaml:"environments,omitempty"`
+
 	// Where was the configuration loaded from
 	source string
+
+	// Where each value was loaded from, see Sources
+	sources map[string]string
 }
 
 // LoadConfiguration loads the given configuration YAML file and puts it contents into loadInto.
@@ -92,10 +135,20 @@ func LoadConfiguration(filename string, loadInto *Configuration) error {
 	return nil
 }
 
-// NewConfiguration loads a YAML configuration file.
+// NewConfiguration loads a YAML configuration file, with the environment selected by the ORTFODB_ENV environment variable.
 // This function also validates the configuration and prints any error to the user.
 // Use LoadConfiguration for a lower-level function that just loads the YAML file into a struct.
 func NewConfiguration(filename string) (Configuration, error) {
+	return NewConfigurationForEnvironment(filename, "")
+}
+
+// NewConfigurationForEnvironment loads a YAML configuration file along with the files it extends and includes, applies the overlay of the given environment (or of the one selected by the ORTFODB_ENV environment variable if environment is empty) and replaces ${VAR} references to environment variables, see LoadLayeredConfiguration.
+// The resulting configuration is validated, and any error is printed to the user.
+func NewConfigurationForEnvironment(filename string, environment string) (Configuration, error) {
+	if environment == "" {
+		environment = os.Getenv(ConfigurationEnvironmentVariable)
+	}
+
 	if filename == DefaultConfigurationFilename {
 		if _, err := os.Stat(filename); os.IsNotExist(err) {
 			ll.Log("Writing", "yellow", "default configuration file at %s", filename)
@@ -110,7 +163,12 @@ func NewConfiguration(filename string) (Configuration, error) {
 		}
 	}
 
-	validated, validationErrors, err := ValidateConfiguration(filename)
+	values, sources, err := LoadLayeredConfiguration(filename, environment)
+	if err != nil {
+		return Configuration{}, fmt.Errorf("while loading configuration file at %s: %w", filename, err)
+	}
+
+	validated, validationErrors, err := validateConfigurationValues(values)
 	if err != nil {
 		return Configuration{}, fmt.Errorf("while validating configuration %s: %v", filename, err.Error())
 	}
@@ -119,11 +177,16 @@ func NewConfiguration(filename string) (Configuration, error) {
 		return Configuration{}, fmt.Errorf("the configuration file is invalid. See validation errors above")
 	}
 
-	config := Configuration{source: filename}
-	err = LoadConfiguration(filename, &config)
+	config := Configuration{source: filename, sources: sources}
+	merged, err := yaml.Marshal(values)
+	if err != nil {
+		return Configuration{}, fmt.Errorf("while encoding merged configuration: %w", err)
+	}
+	err = yaml.Unmarshal(merged, &config)
 	if err != nil {
 		return Configuration{}, fmt.Errorf("while loading configuration file at %s: %w", filename, err)
 	}
+	ll.Debug("Loaded configuration from %s (environment %q) to %#v", filename, environment, config)
 
 	config.ProjectsDirectory, err = homedir.Expand(config.ProjectsDirectory)
 	if err != nil {
@@ -160,11 +223,49 @@ func NewConfiguration(filename string) (Configuration, error) {
 		return Configuration{}, fmt.Errorf("could not expand home directory symbol of make thumbnails.file name template: %w", err)
 	}
 
+	for i, format := range config.MakeThumbnails.Formats {
+		config.MakeThumbnails.Formats[i] = normalizeThumbnailFormat(format)
+	}
+	for format, quality := range config.MakeThumbnails.Quality {
+		if quality < 1 || quality > 100 {
+			return Configuration{}, fmt.Errorf("make thumbnails.quality.%s must be between 1 and 100, not %d", format, quality)
+		}
+	}
+
+	for i, format := range config.MakeGifs.Formats {
+		config.MakeGifs.Formats[i] = normalizeThumbnailFormat(format)
+		if !slices.Contains(GIFFormats, config.MakeGifs.Formats[i]) {
+			return Configuration{}, fmt.Errorf("unsupported format %q in make gifs.formats, must be one of %s", format, strings.Join(GIFFormats, ", "))
+		}
+	}
+
+	for _, kind := range config.MakePlaceholders.Kinds {
+		if kind != PlaceholderBlurHash && kind != PlaceholderLQIP {
+			return Configuration{}, fmt.Errorf("unknown placeholder kind %q in make placeholders.kinds, must be %q or %q", kind, PlaceholderBlurHash, PlaceholderLQIP)
+		}
+	}
+
+	if err := checkProfiles(config); err != nil {
+		return Configuration{}, err
+	}
+
+	for name, field := range config.CustomFields {
+		if err := field.normalize(name); err != nil {
+			return Configuration{}, err
+		}
+		config.CustomFields[name] = field
+	}
+
 	config.Media.At, err = homedir.Expand(config.Media.At)
 	if err != nil {
 		return Configuration{}, fmt.Errorf("could not expand home directory symbol of media.at: %w", err)
 	}
 
+	config.Media.Remote.Cache, err = homedir.Expand(config.Media.Remote.Cache)
+	if err != nil {
+		return Configuration{}, fmt.Errorf("could not expand home directory symbol of media.remote.cache: %w", err)
+	}
+
 	return config, nil
 }
 
@@ -191,10 +292,14 @@ func ValidateConfiguration(configFilepath string) (valid bool, validationErrors
 		return false, nil, err
 	}
 	yaml.Unmarshal(configContent, &configuration)
+	return validateConfigurationValues(normalizeYAMLValue(configuration))
+}
+
+// validateConfigurationValues validates raw configuration values against ConfigurationJSONSchema.
+func validateConfigurationValues(values interface{}) (valid bool, validationErrors []gojsonschema.ResultError, err error) {
 	json := jsoniter.ConfigFastest
-	configurationDocument, _ := json.Marshal(configuration)
-	valid, validationErrors, err = validateWithJSONSchema(string(configurationDocument), ConfigurationJSONSchema())
-	return
+	configurationDocument, _ := json.Marshal(values)
+	return validateWithJSONSchema(string(configurationDocument), ConfigurationJSONSchema())
 }
 
 // DefaultConfiguration returns a configuration with sensible defaults.
@@ -214,7 +319,7 @@ func DefaultConfiguration() Configuration {
 			Sizes:            []int{100, 400, 600, 1200},
 			FileNameTemplate: "<work id>/<block id>@<size>.webp",
 		},
-		Media: struct{ At string }{
+		Media: MediaConfiguration{
 			At: "media/",
 		},
 		ScatteredModeFolder: DefaultScatteredModeFolder,
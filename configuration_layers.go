@@ -0,0 +1,358 @@
+package ortfodb
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+// ConfigurationEnvironmentVariable is the environment variable that selects the configuration environment, when none is given with --env.
+const ConfigurationEnvironmentVariable = "ORTFODB_ENV"
+
+// PatternEnvironmentVariableReference matches ${VAR} and ${VAR:-default} references to environment variables in configuration values. $${VAR} is left as ${VAR}.
+const PatternEnvironmentVariableReference = `\$?\$\{([A-Za-z_][A-Za-z0-9_]*)(?::-([^}]*))?\}`
+
+// configurationLayer holds raw configuration values, with the source each value comes from, keyed by the dotted path to the value (for example "make thumbnails.sizes").
+type configurationLayer struct {
+	values  map[string]interface{}
+	sources map[string]string
+}
+
+// configurationLoader loads a configuration file along with the files it extends and includes.
+type configurationLoader struct {
+	// Absolute paths of the files being loaded, to detect cycles
+	loading []string
+}
+
+// LoadLayeredConfiguration loads the configuration file at filename as raw values: the file it extends is loaded first, then the file itself, then the files it includes, each overriding the values of the previous ones. Maps are merged, other values (lists included) are replaced.
+// The overlay of the given environment (from the environments key) is applied last, and ${VAR} references to environment variables are replaced in string values.
+// It also returns where each value comes from, keyed by the dotted path to the value.
+func LoadLayeredConfiguration(filename string, environment string) (values map[string]interface{}, sources map[string]string, err error) {
+	loader := configurationLoader{}
+	layer, err := loader.load(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if environment != "" {
+		environments, _ := layer.values["environments"].(map[string]interface{})
+		overlayValues, ok := environments[environment].(map[string]interface{})
+		if !ok {
+			available := mapKeys(environments)
+			slices.Sort(available)
+			return nil, nil, fmt.Errorf("no environment named %q in the configuration, available environments are: %s", environment, strings.Join(available, ", "))
+		}
+		for _, key := range []string{"extends", "include", "environments"} {
+			if _, ok := overlayValues[key]; ok {
+				return nil, nil, fmt.Errorf("environments.%s cannot contain %s", environment, key)
+			}
+		}
+		overlay := configurationLayer{values: overlayValues, sources: make(map[string]string)}
+		prefix := "environments." + environment + "."
+		for path, source := range layer.sources {
+			if strings.HasPrefix(path, prefix) {
+				overlay.sources[strings.TrimPrefix(path, prefix)] = fmt.Sprintf("%s (environment %s)", source, environment)
+			}
+		}
+		layer.merge(overlay, nil)
+	}
+	layer.remove([]string{"environments"})
+
+	if err := layer.interpolate(layer.values, ""); err != nil {
+		return nil, nil, err
+	}
+	return layer.values, layer.sources, nil
+}
+
+// load loads the configuration file at filename and the files it extends and includes.
+func (l *configurationLoader) load(filename string) (configurationLayer, error) {
+	absolute, err := filepath.Abs(filename)
+	if err != nil {
+		return configurationLayer{}, fmt.Errorf("while getting absolute path of %s: %w", filename, err)
+	}
+	if slices.Contains(l.loading, absolute) {
+		return configurationLayer{}, fmt.Errorf("configuration file %s includes itself (through %s)", filename, strings.Join(l.loading, " → "))
+	}
+	l.loading = append(l.loading, absolute)
+	defer func() { l.loading = l.loading[:len(l.loading)-1] }()
+
+	raw, err := readFileBytes(filename)
+	if err != nil {
+		return configurationLayer{}, err
+	}
+	var document interface{}
+	if err := yaml.Unmarshal(raw, &document); err != nil {
+		return configurationLayer{}, fmt.Errorf("while parsing %s: %w", filename, err)
+	}
+	values, ok := normalizeYAMLValue(document).(map[string]interface{})
+	if document != nil && !ok {
+		return configurationLayer{}, fmt.Errorf("%s is not a YAML object", filename)
+	}
+	if values == nil {
+		values = make(map[string]interface{})
+	}
+
+	layer := configurationLayer{values: make(map[string]interface{}), sources: make(map[string]string)}
+	if extends, ok := values["extends"]; ok {
+		extended, ok := extends.(string)
+		if !ok {
+			return configurationLayer{}, fmt.Errorf("extends must be a path to a configuration file in %s", filename)
+		}
+		base, err := l.load(resolveIncludedPath(filename, extended))
+		if err != nil {
+			return configurationLayer{}, fmt.Errorf("while loading %s, extended by %s: %w", extended, filename, err)
+		}
+		layer = base
+	}
+
+	includes, ok := values["include"].([]interface{})
+	if _, present := values["include"]; present && !ok {
+		return configurationLayer{}, fmt.Errorf("include must be a list of paths to configuration files in %s", filename)
+	}
+	delete(values, "extends")
+	delete(values, "include")
+
+	own := configurationLayer{values: values, sources: make(map[string]string)}
+	own.setSources(values, "", filename)
+	layer.merge(own, nil)
+
+	for _, include := range includes {
+		included, ok := include.(string)
+		if !ok {
+			return configurationLayer{}, fmt.Errorf("include must be a list of paths to configuration files in %s", filename)
+		}
+		other, err := l.load(resolveIncludedPath(filename, included))
+		if err != nil {
+			return configurationLayer{}, fmt.Errorf("while loading %s, included by %s: %w", included, filename, err)
+		}
+		layer.merge(other, nil)
+	}
+	return layer, nil
+}
+
+// resolveIncludedPath resolves the path of a file extended or included by the configuration file at filename, relative to its directory.
+func resolveIncludedPath(filename string, included string) string {
+	if filepath.IsAbs(included) {
+		return included
+	}
+	return filepath.Join(filepath.Dir(filename), included)
+}
+
+// setSources sets the source of every value of values (found at prefix).
+func (layer configurationLayer) setSources(values map[string]interface{}, prefix string, source string) {
+	for key, value := range values {
+		if nested, ok := value.(map[string]interface{}); ok && len(nested) > 0 {
+			layer.setSources(nested, prefix+key+".", source)
+		} else {
+			layer.sources[prefix+key] = source
+		}
+	}
+}
+
+// merge merges the values of other into the layer's values (at the given path of maps), recursing into maps.
+func (layer configurationLayer) merge(other configurationLayer, at []string) {
+	target := layer.valuesAt(at)
+	for key, value := range other.valuesAt(at) {
+		path := append(slices.Clone(at), key)
+		nested, isMap := value.(map[string]interface{})
+		if _, ok := target[key].(map[string]interface{}); ok && isMap && len(nested) > 0 {
+			layer.merge(other, path)
+			continue
+		}
+		layer.remove(path)
+		target[key] = value
+		if isMap && len(nested) > 0 {
+			prefix := strings.Join(path, ".") + "."
+			for otherPath, otherSource := range other.sources {
+				if strings.HasPrefix(otherPath, prefix) {
+					layer.sources[otherPath] = otherSource
+				}
+			}
+		} else {
+			layer.sources[strings.Join(path, ".")] = other.sources[strings.Join(path, ".")]
+		}
+	}
+}
+
+// valuesAt returns the map found at the given path of maps.
+func (layer configurationLayer) valuesAt(path []string) map[string]interface{} {
+	values := layer.values
+	for _, key := range path {
+		values = values[key].(map[string]interface{})
+	}
+	return values
+}
+
+// remove removes the value at path, and its sources.
+func (layer configurationLayer) remove(path []string) {
+	parent := layer.values
+	for _, key := range path[:len(path)-1] {
+		var ok bool
+		if parent, ok = parent[key].(map[string]interface{}); !ok {
+			return
+		}
+	}
+	delete(parent, path[len(path)-1])
+	dotted := strings.Join(path, ".")
+	for sourcePath := range layer.sources {
+		if sourcePath == dotted || strings.HasPrefix(sourcePath, dotted+".") {
+			delete(layer.sources, sourcePath)
+		}
+	}
+}
+
+// interpolate replaces references to environment variables in string values of values (found at prefix).
+func (layer configurationLayer) interpolate(values map[string]interface{}, prefix string) error {
+	for key, value := range values {
+		path := prefix + key
+		switch value := value.(type) {
+		case map[string]interface{}:
+			if err := layer.interpolate(value, path+"."); err != nil {
+				return err
+			}
+		case []interface{}:
+			if _, err := layer.interpolateListItem(value, path); err != nil {
+				return err
+			}
+		case string:
+			interpolated, err := layer.interpolateString(value, path)
+			if err != nil {
+				return err
+			}
+			values[key] = interpolated
+		}
+	}
+	return nil
+}
+
+// interpolateListItem replaces references to environment variables in string values of item, which is in the list at path, and returns it. Lists and maps are modified in place.
+// Sources are tracked per list, so values at any depth in it are noted as values of the list at path.
+func (layer configurationLayer) interpolateListItem(item interface{}, path string) (interface{}, error) {
+	switch item := item.(type) {
+	case string:
+		return layer.interpolateString(item, path)
+	case []interface{}:
+		for i, value := range item {
+			interpolated, err := layer.interpolateListItem(value, path)
+			if err != nil {
+				return item, err
+			}
+			item[i] = interpolated
+		}
+	case map[string]interface{}:
+		for key, value := range item {
+			interpolated, err := layer.interpolateListItem(value, path)
+			if err != nil {
+				return item, err
+			}
+			item[key] = interpolated
+		}
+	}
+	return item, nil
+}
+
+// interpolateString replaces references to environment variables in s, the value at path, and notes the variables used in its source.
+func (layer configurationLayer) interpolateString(s string, path string) (string, error) {
+	var err error
+	used := make([]string, 0)
+	interpolated := regexp.MustCompile(PatternEnvironmentVariableReference).ReplaceAllStringFunc(s, func(reference string) string {
+		if strings.HasPrefix(reference, "$$") {
+			return strings.TrimPrefix(reference, "$")
+		}
+		groups := regexp.MustCompile(PatternEnvironmentVariableReference).FindStringSubmatch(reference)
+		name, fallback, hasFallback := groups[1], groups[2], strings.Contains(reference, ":-")
+		value, ok := os.LookupEnv(name)
+		if !ok && !hasFallback {
+			err = fmt.Errorf("%s refers to the environment variable %s, which is not set. Use ${%s:-default} to give it a default value", path, name, name)
+			return reference
+		}
+		if !ok {
+			value = fallback
+		}
+		if !slices.Contains(used, name) {
+			used = append(used, name)
+		}
+		return value
+	})
+	// Several strings of a list can use the same variables
+	used = slices.DeleteFunc(used, func(name string) bool {
+		return strings.Contains(layer.sources[path]+",", "$"+name+",")
+	})
+	if len(used) > 0 && strings.Contains(layer.sources[path], ", with $") {
+		layer.sources[path] = fmt.Sprintf("%s, $%s", layer.sources[path], strings.Join(used, ", $"))
+	} else if len(used) > 0 {
+		layer.sources[path] = fmt.Sprintf("%s, with $%s", layer.sources[path], strings.Join(used, ", $"))
+	}
+	return interpolated, err
+}
+
+// normalizeYAMLValue converts maps decoded by yaml.v2 (which have interface{} keys) to maps with string keys, recursively.
+func normalizeYAMLValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[interface{}]interface{}:
+		normalized := make(map[string]interface{}, len(value))
+		for key, item := range value {
+			normalized[fmt.Sprint(key)] = normalizeYAMLValue(item)
+		}
+		return normalized
+	case []interface{}:
+		for i, item := range value {
+			value[i] = normalizeYAMLValue(item)
+		}
+	}
+	return value
+}
+
+// Sources returns where each value of the configuration comes from, keyed by the dotted path to the value (for example "make thumbnails.sizes"): a configuration file, possibly followed by the environment it comes from and the environment variables it uses.
+func (config Configuration) Sources() map[string]string {
+	return config.sources
+}
+
+// AnnotatedYAML returns the configuration as YAML, with the source of each value in comments. Values without a source are defaults.
+func (config Configuration) AnnotatedYAML() (string, error) {
+	var document yamlv3.Node
+	if err := document.Encode(config); err != nil {
+		return "", fmt.Errorf("while encoding configuration: %w", err)
+	}
+	annotateYAMLNode(&document, "", config.sources)
+	out, err := yamlv3.Marshal(&document)
+	if err != nil {
+		return "", fmt.Errorf("while encoding configuration: %w", err)
+	}
+	return string(out), nil
+}
+
+// annotateYAMLNode adds the source of the values of the mapping node (found at prefix) as comments.
+func annotateYAMLNode(node *yamlv3.Node, prefix string, sources map[string]string) {
+	if node.Kind == yamlv3.DocumentNode {
+		for _, child := range node.Content {
+			annotateYAMLNode(child, prefix, sources)
+		}
+		return
+	}
+	if node.Kind != yamlv3.MappingNode {
+		return
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		path := prefix + key.Value
+		if value.Kind == yamlv3.MappingNode && len(value.Content) > 0 {
+			annotateYAMLNode(value, path+".", sources)
+			continue
+		}
+		if value.Kind == yamlv3.SequenceNode {
+			value.Style = yamlv3.FlowStyle
+		}
+		source, ok := sources[path]
+		if !ok {
+			source = "default"
+		}
+		value.LineComment = source
+	}
+}
@@ -0,0 +1,282 @@
+package ortfodb
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/invopop/jsonschema"
+	"github.com/mitchellh/mapstructure"
+)
+
+// Types of custom front-matter fields.
+const (
+	CustomFieldString  = "string"
+	CustomFieldNumber  = "number"
+	CustomFieldInteger = "integer"
+	CustomFieldBoolean = "boolean"
+	// Dates are written like started and finished dates, for example 2024-03-??
+	CustomFieldDate = "date"
+)
+
+// CustomFieldTypes are the types custom front-matter fields can have.
+var CustomFieldTypes = []string{CustomFieldString, CustomFieldNumber, CustomFieldInteger, CustomFieldBoolean, CustomFieldDate}
+
+// CustomFieldConfiguration declares a custom field of the front matter of description files.
+type CustomFieldConfiguration struct {
+	// Type of the field's values: string, number, integer, boolean or date.
+	Type string `yaml:"type"`
+	// Whether the field's value is a list of values of that type. A single value is turned into a list of one value.
+	List bool `yaml:"list,omitempty"`
+	// Whether description files must set the field. Fields with a default value are never missing.
+	Required bool `yaml:"required,omitempty"`
+	// Value of the field for description files that don't set it.
+	Default interface{} `yaml:"default,omitempty"`
+	// Values the field can take. For lists, every item must be one of them.
+	Enum []interface{} `yaml:"enum,omitempty"`
+	// What the field is for, shown in the front matter's JSON schema.
+	Description string `yaml:"description,omitempty"`
+}
+
+// CustomFields maps names of custom front-matter fields to their declaration.
+type CustomFields map[string]CustomFieldConfiguration
+
+// normalize checks the declaration of the field, and coerces its default value and allowed values to its type.
+func (field *CustomFieldConfiguration) normalize(name string) error {
+	if !slices.Contains(CustomFieldTypes, field.Type) {
+		return fmt.Errorf("unknown type %q for custom field %s, must be one of %s", field.Type, name, strings.Join(CustomFieldTypes, ", "))
+	}
+	if slices.Contains(builtinMetadataKeys(), name) || slices.Contains(builtinMetadataKeys(), strings.ReplaceAll(name, "_", " ")) {
+		return fmt.Errorf("custom field %s has the same name as a built-in field", name)
+	}
+	for i, value := range field.Enum {
+		coerced, err := coerceCustomFieldValue(field.Type, value)
+		if err != nil {
+			return fmt.Errorf("invalid allowed value for custom field %s: %w", name, err)
+		}
+		field.Enum[i] = coerced
+	}
+	if field.Default != nil {
+		coerced, err := field.coerce(field.Default)
+		if err != nil {
+			return fmt.Errorf("invalid default value for custom field %s: %w", name, err)
+		}
+		field.Default = coerced
+	}
+	return nil
+}
+
+// coerce converts value to the field's type, and checks that it is allowed.
+func (field CustomFieldConfiguration) coerce(value interface{}) (interface{}, error) {
+	if !field.List {
+		return field.coerceItem(value)
+	}
+	items, isList := value.([]interface{})
+	if !isList {
+		items = []interface{}{value}
+	}
+	coerced := make([]interface{}, 0, len(items))
+	for i, item := range items {
+		coercedItem, err := field.coerceItem(item)
+		if err != nil {
+			return nil, fmt.Errorf("item #%d: %w", i+1, err)
+		}
+		coerced = append(coerced, coercedItem)
+	}
+	return coerced, nil
+}
+
+func (field CustomFieldConfiguration) coerceItem(value interface{}) (interface{}, error) {
+	if _, isList := value.([]interface{}); isList {
+		return nil, fmt.Errorf("expected a single %s, not a list", field.Type)
+	}
+	coerced, err := coerceCustomFieldValue(field.Type, value)
+	if err != nil {
+		return nil, err
+	}
+	if len(field.Enum) > 0 && !slices.Contains(field.Enum, coerced) {
+		allowed := make([]string, 0, len(field.Enum))
+		for _, value := range field.Enum {
+			allowed = append(allowed, fmt.Sprintf("%v", value))
+		}
+		return nil, fmt.Errorf("%v is not one of %s", coerced, strings.Join(allowed, ", "))
+	}
+	return coerced, nil
+}
+
+// coerceCustomFieldValue converts a value decoded from YAML to the given custom field type: numbers written as text are parsed, for example.
+func coerceCustomFieldValue(typ string, value interface{}) (interface{}, error) {
+	switch typ {
+	case CustomFieldString:
+		switch value := value.(type) {
+		case string:
+			return value, nil
+		case int, float64, bool:
+			return fmt.Sprint(value), nil
+		}
+	case CustomFieldNumber:
+		switch value := value.(type) {
+		case int:
+			return float64(value), nil
+		case float64:
+			return value, nil
+		case string:
+			if number, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+				return number, nil
+			}
+		}
+	case CustomFieldInteger:
+		switch value := value.(type) {
+		case int:
+			return value, nil
+		case float64:
+			if value == float64(int(value)) {
+				return int(value), nil
+			}
+		case string:
+			if number, err := strconv.Atoi(strings.TrimSpace(value)); err == nil {
+				return number, nil
+			}
+		}
+	case CustomFieldBoolean:
+		switch value := value.(type) {
+		case bool:
+			return value, nil
+		case string:
+			switch strings.ToLower(strings.TrimSpace(value)) {
+			case "true", "yes", "on":
+				return true, nil
+			case "false", "no", "off":
+				return false, nil
+			}
+		}
+	case CustomFieldDate:
+		date := fmt.Sprint(value)
+		if _, err := parsePossiblyInterderminateDate(date); err != nil {
+			return nil, fmt.Errorf("could not parse %q as a date: %w", date, err)
+		}
+		return date, nil
+	}
+	return nil, fmt.Errorf("expected a %s, got %#v", typ, value)
+}
+
+// Apply validates the custom fields found in additional metadata of a work (as decoded by ParseYAMLHeader) and coerces them to their types, setting default values of missing fields.
+// Values are stored under the name of the field as declared. Fields that are not declared are left as-is.
+func (fields CustomFields) Apply(additionalMetadata map[string]interface{}) (map[string]interface{}, error) {
+	if additionalMetadata == nil {
+		additionalMetadata = make(map[string]interface{})
+	}
+	for _, name := range fields.names() {
+		field := fields[name]
+		key := strings.ReplaceAll(name, " ", "_")
+		value, ok := additionalMetadata[key]
+		delete(additionalMetadata, key)
+		if !ok || value == nil {
+			if field.Default != nil {
+				additionalMetadata[name] = field.Default
+			} else if field.Required {
+				return additionalMetadata, fmt.Errorf("missing required field %s", name)
+			}
+			continue
+		}
+		coerced, err := field.coerce(value)
+		if err != nil {
+			return additionalMetadata, fmt.Errorf("invalid value for %s: %w", name, err)
+		}
+		additionalMetadata[name] = coerced
+	}
+	return additionalMetadata, nil
+}
+
+func (fields CustomFields) names() []string {
+	names := mapKeys(fields)
+	slices.Sort(names)
+	return names
+}
+
+// JSONSchema returns the JSON schema of the field's values.
+func (field CustomFieldConfiguration) JSONSchema() *jsonschema.Schema {
+	item := &jsonschema.Schema{Type: field.Type, Enum: field.Enum}
+	switch field.Type {
+	case CustomFieldDate:
+		item.Type = "string"
+	case CustomFieldInteger, CustomFieldNumber:
+		// Numbers written as text are accepted too
+		item = &jsonschema.Schema{AnyOf: []*jsonschema.Schema{item, {Type: "string"}}}
+	}
+	schema := item
+	if field.List {
+		schema = &jsonschema.Schema{AnyOf: []*jsonschema.Schema{item, {Type: "array", Items: item}}}
+	}
+	schema.Description = field.Description
+	schema.Default = field.Default
+	return schema
+}
+
+// FrontMatterJSONSchema returns the JSON schema of the front matter of description files, including the custom fields declared in the configuration.
+func FrontMatterJSONSchema(config Configuration) *jsonschema.Schema {
+	reflector := yamlReflector
+	reflector.RequiredFromJSONSchemaTags = true
+	reflector.AllowAdditionalProperties = true
+	reflector.AddGoComments("github.com/ortfo/db", "./")
+	schema := reflector.Reflect(&WorkMetadata{})
+	setSchemaId(schema, "front-matter")
+
+	metadata := schema.Definitions["WorkMetadata"]
+	metadata.Properties.Delete("additionalmetadata")
+	for _, name := range config.CustomFields.names() {
+		field := config.CustomFields[name]
+		metadata.Properties.Set(name, field.JSONSchema())
+		if field.Required && field.Default == nil {
+			metadata.Required = append(metadata.Required, name)
+		}
+	}
+	return schema
+}
+
+// builtinMetadataKeys returns the keys of the front matter that are decoded into fields of WorkMetadata, and thus can't be custom fields.
+func builtinMetadataKeys() []string {
+	keys := make([]string, 0)
+	typ := reflect.TypeOf(WorkMetadata{})
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if name == "-" || strings.Contains(field.Tag.Get("mapstructure"), "remain") {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		keys = append(keys, name)
+	}
+	return keys
+}
+
+// DecodeMetadata decodes the additional metadata of a work, including its custom fields, into a value of type T: usually a struct whose fields have yaml tags named after the custom fields.
+// Dates can be decoded into time.Time fields.
+func DecodeMetadata[T any](metadata WorkMetadata) (T, error) {
+	var decoded T
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		Result:     &decoded,
+		TagName:    "yaml",
+		DecodeHook: decodeDateHook,
+	})
+	if err != nil {
+		return decoded, fmt.Errorf("while creating metadata decoder: %w", err)
+	}
+	if err := decoder.Decode(metadata.AdditionalMetadata); err != nil {
+		return decoded, fmt.Errorf("while decoding metadata: %w", err)
+	}
+	return decoded, nil
+}
+
+// decodeDateHook decodes dates written as text into time.Time values.
+func decodeDateHook(from reflect.Type, to reflect.Type, data interface{}) (interface{}, error) {
+	if from.Kind() != reflect.String || to != reflect.TypeOf(time.Time{}) {
+		return data, nil
+	}
+	return parsePossiblyInterderminateDate(data.(string))
+}
@@ -2,6 +2,7 @@ package ortfodb
 
 import (
 	"errors"
+	"time"
 
 	jsoniter "github.com/json-iterator/go"
 )
@@ -36,8 +37,10 @@ func (db Database) FindMedia(mediaEmbed Media, workID string) (found bool, media
 
 	for _, wsl := range w.Content {
 		for _, b := range wsl.Blocks {
-			if b.Type == "media" && b.RelativeSource == mediaEmbed.RelativeSource {
-				return true, b.Media
+			for _, media := range b.Mediae() {
+				if media.RelativeSource == mediaEmbed.RelativeSource {
+					return true, media
+				}
 			}
 		}
 	}
@@ -68,3 +71,72 @@ func (work Work) FirstParagraph(lang string) (found bool, paragraph ContentBlock
 	}
 	return
 }
+
+// LocalizedWork is a Work whose content is in a single language.
+type LocalizedWork struct {
+	ID              string           `json:"id"`
+	BuiltAt         time.Time        `json:"builtAt"`
+	DescriptionHash string           `json:"descriptionHash"`
+	Metadata        WorkMetadata     `json:"metadata"`
+	Content         LocalizedContent `json:"content"`
+	Partial         bool             `json:"Partial"`
+}
+
+// Localize returns the work with its content in the given language. See LocalizableContent.Localize.
+func (work Work) Localize(lang string) LocalizedWork {
+	return LocalizedWork{
+		ID:              work.ID,
+		BuiltAt:         work.BuiltAt,
+		DescriptionHash: work.DescriptionHash,
+		Metadata:        work.Metadata,
+		Content:         work.Content.Localize(lang),
+		Partial:         work.Partial,
+	}
+}
+
+// WorksFilter selects works of a database. Zero-valued fields don't filter anything.
+type WorksFilter struct {
+	// Works must have all of these tags
+	Tags []string
+	// Works must be made with all of these technologies
+	MadeWith []string
+	WIP      *bool
+	Private  *bool
+	// Works must have been created during that year
+	Year int
+}
+
+// Matches returns true if the work is selected by the filter.
+func (f WorksFilter) Matches(work Work) bool {
+	for _, tag := range f.Tags {
+		if !some(work.Metadata.Tags, func(t string) bool { return stringsLooselyMatch(t, tag) }) {
+			return false
+		}
+	}
+	for _, tech := range f.MadeWith {
+		if !some(work.Metadata.MadeWith, func(t string) bool { return stringsLooselyMatch(t, tech) }) {
+			return false
+		}
+	}
+	if f.WIP != nil && work.Metadata.WIP != *f.WIP {
+		return false
+	}
+	if f.Private != nil && work.Metadata.Private != *f.Private {
+		return false
+	}
+	if f.Year != 0 && work.Metadata.CreatedAt().Year() != f.Year {
+		return false
+	}
+	return true
+}
+
+// Filter returns the works matched by filter, most recent works first.
+func (db Database) Filter(filter WorksFilter) []Work {
+	works := make([]Work, 0)
+	for _, work := range db.WorksByDate() {
+		if filter.Matches(work) {
+			works = append(works, work)
+		}
+	}
+	return works
+}
@@ -4,10 +4,11 @@ import (
 	"bytes"
 	"crypto/md5"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
-	"net/url"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 	"unicode/utf8"
@@ -18,7 +19,6 @@ import (
 	"github.com/anaskhan96/soup"
 	ll "github.com/ewen-lbh/label-logger-go"
 	"github.com/k3a/html2text"
-	"github.com/metal3d/go-slugify"
 	"github.com/mitchellh/mapstructure"
 	"github.com/relvacode/iso8601"
 	"github.com/yuin/goldmark"
@@ -36,6 +36,7 @@ const (
 	RuneLoop                      rune   = '~'
 	RuneAutoplay                  rune   = '>'
 	RuneHideControls              rune   = '='
+	PatternPageFragment           string = `#page=(\d+)$`
 )
 
 var markdownParser = goldmark.New(
@@ -55,24 +56,42 @@ var markdownParser = goldmark.New(
 	),
 )
 
+// frontMatterBounds returns the lines of the separators that start and end the YAML front matter of a description file, or -1 if it has none.
+// Only blank lines can come before the front matter.
+func frontMatterBounds(descriptionRaw string) (lines []string, start int, end int) {
+	lines = strings.Split(descriptionRaw, "\n")
+	separator := regexp.MustCompile(PatternYAMLSeparator)
+	start = -1
+	for i, line := range lines {
+		switch {
+		case start < 0 && separator.MatchString(line):
+			start = i
+		case start < 0 && strings.TrimSpace(line) != "":
+			return lines, -1, -1
+		case start >= 0 && separator.MatchString(line):
+			return lines, start, i
+		}
+	}
+	return lines, -1, -1
+}
+
 // ParseYAMLHeader parses the YAML header of a description markdown file and returns the rest of the content (all except the YAML header).
+// Only the first block between separators is the YAML header (see frontMatterBounds): other separators are horizontal rules of the markdown content.
 func ParseYAMLHeader[Metadata interface{}](descriptionRaw string) (Metadata, string) {
-	var inYAMLHeader bool
 	var rawYAMLPart string
 	var markdownPart string
-	for _, line := range strings.Split(descriptionRaw, "\n") {
+	lines, start, end := frontMatterBounds(descriptionRaw)
+	for i, line := range lines {
 		// Replace tabs with four spaces
 		for strings.HasPrefix(line, "\t") {
 			line = strings.Repeat(" ", 4) + strings.TrimPrefix(line, "\t")
 		}
-		// A YAML header separator is 3 or more dashes on a line (without anything else on the same line)
-		if regexp.MustCompile(PatternYAMLSeparator).MatchString(line) {
-			inYAMLHeader = !inYAMLHeader
+		switch {
+		case i == start || i == end:
 			continue
-		}
-		if inYAMLHeader {
+		case start < i && i < end:
 			rawYAMLPart += line + "\n"
-		} else {
+		default:
 			markdownPart += line + "\n"
 		}
 	}
@@ -99,6 +118,13 @@ func ParseYAMLHeader[Metadata interface{}](descriptionRaw string) (Metadata, str
 func ParseDescription(ctx *RunContext, markdownRaw string, workID string) (Work, error) {
 	defer ll.TimeTrack(time.Now(), "ParseDescription", workID)
 	metadata, markdownRaw := ParseYAMLHeader[WorkMetadata](markdownRaw)
+	if ctx.Config != nil {
+		var err error
+		metadata.AdditionalMetadata, err = ctx.Config.CustomFields.Apply(metadata.AdditionalMetadata)
+		if err != nil {
+			return Work{}, fmt.Errorf("while validating custom fields: %w", err)
+		}
+	}
 	// notLocalizedRaw: raw markdown before the first language marker
 	notLocalizedRaw, localizedRawBlocks := SplitOnLanguageMarkers(markdownRaw)
 	ll.Debug("split description into notLocalizedRaw: %#v and localizedRawBlocks: %#v", notLocalizedRaw, localizedRawBlocks)
@@ -232,22 +258,29 @@ type WorkMetadata struct {
 	Aliases            []string                      `json:"aliases" yaml:",omitempty"`
 	Finished           string                        `json:"finished" yaml:",omitempty"`
 	Started            string                        `json:"started"`
-	MadeWith           []string                      `json:"madeWith" yaml:"made with"`
+	MadeWith           []string                      `json:"madeWith" yaml:"made with" mapstructure:"made_with"`
 	Tags               []string                      `json:"tags"`
 	Thumbnail          FilePathInsidePortfolioFolder `json:"thumbnail" yaml:",omitempty"`
-	TitleStyle         TitleStyle                    `json:"titleStyle" yaml:"title style,omitempty"`
+	TitleStyle         TitleStyle                    `json:"titleStyle" yaml:"title style,omitempty" mapstructure:"title_style"`
 	Colors             ColorPalette                  `json:"colors" yaml:",omitempty"`
-	PageBackground     string                        `json:"pageBackground" yaml:"page background,omitempty"`
+	PageBackground     string                        `json:"pageBackground" yaml:"page background,omitempty" mapstructure:"page_background"`
 	WIP                bool                          `json:"wip" yaml:",omitempty"`
 	Private            bool                          `json:"private" yaml:",omitempty"`
+	PublishAt          string                        `json:"publishAt" yaml:"publish at,omitempty" mapstructure:"publish_at"`
 	AdditionalMetadata map[string]interface{}        `mapstructure:",remain" json:"additionalMetadata" yaml:",omitempty"`
 	DatabaseMetadata   DatabaseMeta                  `json:"databaseMetadata" yaml:"-" `
 }
 
+// Dated returns true if the work has a creation, finish or start date. CreatedAt returns a date in year 9999 for works that don't.
+func (m WorkMetadata) Dated() bool {
+	return m.AdditionalMetadata["created"] != nil || m.Finished != "" || m.Started != ""
+}
+
 func (m WorkMetadata) CreatedAt() time.Time {
 	var creationDate string
 	if m.AdditionalMetadata["created"] != nil {
-		creationDate = m.AdditionalMetadata["created"].(string)
+		// created is not necessarily a string when it is not declared as a custom date field
+		creationDate = fmt.Sprint(m.AdditionalMetadata["created"])
 	} else if m.Finished != "" {
 		creationDate = m.Finished
 	} else {
@@ -258,11 +291,25 @@ func (m WorkMetadata) CreatedAt() time.Time {
 	}
 	parsedDate, err := parsePossiblyInterderminateDate(creationDate)
 	if err != nil {
-		panic(err)
+		ll.Debug("Could not parse creation date %q, considering the work undated: %s", creationDate, err)
+		return time.Date(9999, time.January, 1, 0, 0, 0, 0, time.Local)
 	}
 	return parsedDate
 }
 
+// PublishDate returns the date from which the work is part of profiles that don't include scheduled works, as set by publish at.
+// It returns the zero time if publish at is not set.
+func (m WorkMetadata) PublishDate() (time.Time, error) {
+	if m.PublishAt == "" {
+		return time.Time{}, nil
+	}
+	date, err := iso8601.ParseString(m.PublishAt)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid publish at date %q: %w", m.PublishAt, err)
+	}
+	return date, nil
+}
+
 func parsePossiblyInterderminateDate(datestring string) (time.Time, error) {
 	return iso8601.ParseString(
 		strings.ReplaceAll(
@@ -303,6 +350,13 @@ type ContentBlock struct {
 	Media
 	Paragraph
 	Link
+	CodeBlock
+	Quote
+	Heading
+	Embed
+	Gallery
+	// Data stores fields of blocks with types registered by RegisterBlockType.
+	Data map[string]any `json:"data"`
 }
 
 func (b ContentBlock) AsMedia() Media {
@@ -311,18 +365,28 @@ func (b ContentBlock) AsMedia() Media {
 	}
 
 	return Media{
-		Alt:            b.Alt,
-		Caption:        b.Caption,
-		DistSource:     b.DistSource,
-		RelativeSource: b.RelativeSource,
-		ContentType:    b.ContentType,
-		Size:           b.Size,
-		Dimensions:     b.Dimensions,
-		Online:         b.Online,
-		Duration:       b.Duration,
-		Colors:         b.Colors,
-		Thumbnails:     b.Thumbnails,
-		Attributes:     b.Attributes,
+		Alt:              b.Alt,
+		Caption:          b.Caption,
+		DistSource:       b.DistSource,
+		RelativeSource:   b.RelativeSource,
+		ContentType:      b.ContentType,
+		Size:             b.Size,
+		Dimensions:       b.Dimensions,
+		Online:           b.Online,
+		Duration:         b.Duration,
+		Pages:            b.Pages,
+		Colors:           b.Colors,
+		Thumbnails:       b.Thumbnails,
+		ThumbnailSources: b.ThumbnailSources,
+		GIFs:             b.GIFs,
+		BlurHash:         b.BlurHash,
+		LQIP:             b.LQIP,
+		Photo:            b.Photo,
+		Document:         b.Document,
+		Audio:            b.Audio,
+		Waveform:         b.Waveform,
+		WaveformImage:    b.WaveformImage,
+		Attributes:       b.Attributes,
 	}
 }
 
@@ -371,7 +435,7 @@ func (s HTMLString) String() string {
 	return html2text.HTML2Text(string(s))
 }
 
-// ContentBlockType is one of "paragraph", "media" or "link"
+// ContentBlockType is one of "paragraph", "media", "link", "heading", "code", "quote", "embed" or "gallery", or the name of a block type registered with RegisterBlockType.
 type ContentBlockType string
 
 func (t ContentBlockType) String() string {
@@ -390,6 +454,26 @@ func (t ContentBlockType) IsLink() bool {
 	return string(t) == "link"
 }
 
+func (t ContentBlockType) IsHeading() bool {
+	return string(t) == "heading"
+}
+
+func (t ContentBlockType) IsCode() bool {
+	return string(t) == "code"
+}
+
+func (t ContentBlockType) IsQuote() bool {
+	return string(t) == "quote"
+}
+
+func (t ContentBlockType) IsEmbed() bool {
+	return string(t) == "embed"
+}
+
+func (t ContentBlockType) IsGallery() bool {
+	return string(t) == "gallery"
+}
+
 // Layout is a 2D array of content block IDs
 type Layout [][]LayoutCell
 
@@ -403,6 +487,7 @@ type MediaAttributes struct {
 	Muted       bool `json:"muted"`       // Controlled with attribute character > (adds)
 	Playsinline bool `json:"playsinline"` // Controlled with attribute character = (adds)
 	Controls    bool `json:"controls"`    // Controlled with attribute character = (removes)
+	Page        int  `json:"page"`        // Page of PDFs to make thumbnails of, controlled with a #page=N fragment at the end of the source. 0 means the first page
 }
 
 // ParsedWork represents a work, but without analyzed media. All it contains is information from the description.md file.
@@ -441,6 +526,19 @@ func (b ContentBlock) generateID() string {
 		dataToUse = string(b.AsParagraph().Content)
 	case "link":
 		dataToUse = b.Link.URL
+	case "heading", "quote":
+		dataToUse = string(b.Content)
+	case "code":
+		dataToUse = b.CodeLanguage + b.Code
+	case "embed":
+		dataToUse = b.EmbedURL
+	case "gallery":
+		for _, media := range b.Items {
+			dataToUse += string(media.RelativeSource) + "\n"
+		}
+	default:
+		data, _ := json.Marshal(b)
+		dataToUse = string(data)
 	}
 	hash := md5.Sum([]byte(string(b.Type) + dataToUse))
 	id := base64.URLEncoding.WithPadding(base64.NoPadding).EncodeToString(hash[:])[:10]
@@ -468,8 +566,6 @@ func (ctx *RunContext) ParseSingleLanguageDescription(markdownRaw string) (title
 	blocks = make([]ContentBlock, 0)
 	footnotes = make(Footnotes)
 	abbreviations = make(Abbreviations)
-	paragraphLike := make([]soup.Root, 0)
-	paragraphLikeTagNames := "p ol ul h2 h3 h4 h5 h6 dl blockquote hr pre"
 	body := htmlTree.Find("body")
 	if body.Error != nil {
 		err = fmt.Errorf("cannot find body in resulting HTML: %w", body.Error)
@@ -477,82 +573,31 @@ func (ctx *RunContext) ParseSingleLanguageDescription(markdownRaw string) (title
 	}
 
 	for _, element := range body.Children() {
-		// Check if it's a paragraph-like tag
-		if strings.Contains(paragraphLikeTagNames, element.NodeValue) {
-			paragraphLike = append(paragraphLike, element)
-		}
-	}
-
-	for _, paragraph := range paragraphLike {
-		childrenCount := len(paragraph.Children())
-		firstChild := soup.Root{}
-		if childrenCount >= 1 {
-			firstChild = paragraph.Children()[0]
+		if !isElement(element) {
+			continue
 		}
-		if childrenCount == 1 && firstChild.NodeValue == "img" {
-			// A media embed
-			alt, attributes := ExtractAttributesFromAlt(firstChild.Attrs()["alt"])
-			rawSrc, found := firstChild.Attrs()["src"]
-			if !found {
-				err = fmt.Errorf("media block %s has no source URL", firstChild.HTML())
-				return
-			}
-			var src string
-			src, err = url.QueryUnescape(rawSrc)
-			if err != nil {
-				err = fmt.Errorf("while unescaping media source URL %q: %w", rawSrc, err)
-				return
-			}
-			block := ContentBlock{
-				Type:   "media",
-				Anchor: slugify.Marshal(src),
-				Media: Media{
-					Alt:            alt,
-					Caption:        firstChild.Attrs()["title"],
-					RelativeSource: FilePathInsidePortfolioFolder(src),
-					Attributes:     attributes,
-				},
-			}
-			block.ID = block.generateID()
-			blocks = append(blocks, block)
-		} else if childrenCount == 1 && firstChild.NodeValue == "a" {
-			// An isolated link
-			block := ContentBlock{
-				Type:   "link",
-				Anchor: slugify.Marshal(firstChild.FullText(), true),
-				Link: Link{
-					Text:  innerHTML(firstChild),
-					Title: firstChild.Attrs()["title"],
-					URL:   firstChild.Attrs()["href"],
-				},
-			}
-			if block.URL == "" {
-				err = fmt.Errorf("link block %s has no URL", firstChild.HTML())
-				return
-			}
-
-			block.ID = block.generateID()
-			blocks = append(blocks, block)
-		} else if regexpMatches(PatternAbbreviationDefinition, string(innerHTML(paragraph))) {
+		if regexpMatches(PatternAbbreviationDefinition, string(innerHTML(element))) {
 			// An abbreviation definition
-			groups := regexpGroups(PatternAbbreviationDefinition, string(innerHTML(paragraph)))
+			groups := regexpGroups(PatternAbbreviationDefinition, string(innerHTML(element)))
 			abbreviations[groups[1]] = groups[2]
-		} else if regexpMatches(PatternLanguageMarker, string(innerHTML(paragraph))) {
+			continue
+		}
+		if regexpMatches(PatternLanguageMarker, string(innerHTML(element))) {
 			// A language marker (ignored)
 			continue
-		} else {
-			// A paragraph (anything else)
-			block := ContentBlock{
-				Type:   "paragraph",
-				Anchor: paragraph.Attrs()["id"],
-				Paragraph: Paragraph{
-					Content: HTMLString(paragraph.HTML()),
-				},
-			}
-			block.ID = block.generateID()
+		}
+
+		block, ok, detectErr := ctx.detectBlock(element)
+		if detectErr != nil {
+			err = detectErr
+			return
+		}
+		if ok {
 			blocks = append(blocks, block)
 		}
 	}
+	fillCodeLanguages(blocks, codeListings(markdownRaw))
+
 	if h1 := htmlTree.Find("h1"); h1.Error == nil {
 		title = innerHTML(h1)
 		for _, div := range htmlTree.FindAll("div") {
@@ -573,11 +618,13 @@ func (ctx *RunContext) ParseSingleLanguageDescription(markdownRaw string) (title
 				err = fmt.Errorf("two different media blocks have the exact same source")
 			case "link":
 				err = fmt.Errorf("two different links have the exact same URL")
+			default:
+				err = fmt.Errorf("two different %s blocks have the exact same content", block.Type)
 			}
 			return
 		}
 		seenBlockIDs.Put(block.ID)
-		if block.Type != "paragraph" {
+		if !block.Type.IsParagraph() && !block.Type.IsHeading() && !block.Type.IsQuote() {
 			continue
 		}
 		if strings.HasPrefix(string(block.Paragraph.Content), "<pre>") && strings.HasSuffix(string(block.Paragraph.Content), "</pre>") {
@@ -648,6 +695,19 @@ func ExtractAttributesFromAlt(alt string) (string, MediaAttributes) {
 	return altText, attrs
 }
 
+// ExtractPageFromSource removes the #page=N fragment (as understood by PDF viewers) from the end of a media source, and returns the source without it as well as the page number, 0 if there's none.
+func ExtractPageFromSource(source string) (string, int) {
+	match := regexp.MustCompile(PatternPageFragment).FindStringSubmatchIndex(source)
+	if match == nil {
+		return source, 0
+	}
+	page, err := strconv.Atoi(source[match[2]:match[3]])
+	if err != nil {
+		return source, 0
+	}
+	return source[:match[0]], page
+}
+
 func isMediaEmbedAttribute(char rune) bool {
 	return char == RuneAutoplay || char == RuneLoop || char == RuneHideControls
 }
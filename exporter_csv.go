@@ -0,0 +1,78 @@
+package ortfodb
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+type CsvExporterOptions struct {
+	// Format of the spreadsheet: csv (comma-separated) or tsv (tab-separated). Defaults to csv.
+	Format string `yaml:"format,omitempty" jsonschema:"enum=csv,enum=tsv"`
+	// File to write the spreadsheet to. Relative paths are relative to the output database file's directory. Defaults to the output database file, with the format's extension instead of .json.
+	Output string `yaml:"output,omitempty"`
+}
+
+type CsvExporter struct {
+}
+
+func (e *CsvExporter) OptionsType() any {
+	return CsvExporterOptions{}
+}
+
+func (e *CsvExporter) Name() string {
+	return "csv"
+}
+
+func (e *CsvExporter) Description() string {
+	return "Export the metadata of works to a CSV or TSV spreadsheet, one row per work: its ID, titles, dates, tags, technologies, whether it is a work in progress or private, and custom fields. Edited spreadsheets can be applied back to description files with ortfodb import-csv."
+}
+
+func (e *CsvExporter) Before(ctx *RunContext, opts ExporterOptions) error {
+	options := GetExporterOptions[CsvExporterOptions](e, opts)
+	if options.Format != "" {
+		if _, err := SpreadsheetDelimiter(options.Format); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *CsvExporter) Export(ctx *RunContext, opts ExporterOptions, work *Work) error {
+	return nil
+}
+
+func (e *CsvExporter) After(ctx *RunContext, opts ExporterOptions, db *Database) error {
+	options := GetExporterOptions[CsvExporterOptions](e, opts)
+	if options.Format == "" {
+		options.Format = SpreadsheetFormatCSV
+	}
+	delimiter, err := SpreadsheetDelimiter(options.Format)
+	if err != nil {
+		return err
+	}
+	output := options.Output
+	if output == "" {
+		output = strings.TrimSuffix(ctx.OutputDatabaseFile, filepath.Ext(ctx.OutputDatabaseFile)) + "." + options.Format
+	} else if !filepath.IsAbs(output) {
+		output = filepath.Join(filepath.Dir(ctx.OutputDatabaseFile), output)
+	}
+
+	works := db.WorksSlice()
+	slices.SortFunc(works, func(a, b Work) int {
+		return strings.Compare(a.ID, b.ID)
+	})
+
+	file, err := os.Create(output)
+	if err != nil {
+		return fmt.Errorf("while creating %s: %w", output, err)
+	}
+	defer file.Close()
+	if err := WriteSpreadsheet(file, works, ctx.Config.CustomFields, delimiter); err != nil {
+		return fmt.Errorf("while writing spreadsheet to %s: %w", output, err)
+	}
+	ExporterLogCustom(e, "Wrote", "green", "metadata of %d works to %s", len(works), output)
+	return nil
+}
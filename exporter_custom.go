@@ -2,11 +2,19 @@ package ortfodb
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"maps"
+	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
 	"text/template"
+	"time"
 
 	"github.com/Masterminds/sprig/v3"
 	"gopkg.in/alessio/shellescape.v1"
@@ -16,13 +24,32 @@ import (
 )
 
 type CustomExporter struct {
-	data     map[string]any
+	data map[string]any
+	// Outputs captured by the before commands, available to the commands of the other hooks. Only written by Before, which runs before works are exported
+	captured map[string]any
 	name     string
 	Manifest ExporterManifest
 	verbose  bool
 	dryRun   bool
 }
 
+// commandsRun is the state of one run of the commands of a hook. It is not kept in the exporter, as works are exported concurrently.
+type commandsRun struct {
+	// Outputs of commands, by the name they are captured as. They are available in .Data, see ExporterCommand.Capture
+	captured map[string]any
+	// Whether the on error commands are running
+	handlingError bool
+}
+
+// newCommandsRun returns the state of a run of commands that starts with the outputs captured by the before commands.
+func (e *CustomExporter) newCommandsRun() *commandsRun {
+	captured := maps.Clone(e.captured)
+	if captured == nil {
+		captured = make(map[string]any)
+	}
+	return &commandsRun{captured: captured}
+}
+
 func (e *CustomExporter) VerifyRequiredPrograms() error {
 	missingPrograms := make([]string, 0, len(e.Manifest.Requires))
 	for _, program := range e.Manifest.Requires {
@@ -57,114 +84,220 @@ func (e *CustomExporter) Before(ctx *RunContext, opts ExporterOptions) error {
 	}
 	ll.Debug("Setting user-supplied data for exporter %s: %v", e.name, opts)
 	e.data = merge(e.Manifest.Data, opts)
+	e.captured = nil
 	if e.Manifest.Verbose {
 		ExporterLogCustom(e, "Debug", "magenta", ".Data for %s is %v", e.name, e.data)
 	}
-	return e.runCommands(ctx, e.verbose, e.Manifest.Before, map[string]any{})
+	run := e.newCommandsRun()
+	err = e.runCommands(ctx, run, e.verbose, e.Manifest.Before, map[string]any{})
+	e.captured = run.captured
+	return err
 
 }
 
 func (e *CustomExporter) Export(ctx *RunContext, opts ExporterOptions, work *Work) error {
-	return e.runCommands(ctx, e.verbose, e.Manifest.Work, map[string]any{
+	return e.runCommands(ctx, e.newCommandsRun(), e.verbose, e.Manifest.Work, map[string]any{
 		"Work": work,
 	})
 }
 
+// After runs the after commands without any changes: BuildSome calls AfterChanges instead.
 func (e *CustomExporter) After(ctx *RunContext, opts ExporterOptions, db *Database) error {
-	return e.runCommands(ctx, e.verbose, e.Manifest.After, map[string]any{
+	return e.AfterChanges(ctx, opts, db, Changeset{})
+}
+
+func (e *CustomExporter) AfterChanges(ctx *RunContext, opts ExporterOptions, db *Database, changes Changeset) error {
+	return e.runCommands(ctx, e.newCommandsRun(), e.verbose, e.Manifest.After, map[string]any{
 		"Database": db,
+		"Changes":  changes,
 	})
 }
 
-func (e *CustomExporter) runCommands(ctx *RunContext, verbose bool, commands []ExporterCommand, additionalData map[string]any) error {
+func (e *CustomExporter) runCommands(ctx *RunContext, run *commandsRun, verbose bool, commands []ExporterCommand, additionalData map[string]any) error {
 	for _, command := range commands {
-		if command.Run != "" {
-			commandlines_, err := e.renderCommandParts(ctx, []string{command.Run}, additionalData, true)
+		if command.If != "" {
+			condition, err := e.renderCommandParts(ctx, []string{"{{ if " + command.If + " }}true{{ end }}"}, additionalData, run.captured, true)
 			if err != nil {
-				return fmt.Errorf("while rendering commandline for run instruction: %w", err)
+				return fmt.Errorf("while evaluating condition %q: %w", command.If, err)
 			}
-
-			commandline := commandlines_[0]
-			if commandline == "" {
+			if condition[0] != "true" {
 				continue
 			}
-			if verbose && (len(commandline) <= 100 || debugging) {
-				ExporterLogCustom(e, "Running", "yellow", commandline)
-			}
+		}
 
-			proc := exec.Command("bash", "-c", commandline)
-			ll.Debug("exec.Command = %v", commandline)
-			proc.Dir = filepath.Dir(ctx.Config.source)
-			stderr, _ := proc.StderrPipe()
-			stdout, _ := proc.StdoutPipe()
-			err = proc.Start()
+		if command.Run == "" {
+			logParts, err := e.renderCommandParts(ctx, command.Log, additionalData, run.captured, true)
 			if err != nil {
-				return fmt.Errorf("while starting command %q: %w", commandline, err)
+				return fmt.Errorf("while rendering parts for a log instruction: %w", err)
 			}
 
-			outputBuffer := new(strings.Builder)
-			outputChannel := make(chan string)
-
-			// Goroutine to read from stdout and send lines to the output channel
-			go func() {
-				scanner := bufio.NewScanner(stdout)
-				for scanner.Scan() {
-					outputChannel <- scanner.Text()
-				}
-			}()
-
-			// Goroutine to read from stderr and send lines to the output channel
-			go func() {
-				scanner := bufio.NewScanner(stderr)
-				for scanner.Scan() {
-					outputChannel <- scanner.Text()
-				}
-			}()
-
-			linesPrintedCount := 0
-
-			go func() {
-				for line := range outputChannel {
-					if linesPrintedCount > 5 {
-						// Clear the line fives lines after the first output
-						fmt.Print("\033[5A\033[K")
-					}
-					outputBuffer.WriteString(line + "\n")
-					ExporterLogCustomNoFormatting(e, ">", "blue", line)
-					if linesPrintedCount > 5 {
-						// Go back to last line
-						fmt.Print("\033[5B")
-					}
-					linesPrintedCount++
-				}
-			}()
-
-			err = proc.Wait()
-			close(outputChannel)
-			if err != nil {
-				// ExporterLogCustomNoFormatting(e, "Error", "red", fmt.Sprintf("While running %s\n%s", commandline, outputBuffer.String()))
-				return fmt.Errorf("while running %s: %w", commandline, err)
-			} else {
-				// Hide output atfter it's done if there's no errors
-				for i := 0; i < 6 && i < linesPrintedCount; i++ {
-					if debugging {
-						ll.Debug("would clear line %d", i)
-					} else {
-						fmt.Print("\033[1A\033[K")
-					}
-				}
+			if strings.TrimSpace(logParts[2]) != "" {
+				ExporterLogCustom(e, logParts[0], logParts[1], logParts[2])
+			}
+			continue
+		}
+
+		var err error
+		for attempt := 0; attempt <= command.Retries; attempt++ {
+			if attempt > 0 {
+				ExporterLogCustom(e, "Retrying", "yellow", "(%d/%d) %s", attempt, command.Retries, err)
 			}
+			if err = e.runCommand(ctx, run, verbose, command, additionalData); err == nil {
+				break
+			}
+		}
+		if err == nil {
+			continue
+		}
+		if command.ContinueOnError {
+			ExporterLogCustom(e, "Failed", "red", "%s, continuing", err)
+			continue
+		}
+		if len(e.Manifest.OnError) > 0 && !run.handlingError {
+			// Failures of on error commands must not run them again
+			run.handlingError = true
+			onErrorErr := e.runCommands(ctx, run, verbose, e.Manifest.OnError, merge(additionalData, map[string]any{"Error": err.Error()}))
+			run.handlingError = false
+			if onErrorErr != nil {
+				return fmt.Errorf("%w (on error commands failed too: %s)", err, onErrorErr)
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+// runCommand runs the run instruction of the command once, in its directory and environment, and captures its output if asked to.
+func (e *CustomExporter) runCommand(ctx *RunContext, run *commandsRun, verbose bool, command ExporterCommand, additionalData map[string]any) error {
+	commandlines_, err := e.renderCommandParts(ctx, []string{command.Run}, additionalData, run.captured, true)
+	if err != nil {
+		return fmt.Errorf("while rendering commandline for run instruction: %w", err)
+	}
+
+	commandline := commandlines_[0]
+	if commandline == "" {
+		return nil
+	}
+	if verbose && (len(commandline) <= 100 || debugging) {
+		ExporterLogCustom(e, "Running", "yellow", commandline)
+	}
+
+	directory := filepath.Dir(ctx.Config.source)
+	if command.Cwd != "" {
+		cwd, err := e.renderCommandParts(ctx, []string{command.Cwd}, additionalData, run.captured, true)
+		if err != nil {
+			return fmt.Errorf("while rendering cwd: %w", err)
+		}
+		if filepath.IsAbs(cwd[0]) {
+			directory = cwd[0]
 		} else {
-			logParts, err := e.renderCommandParts(ctx, command.Log, additionalData, true)
-			if err != nil {
-				return fmt.Errorf("while rendering parts for a log instruction: %w", err)
+			directory = filepath.Join(directory, cwd[0])
+		}
+	}
+
+	env := os.Environ()
+	names := mapKeys(command.Env)
+	slices.Sort(names)
+	for _, name := range names {
+		value, err := e.renderCommandParts(ctx, []string{command.Env[name]}, additionalData, run.captured, true)
+		if err != nil {
+			return fmt.Errorf("while rendering environment variable %s: %w", name, err)
+		}
+		env = append(env, name+"="+value[0])
+	}
+
+	procCtx := context.Background()
+	if command.Timeout > 0 {
+		var cancel context.CancelFunc
+		procCtx, cancel = context.WithTimeout(procCtx, time.Duration(command.Timeout)*time.Second)
+		defer cancel()
+	}
+
+	proc := exec.CommandContext(procCtx, "bash", "-c", commandline)
+	ll.Debug("exec.Command = %v", commandline)
+	proc.Dir = directory
+	proc.Env = env
+	// Don't wait forever for processes started by the command that keep its output open
+	proc.WaitDelay = time.Second
+	stdoutReader, stdoutWriter := io.Pipe()
+	stderrReader, stderrWriter := io.Pipe()
+	captured := new(strings.Builder)
+	if command.Capture != "" {
+		proc.Stdout = io.MultiWriter(stdoutWriter, captured)
+	} else {
+		proc.Stdout = stdoutWriter
+	}
+	proc.Stderr = stderrWriter
+	err = proc.Start()
+	if err != nil {
+		return fmt.Errorf("while starting command %q: %w", commandline, err)
+	}
+
+	outputChannel := make(chan string)
+	var readers sync.WaitGroup
+
+	// Goroutines to read from stdout and stderr and send lines to the output channel
+	for _, reader := range []io.Reader{stdoutReader, stderrReader} {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			scanner := bufio.NewScanner(reader)
+			for scanner.Scan() {
+				outputChannel <- scanner.Text()
 			}
+			// Lines too long for the scanner must still be read, or the command would block
+			io.Copy(io.Discard, reader)
+		}()
+	}
 
-			if strings.TrimSpace(logParts[2]) != "" {
-				ExporterLogCustom(e, logParts[0], logParts[1], logParts[2])
+	linesPrintedCount := 0
+	printed := make(chan struct{})
+
+	go func() {
+		for line := range outputChannel {
+			if linesPrintedCount > 5 {
+				// Clear the line fives lines after the first output
+				fmt.Print("\033[5A\033[K")
+			}
+			ExporterLogCustomNoFormatting(e, ">", "blue", line)
+			if linesPrintedCount > 5 {
+				// Go back to last line
+				fmt.Print("\033[5B")
 			}
+			linesPrintedCount++
+		}
+		close(printed)
+	}()
+
+	err = proc.Wait()
+	if errors.Is(err, exec.ErrWaitDelay) {
+		// The command itself succeeded
+		err = nil
+	}
+	stdoutWriter.Close()
+	stderrWriter.Close()
+	readers.Wait()
+	close(outputChannel)
+	<-printed
+	if err != nil {
+		if procCtx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("%s timed out after %d seconds", commandline, command.Timeout)
+		}
+		return fmt.Errorf("while running %s: %w", commandline, err)
+	}
+
+	// Hide output atfter it's done if there's no errors
+	for i := 0; i < 6 && i < linesPrintedCount; i++ {
+		if debugging {
+			ll.Debug("would clear line %d", i)
+		} else {
+			fmt.Print("\033[1A\033[K")
 		}
 	}
+
+	if command.Capture != "" {
+		run.captured[command.Capture] = strings.TrimSuffix(captured.String(), "\n")
+	}
 	return nil
 }
 
@@ -181,7 +314,7 @@ var funcmap = template.FuncMap{
 	},
 }
 
-func (e *CustomExporter) renderCommandParts(ctx *RunContext, commands []string, additionalData map[string]any, recursive bool) ([]string, error) {
+func (e *CustomExporter) renderCommandParts(ctx *RunContext, commands []string, additionalData map[string]any, captured map[string]any, recursive bool) ([]string, error) {
 	output := make([]string, 0, len(commands))
 	for _, command := range commands {
 		tmpl, err := template.New("top").Funcs(sprig.TxtFuncMap()).Funcs(funcmap).Parse(command)
@@ -191,12 +324,14 @@ func (e *CustomExporter) renderCommandParts(ctx *RunContext, commands []string,
 		var buf strings.Builder
 		renderedData := e.data
 		if recursive {
-			renderedData, err = e.renderData(ctx)
+			renderedData, err = e.renderData(ctx, captured)
 			if err != nil {
 				return []string{}, fmt.Errorf("while rendering data for command part: %w", err)
 			}
 
 		}
+		// Captured outputs are not templates
+		renderedData = merge(renderedData, captured)
 		ll.DebugNoColor("rendering command part %q, data=%v; renderedData=%v", command, e.data, renderedData)
 		completeData := merge(additionalData, map[string]any{
 			"Data":    renderedData,
@@ -214,12 +349,12 @@ func (e *CustomExporter) renderCommandParts(ctx *RunContext, commands []string,
 	return output, nil
 }
 
-func (e *CustomExporter) renderData(ctx *RunContext) (map[string]any, error) {
+func (e *CustomExporter) renderData(ctx *RunContext, captured map[string]any) (map[string]any, error) {
 	rendered := make(map[string]any)
 	for key, value := range e.data {
 		switch value := value.(type) {
 		case string:
-			_rendered, err := e.renderCommandParts(ctx, []string{value}, map[string]any{}, false)
+			_rendered, err := e.renderCommandParts(ctx, []string{value}, map[string]any{}, captured, false)
 			if err != nil {
 				return rendered, fmt.Errorf("while rendering %q: %w", value, err)
 			}
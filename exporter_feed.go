@@ -0,0 +1,442 @@
+package ortfodb
+
+import (
+	"encoding/xml"
+	"fmt"
+	"mime"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+
+	ll "github.com/ewen-lbh/label-logger-go"
+	jsoniter "github.com/json-iterator/go"
+)
+
+// Formats of the feeds written by the feed exporter.
+const (
+	FeedFormatRSS  = "rss"
+	FeedFormatAtom = "atom"
+	FeedFormatJSON = "json"
+)
+
+type FeedExporterOptions struct {
+	// Title of the feeds. Required.
+	Title string `yaml:"title"`
+	// Description of the feeds.
+	Description string `yaml:"description,omitempty"`
+	// Name of the author of the works.
+	Author string `yaml:"author,omitempty"`
+	// URL of the website that shows the works. Required.
+	BaseURL string `yaml:"base url"`
+	// URL of a work's page, as a Go template. Available variables: .BaseURL, .ID and .Lang. Defaults to {{ .BaseURL }}/{{ .ID }}.
+	WorkURL string `yaml:"work url,omitempty"`
+	// URL the media directory is served at, used for thumbnails. Defaults to the base URL.
+	MediaURL string `yaml:"media url,omitempty"`
+	// Formats to write: rss, atom and json (JSON Feed). Defaults to all of them.
+	Formats []string `yaml:"formats,omitempty" jsonschema:"enum=rss,enum=atom,enum=json"`
+	// Where to write the feeds, as a Go template. Available variables: .Lang and .Format. Relative paths are relative to the output database file's directory. Defaults to feed.{{ .Lang }}.{{ .Format }}
+	Output string `yaml:"output,omitempty"`
+	// Maximum number of works in each feed. All works are included if 0.
+	Limit int `yaml:"limit,omitempty"`
+	// Only include works that have at least one of these tags.
+	Tags []string `yaml:"tags,omitempty"`
+	// Exclude works that have any of these tags.
+	ExcludeTags []string `yaml:"exclude tags,omitempty"`
+	// Include works marked as work in progress.
+	WIP bool `yaml:"wip,omitempty"`
+	// Include works marked as private.
+	Private bool `yaml:"private,omitempty"`
+	// Size of the thumbnails linked in the feeds. The closest available size is used. Defaults to 600.
+	ThumbnailSize int `yaml:"thumbnail size,omitempty"`
+}
+
+type FeedExporter struct {
+}
+
+// feedItem is a work, as it appears in the feed of a language.
+type feedItem struct {
+	ID        string
+	URL       string
+	Title     string
+	Summary   HTMLString
+	Thumbnail string
+	Tags      []string
+	Date      time.Time
+}
+
+func (e *FeedExporter) OptionsType() any {
+	return FeedExporterOptions{}
+}
+
+func (e *FeedExporter) Name() string {
+	return "feed"
+}
+
+func (e *FeedExporter) Description() string {
+	return "Export the most recent works as RSS 2.0, Atom and JSON Feed files, one per language. Works are sorted by creation date, and private and work-in-progress works are left out unless asked otherwise."
+}
+
+func (e *FeedExporter) Before(ctx *RunContext, opts ExporterOptions) error {
+	options := GetExporterOptions[FeedExporterOptions](e, opts)
+	if options.Title == "" {
+		return fmt.Errorf("the title option is required")
+	}
+	if options.BaseURL == "" {
+		return fmt.Errorf("the base url option is required")
+	}
+	for _, format := range options.Formats {
+		if !slices.Contains([]string{FeedFormatRSS, FeedFormatAtom, FeedFormatJSON}, format) {
+			return fmt.Errorf("unknown feed format %q, must be one of rss, atom or json", format)
+		}
+	}
+	return nil
+}
+
+func (e *FeedExporter) Export(ctx *RunContext, opts ExporterOptions, work *Work) error {
+	return nil
+}
+
+func (e *FeedExporter) After(ctx *RunContext, opts ExporterOptions, db *Database) error {
+	options := GetExporterOptions[FeedExporterOptions](e, opts)
+	if len(options.Formats) == 0 {
+		options.Formats = []string{FeedFormatRSS, FeedFormatAtom, FeedFormatJSON}
+	}
+	if options.Output == "" {
+		options.Output = "feed.{{ .Lang }}.{{ .Format }}"
+	}
+	if options.WorkURL == "" {
+		options.WorkURL = "{{ .BaseURL }}/{{ .ID }}"
+	}
+	if options.MediaURL == "" {
+		options.MediaURL = options.BaseURL
+	}
+	if options.ThumbnailSize == 0 {
+		options.ThumbnailSize = 600
+	}
+
+	outputTemplate, err := template.New("output").Parse(options.Output)
+	if err != nil {
+		return fmt.Errorf("while parsing output filename template %q: %w", options.Output, err)
+	}
+	workURLTemplate, err := template.New("work url").Parse(options.WorkURL)
+	if err != nil {
+		return fmt.Errorf("while parsing work url template %q: %w", options.WorkURL, err)
+	}
+
+	for _, lang := range db.Languages() {
+		items, err := e.items(db, lang, options, workURLTemplate)
+		if err != nil {
+			return fmt.Errorf("while collecting works for the %s feed: %w", lang, err)
+		}
+
+		for _, format := range options.Formats {
+			var outputFilename strings.Builder
+			if err := outputTemplate.Execute(&outputFilename, map[string]any{"Lang": lang, "Format": format}); err != nil {
+				return fmt.Errorf("while computing output filename of the %s %s feed: %w", lang, format, err)
+			}
+			output := outputFilename.String()
+			if !filepath.IsAbs(output) {
+				output = filepath.Join(filepath.Dir(ctx.OutputDatabaseFile), output)
+			}
+
+			var contents []byte
+			switch format {
+			case FeedFormatRSS:
+				contents, err = rssFeed(options, lang, items)
+			case FeedFormatAtom:
+				contents, err = atomFeed(options, lang, items)
+			case FeedFormatJSON:
+				contents, err = jsonFeed(options, lang, items)
+			}
+			if err != nil {
+				return fmt.Errorf("while generating the %s %s feed: %w", lang, format, err)
+			}
+
+			if err := os.WriteFile(output, contents, 0644); err != nil {
+				return fmt.Errorf("while writing the %s %s feed to %s: %w", lang, format, output, err)
+			}
+			ExporterLogCustom(e, "Wrote", "green", "%s feed in %s with %d works to %s", format, lang, len(items), output)
+		}
+	}
+	return nil
+}
+
+// items returns the works to include in the feed of the given language, most recent first.
+// Undated works are left out, as feed entries need a date.
+func (e *FeedExporter) items(db *Database, lang string, options FeedExporterOptions, workURLTemplate *template.Template) ([]feedItem, error) {
+	selection := ProfileConfiguration{
+		Private:     options.Private,
+		WIP:         options.WIP,
+		Tags:        options.Tags,
+		ExcludeTags: options.ExcludeTags,
+	}
+	now := time.Now()
+	items := make([]feedItem, 0)
+	for _, work := range db.WorksByDate() {
+		if options.Limit > 0 && len(items) >= options.Limit {
+			break
+		}
+		if !selection.Includes(work, now) {
+			continue
+		}
+		date := work.Metadata.CreatedAt()
+		if date.Year() == 9999 {
+			ll.Debug("Leaving %s out of the %s feed, as it has no date", work.ID, lang)
+			continue
+		}
+
+		var workURL strings.Builder
+		err := workURLTemplate.Execute(&workURL, map[string]any{
+			"BaseURL": strings.TrimSuffix(options.BaseURL, "/"),
+			"ID":      work.ID,
+			"Lang":    lang,
+		})
+		if err != nil {
+			return items, fmt.Errorf("while computing url of %s: %w", work.ID, err)
+		}
+
+		item := feedItem{
+			ID:    work.ID,
+			URL:   workURL.String(),
+			Title: work.Content.Localize(lang).Title.String(),
+			Tags:  work.Metadata.Tags,
+			Date:  date,
+		}
+		if item.Title == "" {
+			item.Title = work.ID
+		}
+		if found, paragraph := work.FirstParagraph(lang); found {
+			item.Summary = paragraph.Content
+		}
+		if thumbnail := work.ThumbnailPath(lang, options.ThumbnailSize); thumbnail != "" {
+			item.Thumbnail = strings.TrimSuffix(options.MediaURL, "/") + "/" + strings.TrimPrefix(string(thumbnail), "/")
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// feedUpdatedAt returns the date of the most recent item, or the current time if there are no items.
+func feedUpdatedAt(items []feedItem) time.Time {
+	if len(items) == 0 {
+		return time.Now()
+	}
+	return items[0].Date
+}
+
+type rssDocument struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Media   string     `xml:"xmlns:media,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	Language      string    `xml:"language,omitempty"`
+	LastBuildDate string    `xml:"lastBuildDate"`
+	Generator     string    `xml:"generator"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string        `xml:"title"`
+	Link        string        `xml:"link"`
+	GUID        string        `xml:"guid"`
+	PubDate     string        `xml:"pubDate"`
+	Description string        `xml:"description,omitempty"`
+	Categories  []string      `xml:"category"`
+	Thumbnail   *rssThumbnail `xml:"media:thumbnail,omitempty"`
+}
+
+type rssThumbnail struct {
+	URL string `xml:"url,attr"`
+}
+
+func rssFeed(options FeedExporterOptions, lang string, items []feedItem) ([]byte, error) {
+	document := rssDocument{
+		Version: "2.0",
+		Media:   "http://search.yahoo.com/mrss/",
+		Channel: rssChannel{
+			Title:         options.Title,
+			Link:          options.BaseURL,
+			Description:   options.Description,
+			Language:      feedLanguage(lang),
+			LastBuildDate: feedUpdatedAt(items).Format(time.RFC1123Z),
+			Generator:     "ortfodb " + Version,
+		},
+	}
+	for _, item := range items {
+		rss := rssItem{
+			Title:       item.Title,
+			Link:        item.URL,
+			GUID:        item.URL,
+			PubDate:     item.Date.Format(time.RFC1123Z),
+			Description: string(item.Summary),
+			Categories:  item.Tags,
+		}
+		if item.Thumbnail != "" {
+			rss.Thumbnail = &rssThumbnail{URL: item.Thumbnail}
+		}
+		document.Channel.Items = append(document.Channel.Items, rss)
+	}
+	return marshalFeedXML(document)
+}
+
+type atomDocument struct {
+	XMLName  xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Lang     string      `xml:"xml:lang,attr,omitempty"`
+	ID       string      `xml:"id"`
+	Title    string      `xml:"title"`
+	Subtitle string      `xml:"subtitle,omitempty"`
+	Updated  string      `xml:"updated"`
+	Link     atomLink    `xml:"link"`
+	Author   *atomAuthor `xml:"author,omitempty"`
+	Entries  []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomSummary struct {
+	Type    string `xml:"type,attr"`
+	Content string `xml:",chardata"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomEntry struct {
+	ID         string         `xml:"id"`
+	Title      string         `xml:"title"`
+	Updated    string         `xml:"updated"`
+	Published  string         `xml:"published"`
+	Links      []atomLink     `xml:"link"`
+	Summary    *atomSummary   `xml:"summary,omitempty"`
+	Categories []atomCategory `xml:"category"`
+}
+
+func atomFeed(options FeedExporterOptions, lang string, items []feedItem) ([]byte, error) {
+	document := atomDocument{
+		Lang:     feedLanguage(lang),
+		ID:       options.BaseURL,
+		Title:    options.Title,
+		Subtitle: options.Description,
+		Updated:  feedUpdatedAt(items).Format(time.RFC3339),
+		Link:     atomLink{Href: options.BaseURL},
+	}
+	if options.Author != "" {
+		document.Author = &atomAuthor{Name: options.Author}
+	}
+	for _, item := range items {
+		entry := atomEntry{
+			ID:        item.URL,
+			Title:     item.Title,
+			Updated:   item.Date.Format(time.RFC3339),
+			Published: item.Date.Format(time.RFC3339),
+			Links:     []atomLink{{Href: item.URL, Rel: "alternate"}},
+		}
+		if item.Summary != "" {
+			entry.Summary = &atomSummary{Type: "html", Content: string(item.Summary)}
+		}
+		if item.Thumbnail != "" {
+			entry.Links = append(entry.Links, atomLink{Href: item.Thumbnail, Rel: "enclosure", Type: mime.TypeByExtension(filepath.Ext(item.Thumbnail))})
+		}
+		for _, tag := range item.Tags {
+			entry.Categories = append(entry.Categories, atomCategory{Term: tag})
+		}
+		document.Entries = append(document.Entries, entry)
+	}
+	return marshalFeedXML(document)
+}
+
+func marshalFeedXML(document any) ([]byte, error) {
+	contents, err := xml.MarshalIndent(document, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(contents, '\n')...), nil
+}
+
+type jsonFeedDocument struct {
+	Version     string           `json:"version"`
+	Title       string           `json:"title"`
+	HomePageURL string           `json:"home_page_url"`
+	Description string           `json:"description,omitempty"`
+	Language    string           `json:"language,omitempty"`
+	Authors     []jsonFeedAuthor `json:"authors,omitempty"`
+	Items       []jsonFeedItem   `json:"items"`
+}
+
+type jsonFeedAuthor struct {
+	Name string `json:"name"`
+}
+
+type jsonFeedItem struct {
+	ID            string   `json:"id"`
+	URL           string   `json:"url"`
+	Title         string   `json:"title"`
+	ContentHTML   string   `json:"content_html,omitempty"`
+	ContentText   string   `json:"content_text,omitempty"`
+	Summary       string   `json:"summary,omitempty"`
+	Image         string   `json:"image,omitempty"`
+	DatePublished string   `json:"date_published"`
+	Tags          []string `json:"tags,omitempty"`
+}
+
+func jsonFeed(options FeedExporterOptions, lang string, items []feedItem) ([]byte, error) {
+	document := jsonFeedDocument{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       options.Title,
+		HomePageURL: options.BaseURL,
+		Description: options.Description,
+		Language:    feedLanguage(lang),
+		Items:       make([]jsonFeedItem, 0, len(items)),
+	}
+	if options.Author != "" {
+		document.Authors = []jsonFeedAuthor{{Name: options.Author}}
+	}
+	for _, item := range items {
+		jsonItem := jsonFeedItem{
+			ID:            item.URL,
+			URL:           item.URL,
+			Title:         item.Title,
+			ContentHTML:   string(item.Summary),
+			Summary:       item.Summary.String(),
+			Image:         item.Thumbnail,
+			DatePublished: item.Date.Format(time.RFC3339),
+			Tags:          item.Tags,
+		}
+		// Items must have some content
+		if item.Summary == "" {
+			jsonItem.ContentText = item.Title
+		}
+		document.Items = append(document.Items, jsonItem)
+	}
+	contents, err := jsoniter.ConfigCompatibleWithStandardLibrary.MarshalIndent(document, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(contents, '\n'), nil
+}
+
+// feedLanguage returns the language code to declare in feeds, or an empty string for content that is not in a specific language.
+func feedLanguage(lang string) string {
+	if lang == "default" {
+		return ""
+	}
+	return lang
+}
@@ -0,0 +1,378 @@
+package ortfodb
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	ll "github.com/ewen-lbh/label-logger-go"
+	"github.com/invopop/jsonschema"
+	jsoniter "github.com/json-iterator/go"
+)
+
+// PluginProtocolVersion is the version of the exporter plugin protocol spoken by ortfodb. See /db/exporters/plugins.md.
+const PluginProtocolVersion = 1
+
+// PluginExporterPrefix prefixes exporter names that refer to plugins installed in the PATH: plugin:name refers to the ortfodb-exporter-name executable.
+const PluginExporterPrefix = "plugin:"
+
+// PluginExecutablePrefix prefixes the names of executables of plugins installed in the PATH.
+const PluginExecutablePrefix = "ortfodb-exporter-"
+
+// PluginRequestTimeout is the time plugins have to respond to a request. Plugins that don't respond in time are stopped.
+var PluginRequestTimeout = 5 * time.Minute
+
+// PluginExitTimeout is the time plugins have to exit once their standard input is closed. Plugins that are still running after that are stopped.
+var PluginExitTimeout = 10 * time.Second
+
+// PluginExporter is an exporter that runs in another process, and that ortfodb talks to with JSON-RPC 2.0 messages over its standard input and output, one message per line.
+// The process is started when the exporter is loaded, and is expected to exit when its standard input is closed by Close.
+type PluginExporter struct {
+	name    string
+	command string
+	info    pluginInfo
+
+	process *exec.Cmd
+	stdin   io.WriteCloser
+	stdout  *bufio.Reader
+
+	// Requests are sent one at a time, as works are exported concurrently
+	mu     sync.Mutex
+	lastID int
+	// Set once the plugin was killed for not responding in time: other requests would never get a response
+	killed bool
+	closed bool
+}
+
+// pluginInfo is the result of the initialize request.
+type pluginInfo struct {
+	ProtocolVersion int                `json:"protocolVersion"`
+	Name            string             `json:"name"`
+	Description     string             `json:"description"`
+	OptionsSchema   *jsonschema.Schema `json:"optionsSchema"`
+	Changes         bool               `json:"changes"`
+}
+
+// pluginContext describes the build to plugins, in before requests.
+type pluginContext struct {
+	ConfigurationFile  string `json:"configurationFile"`
+	ProjectsDirectory  string `json:"projectsDirectory"`
+	MediaDirectory     string `json:"mediaDirectory"`
+	OutputDatabaseFile string `json:"outputDatabaseFile"`
+}
+
+type pluginMessage struct {
+	JSONRPC string              `json:"jsonrpc"`
+	ID      *int                `json:"id,omitempty"`
+	Method  string              `json:"method,omitempty"`
+	Params  any                 `json:"params,omitempty"`
+	Result  jsoniter.RawMessage `json:"result,omitempty"`
+	Error   *pluginError        `json:"error,omitempty"`
+}
+
+type pluginError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    any    `json:"data,omitempty"`
+}
+
+type pluginLogParams struct {
+	// One of debug, info, warning or error
+	Level   string `json:"level"`
+	Message string `json:"message"`
+	// Verb and color of info messages, like the log instruction of YAML exporters
+	Verb  string `json:"verb"`
+	Color string `json:"color"`
+}
+
+// isPluginExporter returns true if the exporter name refers to a plugin: plugin:name, or a path to an executable file that is not a YAML manifest.
+func isPluginExporter(name string, path string) bool {
+	if strings.HasPrefix(name, PluginExporterPrefix) {
+		return true
+	}
+	if extension := filepath.Ext(path); extension == ".yaml" || extension == ".yml" {
+		return false
+	}
+	stat, err := os.Stat(path)
+	return err == nil && !stat.IsDir() && stat.Mode()&0o111 != 0
+}
+
+// LoadPluginExporter starts the plugin executable at command and initializes it. name is the name of the exporter in the configuration file.
+func LoadPluginExporter(name string, command string) (*PluginExporter, error) {
+	if strings.HasPrefix(name, PluginExporterPrefix) {
+		executable := PluginExecutablePrefix + strings.TrimPrefix(name, PluginExporterPrefix)
+		path, err := exec.LookPath(executable)
+		if err != nil {
+			return nil, fmt.Errorf("could not find %s in the PATH: %w", executable, err)
+		}
+		command = path
+	}
+
+	plugin := &PluginExporter{name: name, command: command}
+	plugin.process = exec.Command(command)
+	plugin.process.Stderr = &pluginStderr{plugin: plugin}
+	// Don't wait forever for processes started by the plugin that would keep its standard error open
+	plugin.process.WaitDelay = PluginExitTimeout
+	stdin, err := plugin.process.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("while connecting to the standard input of %s: %w", command, err)
+	}
+	stdout, err := plugin.process.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("while connecting to the standard output of %s: %w", command, err)
+	}
+	plugin.stdin, plugin.stdout = stdin, bufio.NewReader(stdout)
+	if err := plugin.process.Start(); err != nil {
+		return nil, fmt.Errorf("while starting %s: %w", command, err)
+	}
+
+	err = plugin.call("initialize", map[string]any{
+		"protocolVersion": PluginProtocolVersion,
+		"ortfodbVersion":  Version,
+		"name":            name,
+	}, &plugin.info)
+	if err != nil {
+		plugin.Close()
+		return nil, fmt.Errorf("while initializing plugin %s: %w", name, err)
+	}
+	if plugin.info.ProtocolVersion != PluginProtocolVersion {
+		plugin.Close()
+		return nil, fmt.Errorf("plugin %s speaks version %d of the exporter plugin protocol, but ortfodb %s speaks version %d", name, plugin.info.ProtocolVersion, Version, PluginProtocolVersion)
+	}
+	ll.Debug("Initialized plugin %s (%s): %#v", name, command, plugin.info)
+	return plugin, nil
+}
+
+func (p *PluginExporter) Name() string {
+	return p.name
+}
+
+func (p *PluginExporter) Description() string {
+	return p.info.Description
+}
+
+func (p *PluginExporter) OptionsType() any {
+	return map[string]any{}
+}
+
+// OptionsSchema returns the JSON schema of the plugin's options, if it declared one.
+func (p *PluginExporter) OptionsSchema() *jsonschema.Schema {
+	return p.info.OptionsSchema
+}
+
+func (p *PluginExporter) Before(ctx *RunContext, opts ExporterOptions) error {
+	return p.call("before", map[string]any{
+		"options": opts,
+		"context": pluginContext{
+			ConfigurationFile:  ctx.Flags.Config,
+			ProjectsDirectory:  ctx.DatabaseDirectory,
+			MediaDirectory:     ctx.Config.Media.At,
+			OutputDatabaseFile: ctx.OutputDatabaseFile,
+		},
+	}, nil)
+}
+
+func (p *PluginExporter) Export(ctx *RunContext, opts ExporterOptions, work *Work) error {
+	return p.call("export", map[string]any{
+		"options": opts,
+		"work":    work,
+	}, nil)
+}
+
+func (p *PluginExporter) After(ctx *RunContext, opts ExporterOptions, db *Database) error {
+	return p.call("after", map[string]any{
+		"options":  opts,
+		"database": db,
+	}, nil)
+}
+
+// AfterChanges sends the changes since the previous build along with the database, if the plugin asked for them during the handshake.
+func (p *PluginExporter) AfterChanges(ctx *RunContext, opts ExporterOptions, db *Database, changes Changeset) error {
+	if !p.info.Changes {
+		return p.After(ctx, opts, db)
+	}
+	return p.call("after", map[string]any{
+		"options":  opts,
+		"database": db,
+		"changes":  changes,
+	}, nil)
+}
+
+// Close closes the standard input of the plugin, which tells it to exit, and waits for it to do so. Calling Close more than once does nothing.
+func (p *PluginExporter) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+
+	p.stdin.Close()
+	exited := make(chan error, 1)
+	go func() {
+		exited <- p.process.Wait()
+	}()
+	select {
+	case err := <-exited:
+		// Plugins that were killed already had their error reported
+		if err != nil && !p.killed {
+			return fmt.Errorf("plugin %s exited with an error: %w", p.name, err)
+		}
+		return nil
+	case <-time.After(PluginExitTimeout):
+		p.process.Process.Kill()
+		<-exited
+		return fmt.Errorf("plugin %s did not exit %s after its standard input was closed, it was stopped", p.name, PluginExitTimeout)
+	}
+}
+
+// call sends a request to the plugin and decodes its result into result (if not nil), handling log notifications sent in the meantime.
+// The plugin is stopped if it does not respond within PluginRequestTimeout.
+func (p *PluginExporter) call(method string, params any, result any) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed || p.killed {
+		return fmt.Errorf("plugin %s is not running anymore", p.name)
+	}
+
+	json := jsoniter.ConfigCompatibleWithStandardLibrary
+	p.lastID++
+	id := p.lastID
+	request, err := json.Marshal(pluginMessage{JSONRPC: "2.0", ID: &id, Method: method, Params: params})
+	if err != nil {
+		return fmt.Errorf("while encoding %s request: %w", method, err)
+	}
+
+	// Writing blocks if the plugin stops reading its standard input, so it is watched by the timeout too.
+	// Responses are read at the same time, as the plugin might not read the rest of a large request until its messages are read.
+	sent := make(chan error, 1)
+	go func() {
+		_, err := p.stdin.Write(append(request, '\n'))
+		sent <- err
+	}()
+	type response struct {
+		message pluginMessage
+		err     error
+	}
+	responded := make(chan response, 1)
+	go func() {
+		message, err := p.readResponse(method, id)
+		responded <- response{message, err}
+	}()
+
+	var message pluginMessage
+	timeout := time.After(PluginRequestTimeout)
+	for sent != nil || responded != nil {
+		select {
+		case err := <-sent:
+			if err != nil {
+				p.kill()
+				return fmt.Errorf("while sending %s request to %s: %w", method, p.command, err)
+			}
+			sent = nil
+		case response := <-responded:
+			if response.err != nil {
+				p.kill()
+				return response.err
+			}
+			message = response.message
+			responded = nil
+		case <-timeout:
+			p.kill()
+			return fmt.Errorf("plugin %s did not respond to %s in %s, it was stopped", p.name, method, PluginRequestTimeout)
+		}
+	}
+
+	if message.Error != nil {
+		return fmt.Errorf("plugin %s failed to handle %s: %s (code %d)", p.name, method, message.Error.Message, message.Error.Code)
+	}
+	if result != nil && len(message.Result) > 0 {
+		if err := json.Unmarshal(message.Result, result); err != nil {
+			return fmt.Errorf("invalid result for %s from %s: %w", method, p.command, err)
+		}
+	}
+	return nil
+}
+
+// kill stops the plugin, which also stops a write to its standard input or a read from its standard output that would still be going on. Requests are not sent to killed plugins anymore.
+func (p *PluginExporter) kill() {
+	p.process.Process.Kill()
+	p.killed = true
+}
+
+// readResponse reads messages from the plugin until the response to the request with the given id, handling log notifications sent in the meantime, and returns it.
+func (p *PluginExporter) readResponse(method string, id int) (pluginMessage, error) {
+	json := jsoniter.ConfigCompatibleWithStandardLibrary
+	for {
+		line, err := p.stdout.ReadBytes('\n')
+		if err != nil {
+			return pluginMessage{}, fmt.Errorf("while waiting for the response to %s from %s: %w", method, p.command, err)
+		}
+		if strings.TrimSpace(string(line)) == "" {
+			continue
+		}
+		var message pluginMessage
+		if err := json.Unmarshal(line, &message); err != nil {
+			return pluginMessage{}, fmt.Errorf("invalid message from %s: %w", p.command, err)
+		}
+		if message.Method != "" {
+			p.handleNotification(message)
+			continue
+		}
+		if message.ID == nil || *message.ID != id {
+			ll.Debug("Ignoring response to another request from plugin %s: %s", p.name, line)
+			continue
+		}
+		return message, nil
+	}
+}
+
+// handleNotification handles notifications sent by the plugin: only log is supported.
+func (p *PluginExporter) handleNotification(message pluginMessage) {
+	if message.Method != "log" {
+		ll.Debug("Ignoring unknown %s notification from plugin %s", message.Method, p.name)
+		return
+	}
+	var params pluginLogParams
+	json := jsoniter.ConfigCompatibleWithStandardLibrary
+	encoded, _ := json.Marshal(message.Params)
+	if err := json.Unmarshal(encoded, &params); err != nil {
+		ll.Debug("Invalid log notification from plugin %s: %s", p.name, err)
+		return
+	}
+	switch params.Level {
+	case "debug":
+		ll.Debug("[plugin %s] %s", p.name, params.Message)
+	case "warning":
+		ll.Warn("%s", params.Message)
+	case "error":
+		ExporterLogCustomNoFormatting(p, "Error", "red", params.Message)
+	default:
+		verb, color := params.Verb, params.Color
+		if verb == "" {
+			verb = "Info"
+		}
+		if color == "" {
+			color = "blue"
+		}
+		ExporterLogCustomNoFormatting(p, verb, color, params.Message)
+	}
+}
+
+// pluginStderr shows what plugins write to their standard error as debug messages.
+type pluginStderr struct {
+	plugin *PluginExporter
+}
+
+func (w *pluginStderr) Write(output []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimRight(string(output), "\n"), "\n") {
+		ll.Debug("[plugin %s stderr] %s", w.plugin.name, line)
+	}
+	return len(output), nil
+}
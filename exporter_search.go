@@ -0,0 +1,87 @@
+package ortfodb
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+type SearchExporterOptions struct {
+	// Directory to write the indexes to, with a directory per language. Relative paths are relative to the output database file's directory. Defaults to search.
+	Output string `yaml:"output,omitempty"`
+	// Weights of the indexed fields: title, text (paragraphs, headings, quotes and links), caption, alt, tags and technologies. Fields with a weight of 0 are not indexed. Defaults to title: 10, tags: 6, technologies: 6, caption: 3, alt: 2, text: 1.
+	Weights map[string]int `yaml:"weights,omitempty"`
+	// Split the postings of each index into files per first character of terms, so that clients only download the ones they need.
+	Shard bool `yaml:"shard,omitempty"`
+	// Include works marked as work in progress.
+	WIP bool `yaml:"wip,omitempty"`
+	// Include works marked as private.
+	Private bool `yaml:"private,omitempty"`
+}
+
+type SearchExporter struct {
+}
+
+func (e *SearchExporter) OptionsType() any {
+	return SearchExporterOptions{}
+}
+
+func (e *SearchExporter) Name() string {
+	return "search"
+}
+
+func (e *SearchExporter) Description() string {
+	return "Export a full-text search index of the works for each language, to search them from the browser without a server. Titles, paragraphs, captions, alt texts, tags and technologies are indexed with their own weights, and words are stemmed for English, French, Spanish and German."
+}
+
+func (e *SearchExporter) Before(ctx *RunContext, opts ExporterOptions) error {
+	options := GetExporterOptions[SearchExporterOptions](e, opts)
+	for field := range options.Weights {
+		if _, ok := DefaultSearchWeights[field]; !ok {
+			fields := mapKeys(DefaultSearchWeights)
+			slices.Sort(fields)
+			return fmt.Errorf("unknown field %q in weights, must be one of %s", field, strings.Join(fields, ", "))
+		}
+	}
+	return nil
+}
+
+func (e *SearchExporter) Export(ctx *RunContext, opts ExporterOptions, work *Work) error {
+	return nil
+}
+
+func (e *SearchExporter) After(ctx *RunContext, opts ExporterOptions, db *Database) error {
+	options := GetExporterOptions[SearchExporterOptions](e, opts)
+	if options.Output == "" {
+		options.Output = "search"
+	}
+	output := options.Output
+	if !filepath.IsAbs(output) {
+		output = filepath.Join(filepath.Dir(ctx.OutputDatabaseFile), output)
+	}
+	weights := make(map[string]int)
+	for field, weight := range DefaultSearchWeights {
+		weights[field] = weight
+	}
+	for field, weight := range options.Weights {
+		weights[field] = weight
+	}
+
+	selection := ProfileConfiguration{Private: options.Private, WIP: options.WIP}
+	now := time.Now()
+	works := slices.DeleteFunc(db.WorksByDate(), func(work Work) bool {
+		return !selection.Includes(work, now)
+	})
+
+	for _, lang := range db.Languages() {
+		index := BuildSearchIndex(works, lang, weights)
+		terms := len(index.Terms)
+		if err := WriteSearchIndex(index, filepath.Join(output, lang), options.Shard); err != nil {
+			return fmt.Errorf("while writing search index for %s: %w", lang, err)
+		}
+		ExporterLogCustom(e, "Indexed", "green", "%d works in %s (%d terms) to %s", len(works), lang, terms, filepath.Join(output, lang))
+	}
+	return nil
+}
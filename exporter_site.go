@@ -0,0 +1,497 @@
+package ortfodb
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/metal3d/go-slugify"
+)
+
+//go:embed themes/default
+var defaultSiteThemeFiles embed.FS
+
+// SiteTemplates are the templates of a site theme, one per kind of page. Each of them is rendered along with base.html and partials.html.
+var SiteTemplates = []string{"index.html", "work.html", "tag.html", "technology.html", "languages.html"}
+
+type SiteExporterOptions struct {
+	// Title of the site.
+	Title string `yaml:"title,omitempty"`
+	// Directory to write the site to. Relative paths are relative to the output database file's directory. Defaults to site.
+	Output string `yaml:"output,omitempty"`
+	// Directory of a theme: its templates, and the files of its static directory, replace the ones of the default theme with the same name.
+	Theme string `yaml:"theme,omitempty"`
+	// URL the media directory is served at. By default, pages refer to media files with paths relative to them, pointing to the media directory.
+	MediaURL string `yaml:"media url,omitempty"`
+	// Size of the thumbnails shown in lists of works. The closest available size is used. Defaults to 600.
+	ThumbnailSize int `yaml:"thumbnail size,omitempty"`
+	// Additional values, available to templates as .Params.
+	Params map[string]any `yaml:"params,omitempty"`
+}
+
+type SiteExporter struct {
+}
+
+// SitePage is what site templates are rendered with.
+type SitePage struct {
+	// Title of the site
+	SiteTitle string
+	// Kind of page: index, work, tag, technology or languages
+	Kind string
+	// Language of the page. It is "default" for portfolios that are not translated.
+	Lang string
+	// Languages the site is available in.
+	Languages []string
+	// Works shown on the page, most recent first: every work on the index, works with the tag on tag pages, works made with the technology on technology pages.
+	Works []SiteWork
+	// The work of work pages.
+	Work *SiteWork
+	// Tag of tag pages, and technology of technology pages.
+	Taxon *SiteTaxon
+	// Every tag and technology used by works, sorted by name.
+	Tags         []SiteTaxon
+	Technologies []SiteTaxon
+	// Additional values from the exporter's params option
+	Params map[string]any
+	// Date the site was generated at
+	GeneratedAt time.Time
+
+	// Path of the page's file, relative to the site's root
+	file     string
+	exporter *siteExporterRun
+}
+
+// SiteWork is a work, in the language of the page it is shown on.
+type SiteWork struct {
+	LocalizedWork
+	// First paragraph of the work
+	Summary HTMLString
+	// Media used as the work's thumbnail
+	Thumbnail Media
+	// Tags and technologies of the work
+	Tags         []SiteTaxon
+	Technologies []SiteTaxon
+}
+
+// SiteTaxon is a tag or a technology, with details from its repository if it is declared there.
+type SiteTaxon struct {
+	Name        string
+	Slug        string
+	Description string
+	LearnMoreAt string
+	// IDs of the works tagged with it (or made with it)
+	WorkIDs []string
+}
+
+// siteExporterRun holds what is needed to render the pages of a site.
+type siteExporterRun struct {
+	options   SiteExporterOptions
+	output    string
+	mediaRoot string
+	// Whether pages are in a directory per language
+	multilingual bool
+	templates    map[string]*template.Template
+}
+
+func (e *SiteExporter) OptionsType() any {
+	return SiteExporterOptions{}
+}
+
+func (e *SiteExporter) Name() string {
+	return "site"
+}
+
+func (e *SiteExporter) Description() string {
+	return "Generate a static HTML site from the database, with an index of works, a page per work laid out with its layout, and pages per tag and technology, in every language. Uses a default theme, whose templates can be overriden."
+}
+
+func (e *SiteExporter) Before(ctx *RunContext, opts ExporterOptions) error {
+	options := GetExporterOptions[SiteExporterOptions](e, opts)
+	if options.Theme != "" {
+		if stat, err := os.Stat(options.Theme); err != nil || !stat.IsDir() {
+			return fmt.Errorf("theme directory %s does not exist", options.Theme)
+		}
+	}
+	return nil
+}
+
+func (e *SiteExporter) Export(ctx *RunContext, opts ExporterOptions, work *Work) error {
+	return nil
+}
+
+func (e *SiteExporter) After(ctx *RunContext, opts ExporterOptions, db *Database) error {
+	options := GetExporterOptions[SiteExporterOptions](e, opts)
+	if options.Output == "" {
+		options.Output = "site"
+	}
+	if options.ThumbnailSize == 0 {
+		options.ThumbnailSize = 600
+	}
+	run := &siteExporterRun{
+		options:      options,
+		output:       options.Output,
+		mediaRoot:    ctx.Config.Media.At,
+		multilingual: !slices.Equal(db.Languages(), []string{"default"}),
+		templates:    make(map[string]*template.Template),
+	}
+	if !filepath.IsAbs(run.output) {
+		run.output = filepath.Join(filepath.Dir(ctx.OutputDatabaseFile), run.output)
+	}
+
+	theme := siteTheme(options.Theme)
+	for _, name := range SiteTemplates {
+		tmpl, err := theme.parse(name)
+		if err != nil {
+			return err
+		}
+		run.templates[name] = tmpl
+	}
+	if err := theme.copyStatic(filepath.Join(run.output, "static")); err != nil {
+		return err
+	}
+
+	var tags []Tag
+	var technologies []Technology
+	if ctx.Config.Tags.Repository != "" {
+		tags, _ = ctx.LoadTagsRepository()
+	}
+	if ctx.Config.Technologies.Repository != "" {
+		technologies, _ = ctx.LoadTechnologiesRepository()
+	}
+	languages := db.Languages()
+	slices.Sort(languages)
+	pages := 0
+	for _, lang := range languages {
+		works, allTags, allTechnologies := run.works(db, lang, tags, technologies)
+		page := func(kind string, file string) SitePage {
+			return SitePage{
+				SiteTitle:    options.Title,
+				Kind:         kind,
+				Lang:         lang,
+				Languages:    languages,
+				Works:        works,
+				Tags:         allTags,
+				Technologies: allTechnologies,
+				Params:       options.Params,
+				GeneratedAt:  time.Now(),
+				file:         run.languageRoot(lang) + file,
+				exporter:     run,
+			}
+		}
+
+		if err := run.render(page("index", "index.html")); err != nil {
+			return err
+		}
+		pages++
+		for _, work := range works {
+			workPage := page("work", work.ID+"/index.html")
+			workPage.Work = &work
+			if err := run.render(workPage); err != nil {
+				return err
+			}
+			pages++
+		}
+		for kind, taxons := range map[string][]SiteTaxon{"tag": allTags, "technology": allTechnologies} {
+			directory := map[string]string{"tag": "tags", "technology": "technologies"}[kind]
+			for _, taxon := range taxons {
+				taxonPage := page(kind, directory+"/"+taxon.Slug+"/index.html")
+				taxonPage.Taxon = &taxon
+				taxonPage.Works = slices.DeleteFunc(slices.Clone(works), func(work SiteWork) bool {
+					return !slices.Contains(taxon.WorkIDs, work.ID)
+				})
+				if err := run.render(taxonPage); err != nil {
+					return err
+				}
+				pages++
+			}
+		}
+	}
+
+	if run.multilingual {
+		root := SitePage{
+			SiteTitle:   options.Title,
+			Kind:        "languages",
+			Languages:   languages,
+			Params:      options.Params,
+			GeneratedAt: time.Now(),
+			file:        "index.html",
+			exporter:    run,
+		}
+		if err := run.render(root); err != nil {
+			return err
+		}
+		pages++
+	}
+
+	ExporterLogCustom(e, "Generated", "green", "site with %d pages in %s", pages, run.output)
+	return nil
+}
+
+// works returns the works of the database in the given language, most recent first, with the tags and technologies they use.
+func (run *siteExporterRun) works(db *Database, lang string, tagsRepository []Tag, technologiesRepository []Technology) (works []SiteWork, tags []SiteTaxon, technologies []SiteTaxon) {
+	tagsBySlug := make(map[string]*SiteTaxon)
+	technologiesBySlug := make(map[string]*SiteTaxon)
+	for _, work := range db.WorksByDate() {
+		siteWork := SiteWork{
+			LocalizedWork: work.Localize(lang),
+			Thumbnail:     work.ThumbnailBlock(lang),
+			Tags:          make([]SiteTaxon, 0),
+			Technologies:  make([]SiteTaxon, 0),
+		}
+		if found, paragraph := work.FirstParagraph(lang); found {
+			siteWork.Summary = paragraph.Content
+		}
+		for _, name := range work.Metadata.Tags {
+			taxon := SiteTaxon{Name: name}
+			for _, tag := range tagsRepository {
+				if tag.ReferredToBy(name) {
+					taxon = SiteTaxon{Name: tag.DisplayName(), Description: tag.Description, LearnMoreAt: tag.LearnMoreAt}
+					if taxon.Name == "" {
+						taxon.Name = tag.Singular
+					}
+					taxon.Slug = tag.URLFriendlyName()
+					break
+				}
+			}
+			siteWork.Tags = append(siteWork.Tags, addToTaxon(tagsBySlug, taxon, work.ID))
+		}
+		for _, name := range work.Metadata.MadeWith {
+			taxon := SiteTaxon{Name: name}
+			for _, technology := range technologiesRepository {
+				if technology.ReferredToBy(name) {
+					taxon = SiteTaxon{Name: technology.DisplayName(), Slug: technology.URLFriendlyName(), Description: technology.Description, LearnMoreAt: technology.LearnMoreAt}
+					break
+				}
+			}
+			siteWork.Technologies = append(siteWork.Technologies, addToTaxon(technologiesBySlug, taxon, work.ID))
+		}
+		works = append(works, siteWork)
+	}
+
+	for _, taxons := range []struct {
+		bySlug map[string]*SiteTaxon
+		sorted *[]SiteTaxon
+	}{{tagsBySlug, &tags}, {technologiesBySlug, &technologies}} {
+		*taxons.sorted = make([]SiteTaxon, 0, len(taxons.bySlug))
+		for _, taxon := range taxons.bySlug {
+			*taxons.sorted = append(*taxons.sorted, *taxon)
+		}
+		slices.SortFunc(*taxons.sorted, func(a, b SiteTaxon) int {
+			return strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name))
+		})
+	}
+	return
+}
+
+// addToTaxon records that the work uses the taxon, and returns the taxon.
+func addToTaxon(taxons map[string]*SiteTaxon, taxon SiteTaxon, workID string) SiteTaxon {
+	if taxon.Slug == "" {
+		taxon.Slug = strings.ToLower(slugify.Marshal(taxon.Name, true))
+	}
+	if _, ok := taxons[taxon.Slug]; !ok {
+		taxons[taxon.Slug] = &taxon
+	}
+	if !slices.Contains(taxons[taxon.Slug].WorkIDs, workID) {
+		taxons[taxon.Slug].WorkIDs = append(taxons[taxon.Slug].WorkIDs, workID)
+	}
+	return taxon
+}
+
+// languageRoot returns the directory of the pages in the given language, relative to the site's root.
+func (run *siteExporterRun) languageRoot(lang string) string {
+	if !run.multilingual {
+		return ""
+	}
+	return lang + "/"
+}
+
+// render writes the page to its file.
+func (run *siteExporterRun) render(page SitePage) error {
+	var rendered bytes.Buffer
+	if err := run.templates[page.Kind+".html"].ExecuteTemplate(&rendered, "base.html", page); err != nil {
+		return fmt.Errorf("while rendering %s: %w", page.file, err)
+	}
+	file := filepath.Join(run.output, filepath.FromSlash(page.file))
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return fmt.Errorf("while creating directory for %s: %w", file, err)
+	}
+	if err := os.WriteFile(file, rendered.Bytes(), 0o644); err != nil {
+		return fmt.Errorf("while writing %s: %w", file, err)
+	}
+	return nil
+}
+
+// Root returns the relative URL of the site's root from the page, ending with a slash.
+func (page SitePage) Root() string {
+	if !strings.Contains(page.file, "/") {
+		return "./"
+	}
+	return strings.Repeat("../", strings.Count(page.file, "/"))
+}
+
+// Home returns the relative URL of the index of the page's language.
+func (page SitePage) Home() string {
+	if page.Lang == "" {
+		return page.Root()
+	}
+	return page.LanguageURL(page.Lang)
+}
+
+// LanguageURL returns the relative URL of the index in the given language.
+func (page SitePage) LanguageURL(lang string) string {
+	return page.Root() + page.exporter.languageRoot(lang)
+}
+
+// WorkURL returns the relative URL of the page of the work with the given ID.
+func (page SitePage) WorkURL(id string) string {
+	return page.Home() + id + "/"
+}
+
+// TagURL returns the relative URL of the page of the given tag.
+func (page SitePage) TagURL(tag SiteTaxon) string {
+	return page.Home() + "tags/" + tag.Slug + "/"
+}
+
+// TechnologyURL returns the relative URL of the page of the given technology.
+func (page SitePage) TechnologyURL(technology SiteTaxon) string {
+	return page.Home() + "technologies/" + technology.Slug + "/"
+}
+
+// Static returns the relative URL of a file of the theme's static directory.
+func (page SitePage) Static(file string) string {
+	return page.Root() + "static/" + file
+}
+
+// Media returns the URL of a file of the media directory, such as a media's DistSource or one of its thumbnails.
+func (page SitePage) Media(file FilePathInsideMediaRoot) string {
+	if file == "" {
+		return ""
+	}
+	if page.exporter.options.MediaURL != "" {
+		return strings.TrimSuffix(page.exporter.options.MediaURL, "/") + "/" + strings.TrimPrefix(filepath.ToSlash(string(file)), "/")
+	}
+	pageDirectory := filepath.Dir(filepath.Join(page.exporter.output, filepath.FromSlash(page.file)))
+	relative, err := filepath.Rel(pageDirectory, filepath.Join(page.exporter.mediaRoot, string(file)))
+	if err != nil {
+		return filepath.ToSlash(filepath.Join(page.exporter.mediaRoot, string(file)))
+	}
+	return filepath.ToSlash(relative)
+}
+
+// Thumbnail returns the URL of the thumbnail of the media closest to the given size. Without a size, the thumbnail size option is used.
+func (page SitePage) Thumbnail(media Media, size ...int) string {
+	wanted := page.exporter.options.ThumbnailSize
+	if len(size) > 0 {
+		wanted = size[0]
+	}
+	if thumbnail := media.Thumbnails.Closest(wanted); thumbnail != "" {
+		return page.Media(thumbnail)
+	}
+	return page.Media(media.DistSource)
+}
+
+// Layout returns the areas of the work's layout, with the block that occupies them. The layout has .Work.Content.Layout.Width columns.
+func (page SitePage) Layout() (areas []SiteLayoutArea) {
+	if page.Work == nil {
+		return nil
+	}
+	for _, area := range page.Work.Content.Layout.Areas() {
+		for _, block := range page.Work.Content.Blocks {
+			if block.ID == area.BlockID {
+				areas = append(areas, SiteLayoutArea{LayoutArea: area, Block: block})
+				break
+			}
+		}
+	}
+	return areas
+}
+
+// SiteLayoutArea is the area of a work's layout occupied by a content block.
+type SiteLayoutArea struct {
+	LayoutArea
+	Block ContentBlock
+}
+
+// siteTheme is the directory of a theme, whose files replace the ones of the default theme. The default theme is used alone if it is empty.
+type siteTheme string
+
+// read returns the contents of the theme's file.
+func (theme siteTheme) read(name string) ([]byte, error) {
+	if theme != "" {
+		contents, err := os.ReadFile(filepath.Join(string(theme), name))
+		if err == nil {
+			return contents, nil
+		}
+		if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("while reading %s of theme %s: %w", name, theme, err)
+		}
+	}
+	contents, err := defaultSiteThemeFiles.ReadFile(path.Join("themes/default", name))
+	if err != nil {
+		return nil, fmt.Errorf("while reading %s of the default theme: %w", name, err)
+	}
+	return contents, nil
+}
+
+// parse parses the template of a kind of page, along with base.html and partials.html.
+func (theme siteTheme) parse(name string) (*template.Template, error) {
+	tmpl := template.New("site").Funcs(template.FuncMap{
+		"safeHTML":  func(s HTMLString) template.HTML { return template.HTML(s) },
+		"plainText": func(s HTMLString) string { return s.String() },
+		"lower":     strings.ToLower,
+		"hasPrefix": strings.HasPrefix,
+		"list":      func(values ...any) []any { return values },
+		"date": func(layout string, date time.Time) string {
+			return date.Format(layout)
+		},
+	})
+	for _, file := range []string{"base.html", "partials.html", name} {
+		contents, err := theme.read(file)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := tmpl.New(file).Parse(string(contents)); err != nil {
+			return nil, fmt.Errorf("while parsing template %s: %w", file, err)
+		}
+	}
+	return tmpl, nil
+}
+
+// copyStatic copies the static files of the default theme and of the theme to the given directory.
+func (theme siteTheme) copyStatic(to string) error {
+	sources := []fs.FS{}
+	if sub, err := fs.Sub(defaultSiteThemeFiles, "themes/default/static"); err == nil {
+		sources = append(sources, sub)
+	}
+	if stat, err := os.Stat(filepath.Join(string(theme), "static")); theme != "" && err == nil && stat.IsDir() {
+		sources = append(sources, os.DirFS(filepath.Join(string(theme), "static")))
+	}
+	for _, source := range sources {
+		err := fs.WalkDir(source, ".", func(file string, entry fs.DirEntry, err error) error {
+			if err != nil || entry.IsDir() {
+				return err
+			}
+			contents, err := fs.ReadFile(source, file)
+			if err != nil {
+				return err
+			}
+			destination := filepath.Join(to, filepath.FromSlash(file))
+			if err := os.MkdirAll(filepath.Dir(destination), 0o755); err != nil {
+				return err
+			}
+			return os.WriteFile(destination, contents, 0o644)
+		})
+		if err != nil {
+			return fmt.Errorf("while copying static files of the theme: %w", err)
+		}
+	}
+	return nil
+}
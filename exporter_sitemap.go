@@ -0,0 +1,312 @@
+package ortfodb
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+type SitemapExporterOptions struct {
+	// URL of the website that shows the works. Required.
+	BaseURL string `yaml:"base url"`
+	// URL of a work's page, as a Go template. Available variables: .BaseURL, .ID and .Lang (empty for portfolios that are not translated). Defaults to {{ .BaseURL }}/{{ if .Lang }}{{ .Lang }}/{{ end }}{{ .ID }}
+	WorkURL string `yaml:"work url,omitempty"`
+	// Other pages to list in the sitemap, such as the home page, as Go templates rendered for every language. Available variables: .BaseURL and .Lang.
+	Pages []string `yaml:"pages,omitempty"`
+	// URL the media directory is served at, used for images. Defaults to the base URL.
+	MediaURL string `yaml:"media url,omitempty"`
+	// Where to write the sitemap. Relative paths are relative to the output database file's directory. Defaults to sitemap.xml
+	Output string `yaml:"output,omitempty"`
+	// Where to write the JSON-LD document of each work in each language, as a Go template. Available variables: .ID and .Lang. Relative paths are relative to the output database file's directory. Defaults to json-ld/{{ .ID }}{{ if .Lang }}.{{ .Lang }}{{ end }}.json
+	JSONLD string `yaml:"json-ld,omitempty"`
+	// Name of the author of the works, for JSON-LD documents.
+	Author string `yaml:"author,omitempty"`
+	// Include works marked as work in progress.
+	WIP bool `yaml:"wip,omitempty"`
+	// Include works marked as private.
+	Private bool `yaml:"private,omitempty"`
+	// Size of the thumbnails used as images of JSON-LD documents. The closest available size is used. Defaults to 1000.
+	ThumbnailSize int `yaml:"thumbnail size,omitempty"`
+}
+
+type SitemapExporter struct {
+}
+
+func (e *SitemapExporter) OptionsType() any {
+	return SitemapExporterOptions{}
+}
+
+func (e *SitemapExporter) Name() string {
+	return "sitemap"
+}
+
+func (e *SitemapExporter) Description() string {
+	return "Export a sitemap.xml file listing the page of every work in every language, with their images, and a JSON-LD CreativeWork document per work for search engines. URLs of pages are set with templates."
+}
+
+func (e *SitemapExporter) Before(ctx *RunContext, opts ExporterOptions) error {
+	options := GetExporterOptions[SitemapExporterOptions](e, opts)
+	if options.BaseURL == "" {
+		return fmt.Errorf("the base url option is required")
+	}
+	return nil
+}
+
+func (e *SitemapExporter) Export(ctx *RunContext, opts ExporterOptions, work *Work) error {
+	return nil
+}
+
+type sitemapDocument struct {
+	XMLName xml.Name     `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 urlset"`
+	XHTML   string       `xml:"xmlns:xhtml,attr"`
+	Image   string       `xml:"xmlns:image,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapURL struct {
+	Location   string             `xml:"loc"`
+	LastMod    string             `xml:"lastmod,omitempty"`
+	Alternates []sitemapAlternate `xml:"xhtml:link"`
+	Images     []sitemapImage     `xml:"image:image"`
+}
+
+type sitemapAlternate struct {
+	Rel      string `xml:"rel,attr"`
+	HrefLang string `xml:"hreflang,attr"`
+	Href     string `xml:"href,attr"`
+}
+
+type sitemapImage struct {
+	Location string `xml:"image:loc"`
+	Caption  string `xml:"image:caption,omitempty"`
+}
+
+// JSONLDCreativeWork is a schema.org CreativeWork, as a JSON-LD document. See https://schema.org/CreativeWork.
+type JSONLDCreativeWork struct {
+	Context       string   `json:"@context"`
+	Type          string   `json:"@type"`
+	ID            string   `json:"@id"`
+	URL           string   `json:"url"`
+	Name          string   `json:"name"`
+	Description   string   `json:"description,omitempty"`
+	InLanguage    string   `json:"inLanguage,omitempty"`
+	DateCreated   string   `json:"dateCreated,omitempty"`
+	DatePublished string   `json:"datePublished,omitempty"`
+	DateModified  string   `json:"dateModified,omitempty"`
+	Keywords      []string `json:"keywords,omitempty"`
+	// Technologies the work was made with
+	Material []string      `json:"material,omitempty"`
+	Image    string        `json:"image,omitempty"`
+	Creator  *JSONLDPerson `json:"creator,omitempty"`
+	// URLs of the work in other languages
+	WorkTranslation []string `json:"workTranslation,omitempty"`
+}
+
+// JSONLDPerson is a schema.org Person. See https://schema.org/Person.
+type JSONLDPerson struct {
+	Type string `json:"@type"`
+	Name string `json:"name"`
+}
+
+func (e *SitemapExporter) After(ctx *RunContext, opts ExporterOptions, db *Database) error {
+	options := GetExporterOptions[SitemapExporterOptions](e, opts)
+	options.BaseURL = strings.TrimSuffix(options.BaseURL, "/")
+	if options.WorkURL == "" {
+		options.WorkURL = "{{ .BaseURL }}/{{ if .Lang }}{{ .Lang }}/{{ end }}{{ .ID }}"
+	}
+	if options.MediaURL == "" {
+		options.MediaURL = options.BaseURL
+	}
+	if options.Output == "" {
+		options.Output = "sitemap.xml"
+	}
+	if options.JSONLD == "" {
+		options.JSONLD = "json-ld/{{ .ID }}{{ if .Lang }}.{{ .Lang }}{{ end }}.json"
+	}
+	if options.ThumbnailSize == 0 {
+		options.ThumbnailSize = 1000
+	}
+
+	workURLTemplate, err := template.New("work url").Parse(options.WorkURL)
+	if err != nil {
+		return fmt.Errorf("while parsing work url template %q: %w", options.WorkURL, err)
+	}
+	jsonLDTemplate, err := template.New("json-ld").Parse(options.JSONLD)
+	if err != nil {
+		return fmt.Errorf("while parsing json-ld filename template %q: %w", options.JSONLD, err)
+	}
+	resolve := func(path string) string {
+		if filepath.IsAbs(path) {
+			return path
+		}
+		return filepath.Join(filepath.Dir(ctx.OutputDatabaseFile), path)
+	}
+	mediaURL := func(file FilePathInsideMediaRoot) string {
+		return options.MediaURL + "/" + strings.TrimPrefix(filepath.ToSlash(string(file)), "/")
+	}
+
+	sitemap := sitemapDocument{
+		XHTML: "http://www.w3.org/1999/xhtml",
+		Image: "http://www.google.com/schemas/sitemap-image/1.1",
+	}
+
+	languages := db.Languages()
+	slices.Sort(languages)
+	for _, page := range options.Pages {
+		pageTemplate, err := template.New("page").Parse(page)
+		if err != nil {
+			return fmt.Errorf("while parsing page url template %q: %w", page, err)
+		}
+		urls := make(map[string]string)
+		for _, lang := range languages {
+			url, err := executeTemplateToString(pageTemplate, map[string]any{"BaseURL": options.BaseURL, "Lang": feedLanguage(lang)})
+			if err != nil {
+				return fmt.Errorf("while computing url of page %q in %s: %w", page, lang, err)
+			}
+			urls[lang] = url
+		}
+		sitemap.URLs = append(sitemap.URLs, sitemapURLs(languages, urls, func(lang string, url *sitemapURL) {})...)
+	}
+
+	selection := ProfileConfiguration{Private: options.Private, WIP: options.WIP}
+	now := time.Now()
+	documents := 0
+	for _, work := range db.WorksByDate() {
+		if !selection.Includes(work, now) {
+			continue
+		}
+		workLanguages := mapKeys(work.Content)
+		slices.Sort(workLanguages)
+
+		urls := make(map[string]string)
+		for _, lang := range workLanguages {
+			url, err := executeTemplateToString(workURLTemplate, map[string]any{"BaseURL": options.BaseURL, "ID": work.ID, "Lang": feedLanguage(lang)})
+			if err != nil {
+				return fmt.Errorf("while computing url of %s in %s: %w", work.ID, lang, err)
+			}
+			urls[lang] = url
+		}
+
+		sitemap.URLs = append(sitemap.URLs, sitemapURLs(workLanguages, urls, func(lang string, url *sitemapURL) {
+			if !work.BuiltAt.IsZero() {
+				url.LastMod = work.BuiltAt.Format(time.RFC3339)
+			}
+			for _, block := range work.Content[lang].Blocks {
+				for _, media := range block.Mediae() {
+					if media.DistSource != "" && strings.HasPrefix(media.ContentType, "image/") {
+						url.Images = append(url.Images, sitemapImage{Location: mediaURL(media.DistSource), Caption: media.Caption})
+					}
+				}
+			}
+		})...)
+
+		for _, lang := range workLanguages {
+			document := creativeWork(work, lang, urls, options, mediaURL)
+			contents, err := jsoniter.ConfigCompatibleWithStandardLibrary.MarshalIndent(document, "", "  ")
+			if err != nil {
+				return fmt.Errorf("while encoding JSON-LD document of %s in %s: %w", work.ID, lang, err)
+			}
+			filename, err := executeTemplateToString(jsonLDTemplate, map[string]any{"ID": work.ID, "Lang": feedLanguage(lang)})
+			if err != nil {
+				return fmt.Errorf("while computing json-ld filename of %s in %s: %w", work.ID, lang, err)
+			}
+			filename = resolve(filename)
+			if err := os.MkdirAll(filepath.Dir(filename), 0o755); err != nil {
+				return fmt.Errorf("while creating directory for %s: %w", filename, err)
+			}
+			if err := os.WriteFile(filename, append(contents, '\n'), 0o644); err != nil {
+				return fmt.Errorf("while writing JSON-LD document of %s in %s: %w", work.ID, lang, err)
+			}
+			documents++
+		}
+	}
+
+	contents, err := xml.MarshalIndent(sitemap, "", "  ")
+	if err != nil {
+		return fmt.Errorf("while encoding sitemap: %w", err)
+	}
+	output := resolve(options.Output)
+	if err := os.WriteFile(output, append([]byte(xml.Header), append(contents, '\n')...), 0o644); err != nil {
+		return fmt.Errorf("while writing sitemap to %s: %w", output, err)
+	}
+	ExporterLogCustom(e, "Wrote", "green", "sitemap with %d pages to %s, and %d JSON-LD documents", len(sitemap.URLs), output, documents)
+	return nil
+}
+
+// sitemapURLs returns the sitemap entries of a page available in the given languages at the given URLs, each linking to the others as alternates.
+// fill adds details to the entry of each language.
+func sitemapURLs(languages []string, urls map[string]string, fill func(lang string, url *sitemapURL)) []sitemapURL {
+	entries := make([]sitemapURL, 0, len(languages))
+	for _, lang := range languages {
+		entry := sitemapURL{Location: urls[lang]}
+		if len(languages) > 1 {
+			for _, alternate := range languages {
+				hreflang := alternate
+				if alternate == "default" {
+					hreflang = "x-default"
+				}
+				entry.Alternates = append(entry.Alternates, sitemapAlternate{Rel: "alternate", HrefLang: hreflang, Href: urls[alternate]})
+			}
+		}
+		fill(lang, &entry)
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// creativeWork returns the JSON-LD document of the work in the given language. urls are the URLs of the work's page in each language.
+func creativeWork(work Work, lang string, urls map[string]string, options SitemapExporterOptions, mediaURL func(FilePathInsideMediaRoot) string) JSONLDCreativeWork {
+	content := work.Content.Localize(lang)
+	document := JSONLDCreativeWork{
+		Context:    "https://schema.org",
+		Type:       "CreativeWork",
+		ID:         urls[lang],
+		URL:        urls[lang],
+		Name:       content.Title.String(),
+		InLanguage: feedLanguage(lang),
+		Keywords:   work.Metadata.Tags,
+		Material:   work.Metadata.MadeWith,
+	}
+	if document.Name == "" {
+		document.Name = work.ID
+	}
+	if found, paragraph := work.FirstParagraph(lang); found {
+		document.Description = paragraph.Content.String()
+	}
+	if created := work.Metadata.CreatedAt(); created.Year() != 9999 {
+		document.DateCreated = created.Format(time.DateOnly)
+	}
+	if publishAt, err := work.Metadata.PublishDate(); err == nil && !publishAt.IsZero() {
+		document.DatePublished = publishAt.Format(time.RFC3339)
+	}
+	if !work.BuiltAt.IsZero() {
+		document.DateModified = work.BuiltAt.Format(time.RFC3339)
+	}
+	if thumbnail := work.ThumbnailPath(lang, options.ThumbnailSize); thumbnail != "" {
+		document.Image = mediaURL(thumbnail)
+	}
+	if options.Author != "" {
+		document.Creator = &JSONLDPerson{Type: "Person", Name: options.Author}
+	}
+	for _, other := range mapKeys(urls) {
+		if other != lang {
+			document.WorkTranslation = append(document.WorkTranslation, urls[other])
+		}
+	}
+	slices.Sort(document.WorkTranslation)
+	return document
+}
+
+// executeTemplateToString returns the output of the template.
+func executeTemplateToString(tmpl *template.Template, data any) (string, error) {
+	var out strings.Builder
+	err := tmpl.Execute(&out, data)
+	return out.String(), err
+}
@@ -2,17 +2,38 @@ package ortfodb
 
 import (
 	"fmt"
+	"math"
 	"os"
+	"os/exec"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+type SqlDialect string
+
+const (
+	SqlDialectSQLite     SqlDialect = "sqlite"
+	SqlDialectPostgreSQL SqlDialect = "postgresql"
+	SqlDialectMySQL      SqlDialect = "mysql"
 )
 
 type SqlExporterOptions struct {
-	Language string `yaml:"language"`
-	Output   string `yaml:"output,omitempty"`
+	// SQL dialect to generate statements for: sqlite (default), postgresql or mysql
+	Dialect SqlDialect `yaml:"dialect,omitempty" jsonschema:"enum=sqlite,enum=postgresql,enum=mysql"`
+	// Where to write the SQL statements. Defaults to the output database file, with a .sql extension.
+	// Not written if only sqlite is set.
+	Output string `yaml:"output,omitempty"`
+	// Path to a SQLite database file to create (or update) directly, regardless of the chosen dialect. Requires the sqlite3 command.
+	SQLite string `yaml:"sqlite,omitempty"`
+	// Only export content in this language. All languages are exported if empty.
+	Language string `yaml:"language,omitempty"`
 }
 
 type SqlExporter struct {
-	result string
 }
 
 func (e *SqlExporter) OptionsType() any {
@@ -24,39 +45,32 @@ func (e *SqlExporter) Name() string {
 }
 
 func (e *SqlExporter) Description() string {
-	return "Export the database as SQL statements. Rudimentary for now."
+	return "Export the database as SQL statements, with a table for each kind of object (works, localized content, blocks, media, thumbnails, tags, technologies and aliases). Statements are upserts, so the same database can be updated after every build. Can also write a SQLite database file directly."
 }
 
 func (e *SqlExporter) Before(ctx *RunContext, opts ExporterOptions) error {
-	e.result = ""
-	if !fileExists(e.outputFilename(ctx)) {
-		e.result += `CREATE TABLE works (
-		id TEXT PRIMARY KEY,
-		title TEXT,
-		summary TEXT,
-		start_date TEXT,
-		end_date TEXT,
-		tags TEXT,
-		technologies TEXT
-		);`
+	options := GetExporterOptions[SqlExporterOptions](e, opts)
+	if options.SQLite != "" {
+		if _, err := exec.LookPath("sqlite3"); err != nil {
+			return fmt.Errorf("the sqlite3 command is required to write SQLite database files: %w", err)
+		}
+	}
+
+	// Used to fill the tags and technologies tables
+	if ctx.Config.Tags.Repository != "" {
+		if _, err := ctx.LoadTagsRepository(); err != nil {
+			return fmt.Errorf("while loading tags repository: %w", err)
+		}
+	}
+	if ctx.Config.Technologies.Repository != "" {
+		if _, err := ctx.LoadTechnologiesRepository(); err != nil {
+			return fmt.Errorf("while loading technologies repository: %w", err)
+		}
 	}
 	return nil
 }
 
 func (e *SqlExporter) Export(ctx *RunContext, opts ExporterOptions, work *Work) error {
-	options := GetExporterOptions[SqlExporterOptions](e, opts)
-
-	_, summary := work.FirstParagraph(options.Language)
-	e.result += fmt.Sprintf(
-		"INSERT INTO works (id, title, summary, start_date, end_date, tags, technologies) VALUES ('%s', '%s', '%s', '%s', '%s', '%s', '%s');\n",
-		work.ID,
-		work.Content.Localize(options.Language).Title,
-		summary.Content.Markdown(),
-		work.Metadata.Started,
-		work.Metadata.Finished,
-		strings.Join(work.Metadata.Tags, ","),
-		strings.Join(work.Metadata.MadeWith, ","),
-	)
 	return nil
 }
 
@@ -65,7 +79,584 @@ func (e *SqlExporter) outputFilename(ctx *RunContext) string {
 }
 
 func (e *SqlExporter) After(ctx *RunContext, opts ExporterOptions, built *Database) error {
-	os.WriteFile(e.outputFilename(ctx), []byte(e.result), 0o644)
-	ExporterLogCustom(e, "Exported", "green", "SQL file to %s", e.outputFilename(ctx))
+	options := GetExporterOptions[SqlExporterOptions](e, opts)
+	if options.Dialect == "" {
+		options.Dialect = SqlDialectSQLite
+	}
+
+	if options.Output != "" || options.SQLite == "" {
+		outputFilename := options.Output
+		if outputFilename == "" {
+			outputFilename = e.outputFilename(ctx)
+		}
+
+		script, err := ctx.databaseToSQL(*built, options.Dialect, options.Language)
+		if err != nil {
+			return fmt.Errorf("while generating SQL statements: %w", err)
+		}
+		err = os.WriteFile(outputFilename, []byte(script), 0o644)
+		if err != nil {
+			return fmt.Errorf("while writing SQL file to %s: %w", outputFilename, err)
+		}
+		ExporterLogCustom(e, "Exported", "green", "SQL file to %s", outputFilename)
+	}
+
+	if options.SQLite != "" {
+		script, err := ctx.databaseToSQL(*built, SqlDialectSQLite, options.Language)
+		if err != nil {
+			return fmt.Errorf("while generating SQL statements: %w", err)
+		}
+		sqlite := exec.Command("sqlite3", "-bail", options.SQLite)
+		sqlite.Stdin = strings.NewReader(script)
+		if output, err := sqlite.CombinedOutput(); err != nil {
+			return fmt.Errorf("while writing SQLite database to %s: %w: %s", options.SQLite, err, output)
+		}
+		ExporterLogCustom(e, "Exported", "green", "SQLite database to %s", options.SQLite)
+	}
 	return nil
 }
+
+type sqlColumnType int
+
+const (
+	// Text that is part of a primary key. MySQL can't index unbounded TEXT columns.
+	sqlKey sqlColumnType = iota
+	sqlText
+	sqlInteger
+	sqlReal
+	sqlBoolean
+)
+
+type sqlColumn struct {
+	name string
+	typ  sqlColumnType
+}
+
+type sqlTable struct {
+	name       string
+	primaryKey []string
+	columns    []sqlColumn
+}
+
+// sqlRow maps column names to values. Columns of the table that are not in the row are set to NULL.
+type sqlRow map[string]any
+
+// sqlSchema is the schema of the exported database. Tables are created in that order.
+var sqlSchema = []sqlTable{
+	{"works", []string{"id"}, []sqlColumn{
+		{"id", sqlKey},
+		{"built_at", sqlText},
+		{"description_hash", sqlText},
+		{"started", sqlText},
+		{"finished", sqlText},
+		{"wip", sqlBoolean},
+		{"private", sqlBoolean},
+		{"partial", sqlBoolean},
+		{"thumbnail", sqlText},
+		{"title_style", sqlText},
+		{"page_background", sqlText},
+		{"primary_color", sqlText},
+		{"secondary_color", sqlText},
+		{"tertiary_color", sqlText},
+		// JSON object
+		{"additional_metadata", sqlText},
+	}},
+	{"work_aliases", []string{"work_id", "alias"}, []sqlColumn{
+		{"work_id", sqlKey},
+		{"alias", sqlKey},
+	}},
+	{"tags", []string{"name"}, []sqlColumn{
+		{"name", sqlKey},
+		{"plural", sqlText},
+		{"description", sqlText},
+		{"learn_more_at", sqlText},
+	}},
+	{"work_tags", []string{"work_id", "tag"}, []sqlColumn{
+		{"work_id", sqlKey},
+		{"tag", sqlKey},
+	}},
+	{"technologies", []string{"slug"}, []sqlColumn{
+		{"slug", sqlKey},
+		{"name", sqlText},
+		{"by", sqlText},
+		{"description", sqlText},
+		{"learn_more_at", sqlText},
+	}},
+	{"work_technologies", []string{"work_id", "technology"}, []sqlColumn{
+		{"work_id", sqlKey},
+		{"technology", sqlKey},
+	}},
+	{"localized_contents", []string{"work_id", "language"}, []sqlColumn{
+		{"work_id", sqlKey},
+		{"language", sqlKey},
+		{"title", sqlText},
+		// JSON-encoded
+		{"layout", sqlText},
+		{"footnotes", sqlText},
+		{"abbreviations", sqlText},
+	}},
+	{"media", []string{"dist_source"}, []sqlColumn{
+		{"dist_source", sqlKey},
+		{"relative_source", sqlText},
+		{"content_type", sqlText},
+		{"size", sqlInteger},
+		{"width", sqlInteger},
+		{"height", sqlInteger},
+		{"aspect_ratio", sqlReal},
+		{"online", sqlBoolean},
+		{"duration", sqlReal},
+		{"has_sound", sqlBoolean},
+		{"primary_color", sqlText},
+		{"secondary_color", sqlText},
+		{"tertiary_color", sqlText},
+		{"hash", sqlText},
+		{"analyzed", sqlBoolean},
+		{"thumbnails_built_at", sqlText},
+	}},
+	{"thumbnails", []string{"media", "size", "format"}, []sqlColumn{
+		{"media", sqlKey},
+		{"size", sqlInteger},
+		{"format", sqlKey},
+		{"path", sqlText},
+	}},
+	{"blocks", []string{"work_id", "language", "id"}, []sqlColumn{
+		{"work_id", sqlKey},
+		{"language", sqlKey},
+		{"id", sqlKey},
+		{"position", sqlInteger},
+		{"type", sqlText},
+		{"anchor", sqlText},
+		// Paragraphs, headings and quotes
+		{"content", sqlText},
+		// Links and embeds
+		{"text", sqlText},
+		{"title", sqlText},
+		{"url", sqlText},
+		// Media: dist_source of the media in the media table
+		{"media", sqlText},
+		{"alt", sqlText},
+		{"caption", sqlText},
+		{"loop", sqlBoolean},
+		{"autoplay", sqlBoolean},
+		{"muted", sqlBoolean},
+		{"playsinline", sqlBoolean},
+		{"controls", sqlBoolean},
+		// Headings
+		{"level", sqlInteger},
+		// Code
+		{"code", sqlText},
+		{"code_language", sqlText},
+		// Quotes
+		{"citation", sqlText},
+		// Embeds
+		{"provider", sqlText},
+		{"embed_url", sqlText},
+		// JSON object, for block types registered with RegisterBlockType
+		{"data", sqlText},
+	}},
+	{"gallery_items", []string{"work_id", "language", "block_id", "position"}, []sqlColumn{
+		{"work_id", sqlKey},
+		{"language", sqlKey},
+		{"block_id", sqlKey},
+		{"position", sqlInteger},
+		// dist_source of the media in the media table
+		{"media", sqlText},
+		{"alt", sqlText},
+		{"caption", sqlText},
+	}},
+}
+
+// workTables are the tables with rows that belong to a single work, through their work_id column.
+var workTables = []string{"work_aliases", "work_tags", "work_technologies", "localized_contents", "blocks", "gallery_items"}
+
+// sqlScript builds SQL statements for a given dialect.
+type sqlScript struct {
+	dialect SqlDialect
+	strings.Builder
+	// First value that could not be written as SQL, if any. Statements keep being built, but the script must not be used.
+	err error
+}
+
+// databaseToSQL returns a script that creates the schema if needed, then upserts every work of the database.
+// Rows of works that are not in the database anymore are deleted, so that running the script on the database created by a previous run brings it up to date.
+// If language is not empty, only content in that language is exported.
+func (ctx *RunContext) databaseToSQL(db Database, dialect SqlDialect, language string) (string, error) {
+	s := &sqlScript{dialect: dialect}
+
+	s.WriteString("-- Generated by ortfodb. See https://ortfo.org/db/exporters/formats#sql\n")
+	if dialect == SqlDialectMySQL {
+		s.statement("START TRANSACTION")
+	} else {
+		s.statement("BEGIN")
+	}
+
+	for _, table := range sqlSchema {
+		s.createTable(table)
+	}
+
+	workIDs := mapKeys(db)
+	sort.Strings(workIDs)
+
+	// Remove works that were deleted since the last export
+	quotedWorkIDs := make([]string, 0, len(workIDs))
+	for _, id := range workIDs {
+		quotedWorkIDs = append(quotedWorkIDs, s.literal(id))
+	}
+	removeDeletedWorks := func(table string, column string) {
+		if len(quotedWorkIDs) == 0 {
+			s.statement("DELETE FROM %s", s.identifier(table))
+		} else {
+			s.statement("DELETE FROM %s WHERE %s NOT IN (%s)", s.identifier(table), s.identifier(column), strings.Join(quotedWorkIDs, ", "))
+		}
+	}
+	for _, table := range workTables {
+		removeDeletedWorks(table, "work_id")
+	}
+	removeDeletedWorks("works", "id")
+
+	for _, id := range workIDs {
+		ctx.workToSQL(s, db[id], language)
+	}
+
+	// Remove media (and their thumbnails) that are not used anymore
+	s.statement("DELETE FROM %s WHERE %s NOT IN (SELECT %s FROM %s WHERE %s IS NOT NULL UNION SELECT %s FROM %s WHERE %s IS NOT NULL)",
+		s.identifier("media"), s.identifier("dist_source"),
+		s.identifier("media"), s.identifier("blocks"), s.identifier("media"),
+		s.identifier("media"), s.identifier("gallery_items"), s.identifier("media"))
+	s.statement("DELETE FROM %s WHERE %s NOT IN (SELECT %s FROM %s)",
+		s.identifier("thumbnails"), s.identifier("media"), s.identifier("dist_source"), s.identifier("media"))
+
+	s.statement("COMMIT")
+	return s.String(), s.err
+}
+
+func (ctx *RunContext) workToSQL(s *sqlScript, work Work, language string) {
+	s.WriteString(fmt.Sprintf("\n-- %s\n", work.ID))
+
+	additionalMetadata, _ := jsoniter.ConfigFastest.MarshalToString(work.Metadata.AdditionalMetadata)
+	s.upsert("works", sqlRow{
+		"id":                  work.ID,
+		"built_at":            work.BuiltAt,
+		"description_hash":    work.DescriptionHash,
+		"started":             work.Metadata.Started,
+		"finished":            work.Metadata.Finished,
+		"wip":                 work.Metadata.WIP,
+		"private":             work.Metadata.Private,
+		"partial":             work.Partial,
+		"thumbnail":           string(work.Metadata.Thumbnail),
+		"title_style":         string(work.Metadata.TitleStyle),
+		"page_background":     work.Metadata.PageBackground,
+		"primary_color":       work.Metadata.Colors.Primary,
+		"secondary_color":     work.Metadata.Colors.Secondary,
+		"tertiary_color":      work.Metadata.Colors.Tertiary,
+		"additional_metadata": additionalMetadata,
+	})
+
+	// Rows that belong to the work are re-created, so that removed blocks, tags, etc. don't linger around
+	for _, table := range workTables {
+		s.statement("DELETE FROM %s WHERE %s = %s", s.identifier(table), s.identifier("work_id"), s.literal(work.ID))
+	}
+
+	for _, alias := range work.Metadata.Aliases {
+		s.upsert("work_aliases", sqlRow{"work_id": work.ID, "alias": alias})
+	}
+
+	for _, name := range work.Metadata.Tags {
+		tag := sqlRow{"name": name}
+		for _, t := range ctx.TagsRepository {
+			if t.ReferredToBy(name) {
+				tag = sqlRow{"name": t.Singular, "plural": t.Plural, "description": t.Description, "learn_more_at": t.LearnMoreAt}
+				break
+			}
+		}
+		s.upsert("tags", tag)
+		s.upsert("work_tags", sqlRow{"work_id": work.ID, "tag": tag["name"]})
+	}
+
+	for _, name := range work.Metadata.MadeWith {
+		technology := sqlRow{"slug": name, "name": name}
+		for _, t := range ctx.TechnologiesRepository {
+			if t.ReferredToBy(name) {
+				technology = sqlRow{"slug": t.Slug, "name": t.Name, "by": t.By, "description": t.Description, "learn_more_at": t.LearnMoreAt}
+				break
+			}
+		}
+		s.upsert("technologies", technology)
+		s.upsert("work_technologies", sqlRow{"work_id": work.ID, "technology": technology["slug"]})
+	}
+
+	languages := mapKeys(work.Content)
+	sort.Strings(languages)
+	for _, lang := range languages {
+		if language != "" && lang != language {
+			continue
+		}
+		content := work.Content[lang]
+
+		layout, _ := jsoniter.ConfigFastest.MarshalToString(content.Layout)
+		footnotes, _ := jsoniter.ConfigFastest.MarshalToString(content.Footnotes)
+		abbreviations, _ := jsoniter.ConfigFastest.MarshalToString(content.Abbreviations)
+		s.upsert("localized_contents", sqlRow{
+			"work_id":       work.ID,
+			"language":      lang,
+			"title":         string(content.Title),
+			"layout":        layout,
+			"footnotes":     footnotes,
+			"abbreviations": abbreviations,
+		})
+
+		for position, block := range content.Blocks {
+			row := sqlRow{
+				"work_id":  work.ID,
+				"language": lang,
+				"id":       block.ID,
+				"position": position,
+				"type":     string(block.Type),
+				"anchor":   block.Anchor,
+			}
+			switch {
+			case block.Type.IsParagraph():
+				row["content"] = string(block.Content)
+			case block.Type.IsHeading():
+				row["content"] = string(block.Content)
+				row["level"] = block.Level
+			case block.Type.IsQuote():
+				row["content"] = string(block.Content)
+				row["citation"] = block.Citation
+			case block.Type.IsCode():
+				row["code"] = block.Code
+				row["code_language"] = block.CodeLanguage
+			case block.Type.IsEmbed():
+				row["text"] = string(block.Text)
+				row["title"] = block.Link.Title
+				row["url"] = block.URL
+				row["provider"] = block.Provider
+				row["embed_url"] = block.EmbedURL
+			case block.Type.IsGallery():
+				for itemPosition, item := range block.Items {
+					itemRow := sqlRow{
+						"work_id":  work.ID,
+						"language": lang,
+						"block_id": block.ID,
+						"position": itemPosition,
+						"alt":      item.Alt,
+						"caption":  item.Caption,
+					}
+					if item.DistSource != "" {
+						mediaToSQL(s, item)
+						itemRow["media"] = string(item.DistSource)
+					}
+					s.upsert("gallery_items", itemRow)
+				}
+			case block.Type.IsLink():
+				row["text"] = string(block.Text)
+				row["title"] = block.Title
+				row["url"] = block.URL
+			case block.Type.IsMedia():
+				if block.DistSource != "" {
+					mediaToSQL(s, block.Media)
+					row["media"] = string(block.DistSource)
+				}
+				row["alt"] = block.Alt
+				row["caption"] = block.Caption
+				row["loop"] = block.Attributes.Loop
+				row["autoplay"] = block.Attributes.Autoplay
+				row["muted"] = block.Attributes.Muted
+				row["playsinline"] = block.Attributes.Playsinline
+				row["controls"] = block.Attributes.Controls
+			}
+			if len(block.Data) > 0 {
+				row["data"], _ = jsoniter.ConfigFastest.MarshalToString(block.Data)
+			}
+			s.upsert("blocks", row)
+		}
+	}
+}
+
+func mediaToSQL(s *sqlScript, media Media) {
+	s.upsert("media", sqlRow{
+		"dist_source":         string(media.DistSource),
+		"relative_source":     string(media.RelativeSource),
+		"content_type":        media.ContentType,
+		"size":                media.Size,
+		"width":               media.Dimensions.Width,
+		"height":              media.Dimensions.Height,
+		"aspect_ratio":        float64(media.Dimensions.AspectRatio),
+		"online":              media.Online,
+		"duration":            media.Duration,
+		"has_sound":           media.HasSound,
+		"primary_color":       media.Colors.Primary,
+		"secondary_color":     media.Colors.Secondary,
+		"tertiary_color":      media.Colors.Tertiary,
+		"hash":                media.Hash,
+		"analyzed":            media.Analyzed,
+		"thumbnails_built_at": media.ThumbnailsBuiltAt,
+	})
+
+	// The same media can be used in multiple blocks, its thumbnails are re-created every time so that sizes that aren't generated anymore are removed.
+	s.statement("DELETE FROM %s WHERE %s = %s", s.identifier("thumbnails"), s.identifier("media"), s.literal(string(media.DistSource)))
+	sources := media.ThumbnailSources
+	if len(sources) == 0 {
+		// Databases built before thumbnails could be made in multiple formats
+		sources = make(ThumbnailSourcesMap)
+		for size, path := range media.Thumbnails {
+			sources.set(size, thumbnailFormatOf(string(path)), path)
+		}
+	}
+	for _, size := range sources.Sizes() {
+		formats := mapKeys(sources[size])
+		sort.Strings(formats)
+		for _, format := range formats {
+			s.upsert("thumbnails", sqlRow{"media": string(media.DistSource), "size": size, "format": format, "path": string(sources[size][format])})
+		}
+	}
+}
+
+func (s *sqlScript) statement(format string, args ...any) {
+	s.WriteString(fmt.Sprintf(format, args...))
+	s.WriteString(";\n")
+}
+
+func (s *sqlScript) createTable(table sqlTable) {
+	definitions := make([]string, 0, len(table.columns)+1)
+	for _, column := range table.columns {
+		definitions = append(definitions, fmt.Sprintf("%s %s", s.identifier(column.name), s.columnType(column.typ)))
+	}
+	definitions = append(definitions, fmt.Sprintf("PRIMARY KEY (%s)", strings.Join(s.identifiers(table.primaryKey), ", ")))
+	s.statement("CREATE TABLE IF NOT EXISTS %s (\n\t%s\n)", s.identifier(table.name), strings.Join(definitions, ",\n\t"))
+}
+
+// upsert inserts row into table, or updates the existing row with the same primary key.
+func (s *sqlScript) upsert(tableName string, row sqlRow) {
+	var table sqlTable
+	for _, t := range sqlSchema {
+		if t.name == tableName {
+			table = t
+		}
+	}
+
+	columns := make([]string, 0, len(table.columns))
+	values := make([]string, 0, len(table.columns))
+	updates := make([]string, 0, len(table.columns))
+	for _, column := range table.columns {
+		columns = append(columns, s.identifier(column.name))
+		values = append(values, s.literal(row[column.name]))
+		if stringInSlice(table.primaryKey, column.name) {
+			continue
+		}
+		if s.dialect == SqlDialectMySQL {
+			updates = append(updates, fmt.Sprintf("%s = VALUES(%s)", s.identifier(column.name), s.identifier(column.name)))
+		} else {
+			updates = append(updates, fmt.Sprintf("%s = excluded.%s", s.identifier(column.name), s.identifier(column.name)))
+		}
+	}
+
+	insert := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", s.identifier(table.name), strings.Join(columns, ", "), strings.Join(values, ", "))
+	switch {
+	case s.dialect == SqlDialectMySQL && len(updates) == 0:
+		s.statement("INSERT IGNORE%s", strings.TrimPrefix(insert, "INSERT"))
+	case s.dialect == SqlDialectMySQL:
+		s.statement("%s ON DUPLICATE KEY UPDATE %s", insert, strings.Join(updates, ", "))
+	case len(updates) == 0:
+		s.statement("%s ON CONFLICT (%s) DO NOTHING", insert, strings.Join(s.identifiers(table.primaryKey), ", "))
+	default:
+		s.statement("%s ON CONFLICT (%s) DO UPDATE SET %s", insert, strings.Join(s.identifiers(table.primaryKey), ", "), strings.Join(updates, ", "))
+	}
+}
+
+func (s *sqlScript) columnType(typ sqlColumnType) string {
+	switch typ {
+	case sqlKey:
+		if s.dialect == SqlDialectMySQL {
+			return "VARCHAR(255)"
+		}
+		return "TEXT"
+	case sqlText:
+		if s.dialect == SqlDialectMySQL {
+			return "LONGTEXT"
+		}
+		return "TEXT"
+	case sqlInteger:
+		if s.dialect == SqlDialectSQLite {
+			return "INTEGER"
+		}
+		return "BIGINT"
+	case sqlReal:
+		switch s.dialect {
+		case SqlDialectPostgreSQL:
+			return "DOUBLE PRECISION"
+		case SqlDialectMySQL:
+			return "DOUBLE"
+		}
+		return "REAL"
+	case sqlBoolean:
+		return "BOOLEAN"
+	}
+	s.fail(fmt.Errorf("unknown SQL column type %d", typ))
+	return "TEXT"
+}
+
+// fail records err, unless an error was already recorded.
+func (s *sqlScript) fail(err error) {
+	if s.err == nil {
+		s.err = err
+	}
+}
+
+func (s *sqlScript) identifier(name string) string {
+	if s.dialect == SqlDialectMySQL {
+		return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+	}
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+func (s *sqlScript) identifiers(names []string) []string {
+	quoted := make([]string, 0, len(names))
+	for _, name := range names {
+		quoted = append(quoted, s.identifier(name))
+	}
+	return quoted
+}
+
+// literal returns value as a SQL literal. Empty times, NaN and infinite numbers become NULL.
+// Values of other types are recorded as the script's error, and written as NULL.
+func (s *sqlScript) literal(value any) string {
+	switch value := value.(type) {
+	case nil:
+		return "NULL"
+	case bool:
+		if s.dialect == SqlDialectPostgreSQL {
+			return strings.ToUpper(strconv.FormatBool(value))
+		}
+		if value {
+			return "1"
+		}
+		return "0"
+	case int:
+		return strconv.Itoa(value)
+	case int64:
+		return strconv.FormatInt(value, 10)
+	case float32:
+		return s.literal(float64(value))
+	case float64:
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			return "NULL"
+		}
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	case time.Time:
+		if value.IsZero() {
+			return "NULL"
+		}
+		return s.literal(value.Format(time.RFC3339))
+	case string:
+		// NUL bytes can't be stored in text columns
+		value = strings.ReplaceAll(value, "\x00", "")
+		if s.dialect == SqlDialectMySQL {
+			// MySQL treats backslashes as escape characters in string literals by default
+			value = strings.ReplaceAll(value, `\`, `\\`)
+		}
+		return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+	}
+	s.fail(fmt.Errorf("cannot use %#v (%T) as an SQL value", value, value))
+	return "NULL"
+}
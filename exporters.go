@@ -3,12 +3,14 @@ package ortfodb
 import (
 	"embed"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
 
 	ll "github.com/ewen-lbh/label-logger-go"
 	"github.com/mitchellh/mapstructure"
+	"github.com/xeipuuv/gojsonschema"
 	"gopkg.in/yaml.v2"
 )
 
@@ -28,6 +30,20 @@ type ExporterCommand struct {
 	Run string `yaml:"run,omitempty"`
 	// Log a message. The first argument is the verb, the second is the color, the third is the message.
 	Log []string `yaml:"log,omitempty"`
+	// Only run the command (or log the message) if this Go text template expression is true, for example .DryRun or eq .Data.mode "full". Receives the same data as the command.
+	If string `yaml:"if,omitempty"`
+	// Environment variables to set for the command, in addition to those of ortfodb. Values are Go text templates that receive the same data as the command.
+	Env map[string]string `yaml:"env,omitempty"`
+	// Directory to run the command in, a Go text template that receives the same data as the command. Relative paths are relative to the configuration file's directory, which is the default.
+	Cwd string `yaml:"cwd,omitempty"`
+	// Number of seconds after which the command is stopped and considered failed. No timeout by default.
+	Timeout int `yaml:"timeout,omitempty"`
+	// Number of times to run the command again if it fails.
+	Retries int `yaml:"retries,omitempty"`
+	// Keep running the next commands if this one fails, instead of stopping the exporter.
+	ContinueOnError bool `yaml:"continue on error,omitempty"`
+	// Store the command's output (stdout, without the trailing newline) in .Data under this name, for the next commands.
+	Capture string `yaml:"capture,omitempty"`
 }
 
 type ExporterManifest struct {
@@ -40,7 +56,7 @@ type ExporterManifest struct {
 	// Commands to run before the build starts. Go text template that receives .Data
 	Before []ExporterCommand `yaml:"before,omitempty"`
 
-	// Commands to run after the build finishes. Go text template that receives .Data and .Database, the built database.
+	// Commands to run after the build finishes. Go text template that receives .Data, .Database, the built database, and .Changes, the changes since the previous build (see https://pkg.go.dev/github.com/ortfo/db#Changeset).
 	After []ExporterCommand `yaml:"after,omitempty"`
 
 	// Commands to run during the build, for each work. Go text template that receives .Data and .Work, the current work.
@@ -54,12 +70,25 @@ type ExporterManifest struct {
 
 	// List of programs that are required to be available in the PATH for the exporter to run.
 	Requires []string `yaml:"requires,omitempty"`
+
+	// Commands to run when a command of before, after or work fails (unless it continues on error), before the exporter stops. Go text template that receives the same data as the failed command, and .Error, its error message.
+	OnError []ExporterCommand `yaml:"on error,omitempty"`
 }
 
 // ExporterOptions validates then returns the configuration options for the given exporter.
 func (ctx *RunContext) ExporterOptions(exporter Exporter) (ExporterOptions, error) {
 	options := ctx.Config.Exporters[exporter.Name()]
-	err := ValidateExporterOptions(exporter, options)
+	// The profile option is handled by ortfodb, not by the exporter
+	var toValidate ExporterOptions
+	if options != nil {
+		toValidate = make(ExporterOptions, len(options))
+	}
+	for key, value := range options {
+		if key != ExporterProfileOption {
+			toValidate[key] = value
+		}
+	}
+	err := ValidateExporterOptions(exporter, toValidate)
 	if err != nil {
 		return nil, err
 	}
@@ -68,7 +97,12 @@ func (ctx *RunContext) ExporterOptions(exporter Exporter) (ExporterOptions, erro
 }
 
 func ValidateExporterOptions(exporter Exporter, opts ExporterOptions) error {
-	validationErrors := ValidateAsJSONSchema(exporter.OptionsType(), true, opts)
+	var validationErrors []gojsonschema.ResultError
+	if plugin, ok := exporter.(*PluginExporter); ok && plugin.OptionsSchema() != nil {
+		validationErrors = ValidateAgainstJSONSchema(plugin.OptionsSchema(), opts)
+	} else {
+		validationErrors = ValidateAsJSONSchema(exporter.OptionsType(), true, opts)
+	}
 
 	if len(validationErrors) > 0 {
 		DisplayValidationErrors(validationErrors, "configuration", "exporters", exporter.Name())
@@ -80,6 +114,11 @@ func ValidateExporterOptions(exporter Exporter, opts ExporterOptions) error {
 var BuiltinNativeExporters = []Exporter{
 	&SqlExporter{},
 	&LocalizeExporter{},
+	&FeedExporter{},
+	&SiteExporter{},
+	&SitemapExporter{},
+	&SearchExporter{},
+	&CsvExporter{},
 	&CustomExporter{},
 }
 
@@ -114,6 +153,10 @@ func BuiltinExporters() []Exporter {
 }
 
 func (ctx *RunContext) FindExporter(name string) (Exporter, error) {
+	if strings.HasPrefix(name, PluginExporterPrefix) {
+		return LoadPluginExporter(name, "")
+	}
+
 	for _, exporter := range BuiltinExporters() {
 		if exporter.Name() == name {
 			return exporter, nil
@@ -128,6 +171,10 @@ func (ctx *RunContext) FindExporter(name string) (Exporter, error) {
 			manifestPath = filepath.Join(filepath.Dir(ctx.Flags.Config), name)
 		}
 
+		if isPluginExporter(name, manifestPath) {
+			return LoadPluginExporter(name, manifestPath)
+		}
+
 		rawManifest, err := os.ReadFile(manifestPath)
 		if err != nil {
 			return &CustomExporter{}, fmt.Errorf("while reading local manifest file at %s: %w", name, err)
@@ -141,6 +188,17 @@ func (ctx *RunContext) FindExporter(name string) (Exporter, error) {
 	return nil, fmt.Errorf("no exporter named %s", name)
 }
 
+// CloseExporters stops exporters that run in their own process, such as plugins. Errors are displayed but do not stop the other exporters.
+func (ctx *RunContext) CloseExporters() {
+	for _, exporter := range ctx.Exporters {
+		if closer, ok := exporter.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				ll.ErrorDisplay("while stopping exporter %s", err, exporter.Name())
+			}
+		}
+	}
+}
+
 // LoadExporter loads an exporter from a manifest YAML file's contents.
 func LoadExporter(name string, manifestRaw []byte, config map[string]any) (*CustomExporter, error) {
 	var manifest ExporterManifest
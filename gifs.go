@@ -0,0 +1,150 @@
+package ortfodb
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	ll "github.com/ewen-lbh/label-logger-go"
+)
+
+const (
+	// DefaultGIFFileNameTemplate is used when make gifs.file name template is not set in the configuration.
+	DefaultGIFFileNameTemplate = "<work id>/<block id>.preview.<format>"
+	// DefaultGIFDuration is the duration of animated previews in seconds, when make gifs.duration is not set in the configuration.
+	DefaultGIFDuration = 3
+	// DefaultGIFFrameRate is the number of frames per second of animated previews, when make gifs.frame rate is not set in the configuration.
+	DefaultGIFFrameRate = 10
+	// DefaultGIFSize is the size of the largest side of animated previews, when make gifs.size is not set in the configuration.
+	DefaultGIFSize = 400
+)
+
+// GIFFormats are the formats animated previews can be made in.
+var GIFFormats = []string{"gif", "webp"}
+
+// GIFFileNameTemplate returns the file name template of animated previews.
+func (ctx *RunContext) GIFFileNameTemplate() string {
+	if ctx.Config.MakeGifs.FileNameTemplate == "" {
+		return DefaultGIFFileNameTemplate
+	}
+	return ctx.Config.MakeGifs.FileNameTemplate
+}
+
+// GIFFormatsToMake returns the formats to make animated previews in.
+func (ctx *RunContext) GIFFormatsToMake() []string {
+	if len(ctx.Config.MakeGifs.Formats) > 0 {
+		return ctx.Config.MakeGifs.Formats
+	}
+	template := ctx.GIFFileNameTemplate()
+	if format := thumbnailFormatOf(template); !strings.Contains(template, "<format>") && format != "" {
+		return []string{format}
+	}
+	return GIFFormats
+}
+
+// ComputeOutputGIFFilename returns the filename where to save an animated preview of the media in the given format, relative to the media directory.
+// The file name template has the same placeholders as the thumbnails' one, see ComputeOutputThumbnailFilename. <size> is the size of the animated preview.
+func (ctx *RunContext) ComputeOutputGIFFilename(media Media, blockID string, workID string, format string, lang string) FilePathInsideMediaRoot {
+	return ctx.computeOutputFilename(ctx.GIFFileNameTemplate(), media, blockID, workID, ctx.gifSize(), format, lang)
+}
+
+func (ctx *RunContext) gifSize() int {
+	if ctx.Config.MakeGifs.Size > 0 {
+		return ctx.Config.MakeGifs.Size
+	}
+	return DefaultGIFSize
+}
+
+// MakeGIFs makes animated previews of the given video media, in every format of make gifs.formats, and returns them keyed by format.
+// Existing previews are kept when usedCache is true, the media not having changed since the previous build.
+func (ctx *RunContext) MakeGIFs(media Media, blockID string, workID string, language string, usedCache bool) (map[string]FilePathInsideMediaRoot, error) {
+	gifs := make(map[string]FilePathInsideMediaRoot)
+	toMake := make([]FilePathInsideMediaRoot, 0)
+	for _, format := range ctx.GIFFormatsToMake() {
+		saveTo := ctx.ComputeOutputGIFFilename(media, blockID, workID, format, language)
+		gifs[format] = saveTo
+		if fileExists(saveTo.Absolute(ctx)) && usedCache {
+			ll.Debug("Skipping animated preview creation for %s#%s because %s already exists", media.RelativeSource, blockID, saveTo)
+			continue
+		}
+		toMake = append(toMake, saveTo)
+	}
+	if len(toMake) == 0 {
+		return gifs, nil
+	}
+
+	ctx.Status(workID, PhaseGIFs, string(media.RelativeSource))
+	frames, err := ctx.videoFrames(media)
+	if err != nil {
+		return nil, fmt.Errorf("while extracting frames of %s: %w", media.RelativeSource, err)
+	}
+	if len(frames) == 0 {
+		return nil, fmt.Errorf("no frames could be extracted from %s", media.RelativeSource)
+	}
+
+	frameRate := ctx.Config.MakeGifs.FrameRate
+	if frameRate <= 0 {
+		frameRate = DefaultGIFFrameRate
+	}
+	delays := make([]int, len(frames))
+	for i := range delays {
+		// GIF delays are in hundredths of a second
+		delays[i] = max(1, 100/frameRate)
+	}
+
+	for _, saveTo := range toMake {
+		os.MkdirAll(filepath.Dir(saveTo.Absolute(ctx)), 0o755)
+		if err := writeAnimation(saveTo.Absolute(ctx), frames, delays, 0, nil); err != nil {
+			return nil, fmt.Errorf("while making animated preview %s: %w", saveTo, err)
+		}
+		ll.Debug("Made animated preview %s", saveTo)
+	}
+	return gifs, nil
+}
+
+// videoFrames extracts frames of the video with ffmpeg, according to the make gifs configuration.
+func (ctx *RunContext) videoFrames(media Media) ([]image.Image, error) {
+	config := ctx.Config.MakeGifs
+	duration, frameRate, start := config.Duration, config.FrameRate, config.Start
+	if duration <= 0 {
+		duration = DefaultGIFDuration
+	}
+	if frameRate <= 0 {
+		frameRate = DefaultGIFFrameRate
+	}
+	// Start from the beginning of videos that are too short
+	if media.Duration > 0 && start >= media.Duration {
+		start = 0
+	}
+	size := ctx.gifSize()
+
+	var output bytes.Buffer
+	defer ll.TimeTrack(time.Now(), "videoFrames", media.RelativeSource)
+	err := runWithStdoutStdin("ffmpeg", nil, &output,
+		"-v", "error", "-nostdin",
+		"-ss", fmt.Sprint(start), "-t", fmt.Sprint(duration),
+		"-i", ctx.mediaFile(media),
+		"-vf", fmt.Sprintf("fps=%d,scale='min(%d,iw)':'min(%d,ih)':force_original_aspect_ratio=decrease:flags=lanczos", frameRate, size, size),
+		"-f", "image2pipe", "-c:v", "png", "-",
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// ffmpeg writes PNG files one after the other
+	frames := make([]image.Image, 0)
+	reader := bytes.NewReader(output.Bytes())
+	for reader.Len() > 0 {
+		frame, err := png.Decode(reader)
+		if err != nil {
+			return nil, fmt.Errorf("while decoding frame %d: %w", len(frames), err)
+		}
+		frames = append(frames, frame)
+	}
+	return frames, nil
+}
@@ -7,11 +7,14 @@ require (
 	github.com/JohannesKaufmann/html-to-markdown v1.5.0
 	github.com/anaskhan96/soup v1.2.5
 	github.com/charmbracelet/huh v0.3.0
+	github.com/disintegration/imaging v1.6.2
 	github.com/ewen-lbh/label-logger-go v0.1.1
+	github.com/fsnotify/fsnotify v1.7.0
 	github.com/gabriel-vasile/mimetype v1.4.3
 	github.com/go-git/go-git/v5 v5.12.0
 	github.com/invopop/jsonschema v0.12.0
 	github.com/json-iterator/go v1.1.12
+	github.com/ledongthuc/pdf v0.0.0-20220302134840-0c2507a12d80
 	github.com/metal3d/go-slugify v0.0.0-20160607203414-7ac2014b2f23
 	github.com/mitchellh/colorstring v0.0.0-20190213212951-d06e56a500db
 	github.com/mitchellh/go-homedir v1.1.0
@@ -20,6 +23,7 @@ require (
 	github.com/ortfo/languageserver v0.0.0-20240424205118-090504dc9e39
 	github.com/plus3it/gorecurcopy v0.0.1
 	github.com/relvacode/iso8601 v1.4.0
+	github.com/rwcarlsen/goexif v0.0.0-20190401172101-9e8deecbddbd
 	github.com/spf13/cobra v1.8.0
 	github.com/spf13/pflag v1.0.5
 	github.com/ssttevee/go-ffmpeg v0.2.1
@@ -27,6 +31,7 @@ require (
 	github.com/xeipuuv/gojsonschema v1.2.0
 	github.com/zyedidia/generic v1.2.1
 	go.uber.org/zap v1.27.0
+	golang.org/x/net v0.24.0
 	golang.org/x/term v0.19.0
 	gopkg.in/yaml.v2 v2.4.0
 	gopkg.in/yaml.v3 v3.0.1
@@ -105,7 +110,6 @@ require (
 	github.com/yuin/goldmark v1.7.1
 	github.com/yuin/goldmark-highlighting v0.0.0-20220208100518-594be1970594
 	golang.org/x/image v0.15.0
-	golang.org/x/net v0.24.0 // indirect
 	golang.org/x/text v0.14.0
 	gopkg.in/alessio/shellescape.v1 v1.0.0-20170105083845-52074bc9df61
 )
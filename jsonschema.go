@@ -7,7 +7,7 @@ import (
 	"github.com/invopop/jsonschema"
 )
 
-var AvailableJSONSchemas = []string{"configuration", "database", "tags", "technologies", "exporter"}
+var AvailableJSONSchemas = []string{"configuration", "database", "tags", "technologies", "exporter", "front-matter"}
 
 var yamlReflector = jsonschema.Reflector{
 	FieldNameTag: "yaml",
@@ -2,8 +2,11 @@ package ortfodb
 
 import (
 	"fmt"
-	ll "github.com/ewen-lbh/label-logger-go"
 	"strconv"
+	"strings"
+	"unicode"
+
+	ll "github.com/ewen-lbh/label-logger-go"
 )
 
 // EmptyLayoutCell is a special value that represents an empty cell (used as a spacer, for example). Expressed in the user-provided layout as a nil value.
@@ -81,6 +84,48 @@ func (layout Layout) BlockIDs() (blockIDs []string) {
 	return blockIDs
 }
 
+// LayoutArea is the rectangle of a layout occupied by a content block, as CSS grid lines: rows and columns start at 1, and the end lines are excluded.
+type LayoutArea struct {
+	BlockID   string
+	Row       int
+	RowEnd    int
+	Column    int
+	ColumnEnd int
+}
+
+// Areas returns the area occupied by each block of the layout, in the order blocks first appear. A block that appears in cells that are not next to each other occupies the smallest rectangle containing them. Empty cells are left out.
+// The layout must be normalized, see Normalize.
+func (layout Layout) Areas() []LayoutArea {
+	areas := make([]LayoutArea, 0)
+	indices := make(map[string]int)
+	for i, row := range layout {
+		for j, cell := range row {
+			if string(cell) == EmptyLayoutCell {
+				continue
+			}
+			index, ok := indices[string(cell)]
+			if !ok {
+				indices[string(cell)] = len(areas)
+				areas = append(areas, LayoutArea{BlockID: string(cell), Row: i + 1, RowEnd: i + 2, Column: j + 1, ColumnEnd: j + 2})
+				continue
+			}
+			area := &areas[index]
+			area.Row, area.RowEnd = min(area.Row, i+1), max(area.RowEnd, i+2)
+			area.Column, area.ColumnEnd = min(area.Column, j+1), max(area.ColumnEnd, j+2)
+		}
+	}
+	return areas
+}
+
+// Width returns the number of columns of the layout.
+func (layout Layout) Width() int {
+	width := 0
+	for _, row := range layout {
+		width = max(width, len(row))
+	}
+	return width
+}
+
 // ResolveLayout returns a layout, given the parsed description.
 func ResolveLayout(metadata WorkMetadata, language string, blocks []ContentBlock) (Layout, error) {
 	ll.Debug("Resolving layout from metadata %#v", metadata)
@@ -134,26 +179,37 @@ func ResolveLayout(metadata WorkMetadata, language string, blocks []ContentBlock
 }
 
 // ResolveBlockID returns the ID of a block, given its ref (user-facing content block references comprising of a content block type shorthand and an index). This index is 1-based.
+// Shorthands are declared by block types, see BlockType.Shorthand.
 func ResolveBlockID(blocks []ContentBlock, language string, blockRef string) (string, error) {
-	typ, indexStr := blockRef[0:1], blockRef[1:]
+	shorthandLength := strings.IndexFunc(blockRef, unicode.IsDigit)
+	if shorthandLength <= 0 {
+		return "", fmt.Errorf("invalid content block reference %q: must be a block type shorthand followed by an index, like p1", blockRef)
+	}
+	shorthand, indexStr := blockRef[:shorthandLength], blockRef[shorthandLength:]
 	index, err := strconv.Atoi(indexStr)
 	if err != nil {
 		return "", fmt.Errorf("invalid content block reference: %w", err)
 	}
 
-	currentIndexByType := map[ContentBlockType]int{"paragraph": 0, "media": 0, "link": 0}
-
-	if typ != "p" && typ != "m" && typ != "l" {
-		return "", fmt.Errorf("invalid content block reference: %s is not one of p, m, l", typ)
+	blockType, ok := findBlockTypeByShorthand(shorthand)
+	if !ok {
+		shorthands := make([]string, 0)
+		for _, blockType := range BlockTypes() {
+			shorthands = append(shorthands, blockType.Shorthand)
+		}
+		return "", fmt.Errorf("invalid content block reference: %s is not one of %s", shorthand, strings.Join(shorthands, ", "))
 	}
 
+	currentIndex := 0
 	for _, block := range blocks {
-		currentIndexByType[block.Type]++
-		if currentIndexByType[block.Type] == index && string(block.Type)[0:1] == typ {
+		if block.Type != blockType.Name {
+			continue
+		}
+		currentIndex++
+		if currentIndex == index {
 			return block.ID, nil
 		}
 	}
 
-	return "", fmt.Errorf("invalid content block reference: %s%d does not exist", typ, index)
-
+	return "", fmt.Errorf("invalid content block reference: %s%d does not exist", shorthand, index)
 }
@@ -0,0 +1,454 @@
+package ortfodb
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// LintProblem is a mistake found in a description file by Lint.
+type LintProblem struct {
+	WorkID string `json:"work"`
+	// Path to the description file
+	File string `json:"file"`
+	// 1-based line number in the description file
+	Line int `json:"line"`
+	// Kind of problem, e.g. "unknown-tag"
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+func (p LintProblem) String() string {
+	return fmt.Sprintf("%s:%d: %s [%s]", p.File, p.Line, p.Message, p.Rule)
+}
+
+// Lint checks the description files of the given works for mistakes that would otherwise only surface during the build (or not at all):
+// invalid YAML header, unparseable dates, unknown tags and technologies, missing media files, layout references to blocks that don't exist and duplicate content blocks.
+// All works of the projects directory are checked if workIDs is empty.
+func (ctx *RunContext) Lint(workIDs []string) ([]LintProblem, error) {
+	if len(workIDs) == 0 {
+		workDirectories, err := ctx.ComputeProgressTotal()
+		if err != nil {
+			return nil, fmt.Errorf("while listing works in %s: %w", ctx.DatabaseDirectory, err)
+		}
+		for _, dirEntry := range workDirectories {
+			workIDs = append(workIDs, dirEntry.Name())
+		}
+	}
+
+	if ctx.Config.Tags.Repository != "" {
+		if _, err := ctx.LoadTagsRepository(); err != nil {
+			return nil, fmt.Errorf("while loading tags repository: %w", err)
+		}
+	}
+	if ctx.Config.Technologies.Repository != "" {
+		if _, err := ctx.LoadTechnologiesRepository(); err != nil {
+			return nil, fmt.Errorf("while loading technologies repository: %w", err)
+		}
+	}
+
+	problems := make([]LintProblem, 0)
+	for _, workID := range workIDs {
+		workProblems, err := ctx.LintWork(workID)
+		if err != nil {
+			return problems, err
+		}
+		problems = append(problems, workProblems...)
+	}
+	return problems, nil
+}
+
+// LintWork checks the description file of a single work. See Lint.
+// Tags and technologies are only checked if the corresponding repository has been loaded.
+func (ctx *RunContext) LintWork(workID string) ([]LintProblem, error) {
+	filename := ctx.DescriptionFilename(ctx.DatabaseDirectory, workID)
+	raw, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("while reading description file %s: %w", filename, err)
+	}
+
+	d := newLintedDescription(workID, filename, string(raw))
+	metadata, markdown := ParseYAMLHeader[WorkMetadata](string(raw))
+
+	d.lintYAMLHeader()
+	d.lintDates()
+	if ctx.Config != nil {
+		d.lintCustomFields(ctx.Config.CustomFields)
+	}
+	if len(ctx.TagsRepository) > 0 {
+		d.lintTags(ctx.TagsRepository)
+	}
+	if len(ctx.TechnologiesRepository) > 0 {
+		d.lintTechnologies(ctx.TechnologiesRepository)
+	}
+
+	notLocalizedRaw, localizedRawBlocks := SplitOnLanguageMarkers(markdown)
+	languages := mapKeys(localizedRawBlocks)
+	if len(languages) == 0 {
+		languages = []string{"default"}
+	}
+	sort.Strings(languages)
+
+	blocksPerLanguage := make(map[string][]ContentBlock)
+	for _, language := range languages {
+		// Errors are caught by ParseDescription below, blocks are returned even when duplicates are found.
+		_, blocks, _, _, _ := ctx.ParseSingleLanguageDescription(notLocalizedRaw + localizedRawBlocks[language])
+		blocksPerLanguage[language] = blocks
+	}
+
+	d.lintMedia(ctx, blocksPerLanguage)
+	d.lintLayout(metadata, languages, blocksPerLanguage)
+	d.lintDuplicateBlocks()
+
+	// Catch-all for errors that the checks above don't know about
+	if _, err := ParseDescription(ctx, string(raw), workID); err != nil && len(d.problems) == 0 {
+		d.report(1, "invalid-description", "%s", err)
+	}
+
+	sort.SliceStable(d.problems, func(i, j int) bool { return d.problems[i].Line < d.problems[j].Line })
+	return d.problems, nil
+}
+
+// lintedDescription keeps track of line numbers of a description file's contents, as well as problems found in it.
+type lintedDescription struct {
+	workID   string
+	filename string
+	lines    []string
+	// inHeader[i] is true if lines[i] is part of the YAML header (separators excluded)
+	inHeader []bool
+	// The YAML header, decoded as-is
+	headerValues map[string]any
+	headerError  error
+	problems     []LintProblem
+}
+
+func newLintedDescription(workID string, filename string, raw string) *lintedDescription {
+	// Same front matter as ParseYAMLHeader: other separators are horizontal rules of the markdown content
+	lines, start, end := frontMatterBounds(raw)
+	d := &lintedDescription{workID: workID, filename: filename, lines: lines}
+	for i := range d.lines {
+		d.inHeader = append(d.inHeader, start < i && i < end)
+	}
+	d.headerError = yaml.Unmarshal([]byte(d.header()), &d.headerValues)
+	return d
+}
+
+func (d *lintedDescription) report(line int, rule string, format string, args ...any) {
+	d.problems = append(d.problems, LintProblem{
+		WorkID:  d.workID,
+		File:    d.filename,
+		Line:    line,
+		Rule:    rule,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// headerKeyLine returns the line number of the given top-level key of the YAML header, or the first line of the file if it can't be found.
+func (d *lintedDescription) headerKeyLine(key string) int {
+	for i, line := range d.lines {
+		if d.inHeader[i] && (strings.HasPrefix(line, key+":") || strings.HasPrefix(line, strings.ReplaceAll(key, " ", "_")+":")) {
+			return i + 1
+		}
+	}
+	return 1
+}
+
+// headerValueLine returns the line number where value appears under key in the YAML header, or the line of key itself if it can't be found.
+func (d *lintedDescription) headerValueLine(key string, value string) int {
+	keyLine := d.headerKeyLine(key)
+	for i := keyLine - 1; i < len(d.lines) && d.inHeader[i]; i++ {
+		if i > keyLine-1 && d.lines[i] != "" && !strings.HasPrefix(d.lines[i], " ") && !strings.HasPrefix(d.lines[i], "-") {
+			// Reached the next top-level key
+			break
+		}
+		if strings.Contains(d.lines[i][strings.Index(d.lines[i], ":")+1:], value) {
+			return i + 1
+		}
+	}
+	return keyLine
+}
+
+// bodyLine returns the line number of the first line outside of the YAML header that contains any of needles, or the first line of the file if none do.
+func (d *lintedDescription) bodyLine(needles ...string) int {
+	for i, line := range d.lines {
+		if d.inHeader[i] {
+			continue
+		}
+		for _, needle := range needles {
+			if strings.Contains(line, needle) {
+				return i + 1
+			}
+		}
+	}
+	return 1
+}
+
+func (d *lintedDescription) header() string {
+	var header strings.Builder
+	for i, line := range d.lines {
+		if d.inHeader[i] {
+			header.WriteString(line + "\n")
+		}
+	}
+	return header.String()
+}
+
+func (d *lintedDescription) firstHeaderLine() int {
+	for i := range d.lines {
+		if d.inHeader[i] {
+			return i + 1
+		}
+	}
+	return 1
+}
+
+func (d *lintedDescription) lintYAMLHeader() {
+	if d.headerError == nil {
+		return
+	}
+
+	line := d.firstHeaderLine()
+	if groups := regexp.MustCompile(`line (\d+)`).FindStringSubmatch(d.headerError.Error()); groups != nil {
+		lineInHeader, _ := strconv.Atoi(groups[1])
+		line += lineInHeader - 1
+	}
+	d.report(line, "invalid-yaml", "invalid YAML header: %s", strings.TrimPrefix(d.headerError.Error(), "yaml: "))
+}
+
+func (d *lintedDescription) lintDates() {
+	for _, key := range []string{"started", "finished", "created"} {
+		value, ok := d.headerValues[key]
+		if !ok || value == nil {
+			continue
+		}
+		date, isString := value.(string)
+		if !isString {
+			d.report(d.headerKeyLine(key), "invalid-date", "%s must be a date written as text, e.g. 2024-03-?? (got %v)", key, value)
+			continue
+		}
+		if _, err := parsePossiblyInterderminateDate(date); err != nil {
+			d.report(d.headerKeyLine(key), "invalid-date", "%s: could not parse %q as a date: %s", key, date, err)
+		}
+	}
+}
+
+func (d *lintedDescription) lintCustomFields(fields CustomFields) {
+	for _, name := range fields.names() {
+		field := fields[name]
+		value, ok := d.headerValues[name]
+		if !ok {
+			value, ok = d.headerValues[strings.ReplaceAll(name, " ", "_")]
+		}
+		if !ok || value == nil {
+			if field.Required && field.Default == nil {
+				d.report(d.firstHeaderLine(), "missing-field", "missing required field %s", name)
+			}
+			continue
+		}
+		if _, err := field.coerce(value); err != nil {
+			d.report(d.headerKeyLine(name), "invalid-field", "invalid value for %s: %s", name, err)
+		}
+	}
+}
+
+func (d *lintedDescription) lintTags(repository []Tag) {
+	for _, tag := range d.headerStrings("tags") {
+		if !some(repository, func(t Tag) bool { return t.ReferredToBy(tag) }) {
+			d.report(d.headerValueLine("tags", tag), "unknown-tag", "tag %q is not in the tags repository%s", tag, didYouMean(tag, mapStringers(repository)))
+		}
+	}
+}
+
+func (d *lintedDescription) lintTechnologies(repository []Technology) {
+	slugs := make([]string, 0, len(repository))
+	for _, t := range repository {
+		slugs = append(slugs, t.Slug)
+	}
+	for _, tech := range d.headerStrings("made with") {
+		if !some(repository, func(t Technology) bool { return t.ReferredToBy(tech) }) {
+			d.report(d.headerValueLine("made with", tech), "unknown-technology", "technology %q is not in the technologies repository%s", tech, didYouMean(tech, slugs))
+		}
+	}
+}
+
+// headerStrings returns the string items of the list at key in the YAML header.
+func (d *lintedDescription) headerStrings(key string) []string {
+	items, _ := d.headerValues[key].([]any)
+	values := make([]string, 0, len(items))
+	for _, item := range items {
+		if value, ok := item.(string); ok {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+func (d *lintedDescription) lintMedia(ctx *RunContext, blocksPerLanguage map[string][]ContentBlock) {
+	checked := make(map[string]bool)
+	for _, blocks := range blocksPerLanguage {
+		for _, block := range blocks {
+			for _, media := range block.Mediae() {
+				source := string(media.RelativeSource)
+				if checked[source] || isValidURL(source) {
+					continue
+				}
+				checked[source] = true
+
+				filename := source
+				if !filepath.IsAbs(filename) {
+					filename = filepath.Join(ctx.PathToWorkFolder(d.workID), source)
+				}
+				if !fileExists(filename) {
+					d.report(d.bodyLine(source, strings.ReplaceAll(source, " ", "%20")), "missing-media", "media file %s does not exist", filename)
+				}
+			}
+		}
+	}
+}
+
+func (d *lintedDescription) lintLayout(metadata WorkMetadata, languages []string, blocksPerLanguage map[string][]ContentBlock) {
+	rows, ok := metadata.AdditionalMetadata["layout"].([]any)
+	if !ok {
+		return
+	}
+
+	references := make([]string, 0)
+	for _, row := range rows {
+		switch row := row.(type) {
+		case string:
+			references = append(references, row)
+		case []any:
+			for _, cell := range row {
+				if reference, ok := cell.(string); ok {
+					references = append(references, reference)
+				}
+			}
+		}
+	}
+
+	for _, reference := range noDuplicates(references) {
+		if reference == "" {
+			d.report(d.headerKeyLine("layout"), "invalid-layout", "layout contains an empty block reference, use ~ (null) for empty cells")
+			continue
+		}
+		failingLanguages := make([]string, 0)
+		var lastErr error
+		for _, language := range languages {
+			if _, err := ResolveBlockID(blocksPerLanguage[language], language, reference); err != nil {
+				failingLanguages = append(failingLanguages, language)
+				lastErr = err
+			}
+		}
+		if len(failingLanguages) == 0 {
+			continue
+		}
+		if len(languages) == 1 {
+			d.report(d.headerValueLine("layout", reference), "invalid-layout", "layout: %s", lastErr)
+		} else {
+			d.report(d.headerValueLine("layout", reference), "invalid-layout", "layout: %s (in %s)", lastErr, strings.Join(failingLanguages, ", "))
+		}
+	}
+}
+
+// lintDuplicateBlocks reports paragraphs, media and links that appear twice in the same language, which ParseDescription refuses.
+func (d *lintedDescription) lintDuplicateBlocks() {
+	languageMarker := regexp.MustCompile(PatternLanguageMarker)
+	separator := regexp.MustCompile(PatternYAMLSeparator)
+	// Maps chunks of text (separated by blank lines) to the line they first appear on, for the current language
+	seen := make(map[string]int)
+	// Chunks that appear before any language marker are part of every language
+	notLocalized := make(map[string]int)
+	localized := false
+
+	var chunk strings.Builder
+	chunkStart := 0
+	endChunk := func() {
+		text := strings.TrimSpace(chunk.String())
+		chunk.Reset()
+		// Titles, abbreviations and footnotes definitions are not content blocks
+		if text == "" || strings.HasPrefix(text, "# ") || strings.HasPrefix(text, "*[") || strings.HasPrefix(text, "[^") {
+			return
+		}
+		if firstLine, ok := seen[text]; ok {
+			d.report(chunkStart, "duplicate-block", "same content as line %d: paragraphs, media and links must be unique in each language", firstLine)
+			return
+		}
+		seen[text] = chunkStart
+		if !localized {
+			notLocalized[text] = chunkStart
+		}
+	}
+
+	for i, line := range d.lines {
+		if d.inHeader[i] || separator.MatchString(line) {
+			continue
+		}
+		if languageMarker.MatchString(line) {
+			endChunk()
+			localized = true
+			seen = make(map[string]int)
+			for text, firstLine := range notLocalized {
+				seen[text] = firstLine
+			}
+			continue
+		}
+		if strings.TrimSpace(line) == "" {
+			endChunk()
+			continue
+		}
+		if chunk.Len() == 0 {
+			chunkStart = i + 1
+		}
+		chunk.WriteString(line + "\n")
+	}
+	endChunk()
+}
+
+// didYouMean returns a " (did you mean …?)" suggestion with the closest candidate to name, or an empty string if none is close enough.
+func didYouMean(name string, candidates []string) string {
+	best, bestDistance := "", 3
+	for _, candidate := range candidates {
+		if distance := levenshtein(strings.ToLower(name), strings.ToLower(candidate)); distance < bestDistance {
+			best, bestDistance = candidate, distance
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	return fmt.Sprintf(" (did you mean %q?)", best)
+}
+
+func levenshtein(a string, b string) int {
+	s, t := []rune(a), []rune(b)
+	previous := make([]int, len(t)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(s); i++ {
+		current := make([]int, len(t)+1)
+		current[0] = i
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous = current
+	}
+	return previous[len(t)]
+}
+
+func mapStringers[T fmt.Stringer](items []T) []string {
+	result := make([]string, 0, len(items))
+	for _, item := range items {
+		result = append(result, item.String())
+	}
+	return result
+}
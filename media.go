@@ -7,6 +7,7 @@ import (
 	"errors"
 	"fmt"
 	"image"
+	"math"
 	"time"
 
 	// Supported formats
@@ -24,19 +25,19 @@ import (
 	_ "golang.org/x/image/vp8l"
 	_ "golang.org/x/image/webp"
 
+	"net/url"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
 
-	"github.com/gabriel-vasile/mimetype"
-	// "github.com/gen2brain/go-fitz" // FIXME requires cgo, which goreleaser has a hard time with
 	ll "github.com/ewen-lbh/label-logger-go"
+	"github.com/gabriel-vasile/mimetype"
 	"github.com/lafriks/go-svg"
+	"github.com/ledongthuc/pdf"
 	"github.com/metal3d/go-slugify"
 	recurcopy "github.com/plus3it/gorecurcopy"
 	ffmpeg "github.com/ssttevee/go-ffmpeg"
-	"github.com/tcolgate/mp3"
 )
 
 func (p FilePathInsideMediaRoot) Absolute(ctx *RunContext) string {
@@ -65,24 +66,43 @@ type ImageDimensions struct {
 
 // Media represents a media object inserted in the work object's media array.
 type Media struct {
-	Alt               string                        `json:"alt"`
-	Caption           string                        `json:"caption"`
-	RelativeSource    FilePathInsidePortfolioFolder `json:"relativeSource"`
-	DistSource        FilePathInsideMediaRoot       `json:"distSource"`
-	ContentType       string                        `json:"contentType"`
-	Size              int                           `json:"size"` // in bytes
-	Dimensions        ImageDimensions               `json:"dimensions"`
-	Online            bool                          `json:"online"`
-	Duration          float64                       `json:"duration"` // in seconds
-	HasSound          bool                          `json:"hasSound"`
-	Colors            ColorPalette                  `json:"colors"`
-	Thumbnails        ThumbnailsMap                 `json:"thumbnails"`
-	ThumbnailsBuiltAt time.Time                     `json:"thumbnailsBuiltAt"`
-	Attributes        MediaAttributes               `json:"attributes"`
-	Analyzed          bool                          `json:"analyzed"` // whether the media has been analyzed
+	Alt            string                        `json:"alt"`
+	Caption        string                        `json:"caption"`
+	RelativeSource FilePathInsidePortfolioFolder `json:"relativeSource"`
+	DistSource     FilePathInsideMediaRoot       `json:"distSource"`
+	ContentType    string                        `json:"contentType"`
+	Size           int                           `json:"size"` // in bytes
+	Dimensions     ImageDimensions               `json:"dimensions"`
+	Online         bool                          `json:"online"`
+	Duration       float64                       `json:"duration"` // in seconds
+	Pages          int                           `json:"pages"`    // number of pages of PDFs
+	HasSound       bool                          `json:"hasSound"`
+	Colors         ColorPalette                  `json:"colors"`
+	Thumbnails     ThumbnailsMap                 `json:"thumbnails"`
+	// Thumbnails in every format they were made in (see make thumbnails.formats in the configuration)
+	ThumbnailSources  ThumbnailSourcesMap `json:"thumbnailSources"`
+	ThumbnailsBuiltAt time.Time           `json:"thumbnailsBuiltAt"`
+	Attributes        MediaAttributes     `json:"attributes"`
+	Analyzed          bool                `json:"analyzed"` // whether the media has been analyzed
 	// Hash of the media file, used for caching purposes. Could also serve as an integrity check.
 	// The value is the MD5 hash, base64-encoded.
 	Hash string `json:"hash"`
+	// BlurHash of the media, see https://blurha.sh
+	BlurHash string `json:"blurHash"`
+	// Low-quality image placeholder: a tiny version of the media, as a data: URL
+	LQIP string `json:"lqip"`
+	// Animated previews of videos, keyed by format (gif or webp)
+	GIFs map[string]FilePathInsideMediaRoot `json:"gifs"`
+	// EXIF and XMP metadata of photos
+	Photo *PhotoMetadata `json:"photo"`
+	// Metadata of PDFs
+	Document *DocumentMetadata `json:"document"`
+	// Tags of audio files
+	Audio *AudioMetadata `json:"audio"`
+	// Peaks of audio files, from 0 to 1, see /db/waveforms.md
+	Waveform []float64 `json:"waveform"`
+	// SVG rendering of the waveform of audio files, see make waveforms.file name template in the configuration
+	WaveformImage FilePathInsideMediaRoot `json:"waveformImage"`
 }
 
 // GetImageDimensions returns an ImageDimensions object, given a pointer to a file.
@@ -152,12 +172,23 @@ func (ctx *RunContext) AnalyzeMediaFile(workID string, embedDeclaration Media) (
 
 	// Compute absolute filepath to media
 	var filename string
-	if !filepath.IsAbs(string(embedDeclaration.RelativeSource)) {
-		filename, _ = filepath.Abs(filepath.Join(ctx.PathToWorkFolder(workID), string(embedDeclaration.RelativeSource)))
+	online := isRemoteSource(embedDeclaration.RelativeSource)
+	if online {
+		filename, err = ctx.DownloadRemoteMedia(string(embedDeclaration.RelativeSource))
+		if err != nil {
+			err = fmt.Errorf("while downloading remote media %s: %w", embedDeclaration.RelativeSource, err)
+			return
+		}
+		sourceURL, _ := url.Parse(string(embedDeclaration.RelativeSource))
+		anchor = slugify.Marshal(filepathBaseNoExt(sourceURL.Path), true)
 	} else {
-		filename = string(embedDeclaration.RelativeSource)
+		if !filepath.IsAbs(string(embedDeclaration.RelativeSource)) {
+			filename, _ = filepath.Abs(filepath.Join(ctx.PathToWorkFolder(workID), string(embedDeclaration.RelativeSource)))
+		} else {
+			filename = string(embedDeclaration.RelativeSource)
+		}
+		anchor = slugify.Marshal(filepathBaseNoExt(filename), true)
 	}
-	anchor = slugify.Marshal(filepathBaseNoExt(filename), true)
 	file, err := os.Open(filename)
 	if err != nil {
 		return
@@ -182,6 +213,33 @@ func (ctx *RunContext) AnalyzeMediaFile(workID string, embedDeclaration Media) (
 
 		if usedCache && cachedAnalysis.ContentType != "" {
 			ll.Debug("Reusing cached analysis %#v", cachedAnalysis)
+			pageChanged := cachedAnalysis.Attributes.Page != embedDeclaration.Attributes.Page
+			cachedAnalysis.Attributes = embedDeclaration.Attributes
+			// Stripping private metadata was maybe not enabled when the media was analyzed
+			if cachedAnalysis.Photo != nil && ctx.Config.Media.StripPrivateMetadata {
+				stripped := cachedAnalysis.Photo.withoutPrivateInformation()
+				cachedAnalysis.Photo = &stripped
+			}
+			// Placeholders were maybe not enabled when the media was analyzed
+			// Same for waveforms
+			if ctx.needsWaveform(cachedAnalysis) {
+				cachedAnalysis.Waveform, err = MakeWaveform(filename, ctx.waveformPeaksCount())
+				if err != nil {
+					ll.ErrorDisplay("Could not compute waveform of %s", err, filename)
+					err = nil
+				}
+			}
+			if strings.HasPrefix(cachedAnalysis.ContentType, "image/") && ctx.needsPlaceholders(cachedAnalysis) {
+				cachedAnalysis.BlurHash, cachedAnalysis.LQIP, err = ctx.makePlaceholders(filename)
+				if err != nil {
+					ll.Debug("Could not make placeholders of %s, they will be made from its thumbnails: %s", filename, err)
+					err = nil
+				}
+			}
+			if pageChanged {
+				ll.Debug("Page to make thumbnails of %s changed, thumbnails will be made again", filename)
+				return false, cachedAnalysis, anchor, nil
+			}
 			return true, cachedAnalysis, anchor, nil
 		} else if usedCache {
 			ll.Debug("UseMediaCache tells me to use cache for %s, but the cached analysis has no content type. Will reanalyze.", filename)
@@ -202,9 +260,15 @@ func (ctx *RunContext) AnalyzeMediaFile(workID string, embedDeclaration Media) (
 	isPDF := contentType == "application/pdf"
 
 	var dimensions ImageDimensions
-	var duration uint
+	var duration float64
 	var hasSound bool
 	var colors ColorPalette
+	var blurHash, lqip string
+	var photo *PhotoMetadata
+	var pages uint
+	var document *DocumentMetadata
+	var audioTags *AudioTags
+	var waveform []float64
 
 	if isImage {
 		if contentType == "image/svg" || contentType == "image/svg+xml" {
@@ -215,6 +279,25 @@ func (ctx *RunContext) AnalyzeMediaFile(workID string, embedDeclaration Media) (
 		if err != nil {
 			return
 		}
+		photo, err = ExtractPhotoMetadata(filename)
+		if err != nil {
+			ll.ErrorDisplay("Could not read EXIF and XMP metadata of %s", err, filename)
+			err = nil
+		}
+		if photo != nil {
+			ll.Debug("Photo metadata of %s: %#v", filename, photo)
+			if photo.SwapsDimensions() {
+				dimensions = ImageDimensions{
+					Width:       dimensions.Height,
+					Height:      dimensions.Width,
+					AspectRatio: float32(dimensions.Height) / float32(dimensions.Width),
+				}
+			}
+			if ctx.Config.Media.StripPrivateMetadata {
+				stripped := photo.withoutPrivateInformation()
+				photo = &stripped
+			}
+		}
 		if ctx.Config.ExtractColors.Enabled {
 			if canExtractColors(contentType) {
 				ll.Debug("Extracting colors from %s", filename)
@@ -228,43 +311,91 @@ func (ctx *RunContext) AnalyzeMediaFile(workID string, embedDeclaration Media) (
 				ll.Debug("Not extracting colors from %s: unsupported content type", filename)
 			}
 		}
+		if ctx.Config.MakePlaceholders.Enabled {
+			blurHash, lqip, err = ctx.makePlaceholders(filename)
+			if err != nil {
+				ll.Debug("Could not make placeholders of %s, they will be made from its thumbnails: %s", filename, err)
+				err = nil
+			}
+		}
 	}
 
 	if isVideo {
-		dimensions, duration, hasSound, err = AnalyzeVideo(filename)
+		var videoDuration uint
+		dimensions, videoDuration, hasSound, err = AnalyzeVideo(filename)
 		if err != nil {
 			return
 		}
+		duration = float64(videoDuration)
 		ll.Debug("Video analyzed: dimensions=%#v, duration=%v, hasSound=%v", dimensions, duration, hasSound)
 	}
 
 	if isAudio {
-		duration = AnalyzeAudio(file)
+		var tags AudioTags
+		duration, tags, err = AnalyzeAudio(file)
+		if err != nil {
+			ll.ErrorDisplay("Could not analyze audio file %s", err, filename)
+			err = nil
+		} else {
+			audioTags = &tags
+		}
 		hasSound = true
-		ll.Debug("Audio analyzed: duration=%v", duration)
+		ll.Debug("Audio analyzed: duration=%v, title=%q, artist=%q, album=%q", duration, tags.Title, tags.Artist, tags.Album)
+		if ctx.Config.MakeWaveforms.Enabled {
+			waveform, err = MakeWaveform(filename, ctx.waveformPeaksCount())
+			if err != nil {
+				ll.ErrorDisplay("Could not compute waveform of %s", err, filename)
+				err = nil
+			}
+		}
 	}
 
 	if isPDF {
-		ll.Warn("PDF analysis is disabled")
-		// dimensions, duration, err = AnalyzePDF(filename)
-		// if err != nil {
-		// 	return
-		// }
-		// LogDebug("PDF analyzed: dimensions=%#v, duration=%v", dimensions, duration)
+		var metadata DocumentMetadata
+		dimensions, pages, metadata, err = AnalyzePDF(filename)
+		if err != nil {
+			ll.ErrorDisplay("Could not analyze PDF %s", err, filename)
+			err = nil
+		} else {
+			document = &metadata
+			ll.Debug("PDF analyzed: dimensions=%#v, pages=%v, metadata=%#v", dimensions, pages, metadata)
+		}
+	}
+
+	distSource := embedDeclaration.RelativeSource.RelativeToMediaRoot(ctx, workID)
+	if online {
+		distSource = ctx.remoteMediaDistSource(workID, filename)
+	}
+
+	var audio *AudioMetadata
+	if audioTags != nil {
+		metadata := audioTags.Metadata()
+		if audioTags.Cover != nil && distSource != "" {
+			metadata.Cover = audioCoverPath(distSource, audioTags.CoverContentType)
+		}
+		audio = &metadata
 	}
 
 	analyzedMedia = Media{
 		Alt:            embedDeclaration.Alt,
 		Caption:        embedDeclaration.Caption,
 		RelativeSource: embedDeclaration.RelativeSource,
-		DistSource:     FilePathInsideMediaRoot(embedDeclaration.RelativeSource.RelativeToMediaRoot(ctx, workID)),
+		DistSource:     distSource,
+		Online:         online,
 		Attributes:     embedDeclaration.Attributes,
 		ContentType:    contentType,
 		Dimensions:     dimensions,
-		Duration:       float64(duration),
+		Duration:       duration,
+		Pages:          int(pages),
 		Size:           int(fileInfo.Size()),
 		HasSound:       hasSound,
 		Colors:         colors,
+		BlurHash:       blurHash,
+		LQIP:           lqip,
+		Photo:          photo,
+		Document:       document,
+		Audio:          audio,
+		Waveform:       waveform,
 		Analyzed:       true,
 		Hash:           contentHash,
 	}
@@ -328,48 +459,133 @@ func (ctx *RunContext) UseMediaCache(filename string, embedDeclaration Media, wo
 
 }
 
-// AnalyzeAudio takes in an os.File and returns the duration of the audio file in seconds. If any error occurs the duration will be 0.
-func AnalyzeAudio(file *os.File) uint {
-	var duration uint
-	decoder := mp3.NewDecoder(file)
-	skipped := 0
-	var frame mp3.Frame
-	for {
-		err := decoder.Decode(&frame, &skipped)
-		if err != nil {
-			break
-		} else {
-			duration += uint(frame.Duration().Seconds())
-		}
-	}
-	return duration
+// DocumentMetadata is information about a PDF document, as set by its author in the document itself.
+type DocumentMetadata struct {
+	Title     string `json:"title"`
+	Author    string `json:"author"`
+	Subject   string `json:"subject"`
+	CreatedAt string `json:"createdAt"` // in ISO 8601 format
 }
 
-// AnalyzePDF returns an ImageDimensions struct for the first page of the PDF file at filename. It also returns the number of pages.
-func AnalyzePDF(filename string) (dimensions ImageDimensions, pagesCount uint, err error) {
-	return ImageDimensions{}, 0, errors.New("PDF analysis is disabled")
-	/* fitz requires cgo, which goreleaser has a hard time with.
-	document, err := fitz.New(filename)
+// AnalyzePDF returns an ImageDimensions struct for the first page of the PDF file at filename, in points (1/72 of an inch). It also returns the number of pages and the document's metadata.
+func AnalyzePDF(filename string) (dimensions ImageDimensions, pagesCount uint, document DocumentMetadata, err error) {
+	// The PDF reader panics on malformed files instead of returning errors
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("while reading PDF: %v", recovered)
+		}
+	}()
+
+	file, reader, err := pdf.Open(filename)
+	if file != nil {
+		defer file.Close()
+	}
 	if err != nil {
-		return dimensions, pagesCount, fmt.Errorf("while opening PDF: %w", err)
+		return dimensions, pagesCount, document, fmt.Errorf("while opening PDF: %w", err)
 	}
 
-	defer document.Close()
+	info := reader.Trailer().Key("Info")
+	document = DocumentMetadata{
+		Title:     strings.TrimSpace(info.Key("Title").Text()),
+		Author:    strings.TrimSpace(info.Key("Author").Text()),
+		Subject:   strings.TrimSpace(info.Key("Subject").Text()),
+		CreatedAt: pdfDate(info.Key("CreationDate").Text()),
+	}
 
-	firstPage, err := document.Image(1)
-	if err != nil {
-		return dimensions, pagesCount, fmt.Errorf("while getting an image of the PDF's first page: %w", err)
+	pages := reader.Trailer().Key("Root").Key("Pages")
+	pagesCount = uint(max(0, pages.Key("Count").Int64()))
+	firstPage, found := pdfFirstPage(pages, 0)
+	if !found {
+		return dimensions, pagesCount, document, fmt.Errorf("PDF has no pages")
 	}
 
-	width := firstPage.Bounds().Size().X
-	height := firstPage.Bounds().Size().Y
+	// The crop box is what PDF viewers display, it defaults to the media box
+	box := pdfInheritedAttribute(firstPage, "CropBox")
+	if box.Len() != 4 {
+		box = pdfInheritedAttribute(firstPage, "MediaBox")
+	}
+	if box.Len() != 4 {
+		return dimensions, pagesCount, document, fmt.Errorf("first page of PDF has no valid media box")
+	}
+	width := int(math.Round(math.Abs(box.Index(2).Float64() - box.Index(0).Float64())))
+	height := int(math.Round(math.Abs(box.Index(3).Float64() - box.Index(1).Float64())))
+	if rotation := pdfInheritedAttribute(firstPage, "Rotate").Int64(); rotation%180 != 0 {
+		width, height = height, width
+	}
+	if width == 0 || height == 0 {
+		return dimensions, pagesCount, document, fmt.Errorf("first page of PDF is empty (%dx%d)", width, height)
+	}
 
 	return ImageDimensions{
-		Width:       int(width),
-		Height:      int(height),
+		Width:       width,
+		Height:      height,
 		AspectRatio: float32(width) / float32(height),
-	}, uint(document.NumPage()), nil
-	*/
+	}, pagesCount, document, nil
+}
+
+// pdfFirstPage returns the first page of the given node of a PDF page tree.
+// depth prevents infinite recursion on malformed files, where a node is its own descendant.
+func pdfFirstPage(node pdf.Value, depth int) (pdf.Value, bool) {
+	if depth > 64 || node.IsNull() {
+		return pdf.Value{}, false
+	}
+	if node.Key("Type").Name() == "Page" {
+		return node, true
+	}
+	kids := node.Key("Kids")
+	for i := 0; i < kids.Len(); i++ {
+		if page, found := pdfFirstPage(kids.Index(i), depth+1); found {
+			return page, true
+		}
+	}
+	return pdf.Value{}, false
+}
+
+// pdfInheritedAttribute returns the value of key in page, or in the closest of its ancestors in the page tree that has it, as attributes such as the media box can be set for a group of pages.
+func pdfInheritedAttribute(page pdf.Value, key string) pdf.Value {
+	node := page
+	for depth := 0; depth <= 64 && !node.IsNull(); depth++ {
+		if value := node.Key(key); !value.IsNull() {
+			return value
+		}
+		node = node.Key("Parent")
+	}
+	return pdf.Value{}
+}
+
+// pdfDate converts a PDF date ("D:YYYYMMDDHHmmSSOHH'mm'", every part after the year being optional) to ISO 8601. Invalid dates give an empty string.
+func pdfDate(value string) string {
+	value = strings.ReplaceAll(strings.TrimPrefix(strings.TrimSpace(value), "D:"), "'", "")
+	digits := strings.IndexFunc(value, func(char rune) bool { return char < '0' || char > '9' })
+	if digits == -1 {
+		digits = len(value)
+	}
+	if digits < 4 || digits > 14 || digits%2 != 0 {
+		return ""
+	}
+	// Missing parts default to the start of the year, month, day...
+	parsed, err := time.Parse("20060102150405", value[:digits]+"00000101000000"[digits:])
+	if err != nil {
+		return ""
+	}
+	formatted := parsed.Format("2006-01-02T15:04:05")
+
+	switch offset := value[digits:]; {
+	case offset == "":
+		return formatted
+	case offset[0] == 'Z':
+		return formatted + "Z"
+	case (offset[0] == '+' || offset[0] == '-') && (len(offset) == 3 || len(offset) == 5):
+		if _, err := strconv.Atoi(offset[1:]); err != nil {
+			return ""
+		}
+		minutes := "00"
+		if len(offset) == 5 {
+			minutes = offset[3:]
+		}
+		return formatted + offset[:3] + ":" + minutes
+	}
+	return ""
 }
 
 // AnalyzeVideo returns an ImageDimensions struct with the video's height, width and aspect ratio and a duration in seconds.
@@ -418,13 +634,28 @@ func (ctx *RunContext) HandleMedia(workID string, blockID string, embedDeclarati
 	}
 
 	absolutePathSource := media.RelativeSource.Absolute(ctx, workID)
+	if media.Online {
+		absolutePathSource = ctx.downloadedFile(media)
+	}
 	absolutePathDestination := media.DistSource.Absolute(ctx)
 
 	copyingStepStart := time.Now()
 	skipCopy := usedCache && fileExists(absolutePathDestination)
+	if skipCopy && ctx.Config.Media.StripPrivateMetadata && media.ContentType == "image/jpeg" {
+		// The copy was maybe made before stripping private metadata was enabled, its thumbnails too
+		if private, err := hasPrivateJPEGMetadata(absolutePathDestination); err != nil || private {
+			ll.Debug("Copying %s again and remaking its thumbnails since its copy still has private metadata", absolutePathSource)
+			skipCopy = false
+			usedCache = false
+		}
+	}
 	if skipCopy {
 		ll.Debug("Skipping media copy for %s because it already exists", absolutePathDestination)
 	}
+	if media.DistSource == "" {
+		ll.Debug("Not copying remote media %s to the media directory", media.RelativeSource)
+		skipCopy = true
+	}
 	if absolutePathDestination != absolutePathSource && !skipCopy {
 		err = os.MkdirAll(filepath.Dir(absolutePathDestination), 0o755)
 		if err != nil {
@@ -433,7 +664,12 @@ func (ctx *RunContext) HandleMedia(workID string, blockID string, embedDeclarati
 		}
 		if media.ContentType == "directory" {
 			err = recurcopy.CopyDirectory(absolutePathSource, absolutePathDestination)
+		} else if ctx.Config.Media.StripPrivateMetadata && media.ContentType == "image/jpeg" {
+			err = stripPrivateJPEGMetadata(absolutePathSource, absolutePathDestination)
 		} else {
+			if ctx.Config.Media.StripPrivateMetadata && media.Photo != nil {
+				ll.Warn("%s is copied to the media directory with its metadata, private metadata can only be removed from JPEG files", media.RelativeSource)
+			}
 			// content, err = os.ReadFile(absolutePathSource)
 			// if err != nil {
 			// 	err = fmt.Errorf("could not read file %s: %w", absolutePathSource, err)
@@ -457,31 +693,48 @@ func (ctx *RunContext) HandleMedia(workID string, blockID string, embedDeclarati
 			ll.Debug("%s: initializing thumbnails map since it's nil in the (previously built?) work", media.RelativeSource)
 			media.Thumbnails = make(map[int]FilePathInsideMediaRoot)
 		}
+		if media.ThumbnailSources == nil {
+			media.ThumbnailSources = make(ThumbnailSourcesMap)
+		}
+		type thumbnail struct {
+			size   int
+			format string
+		}
 		type result struct {
-			size    int
+			thumbnail
 			err     error
 			skipped bool
 		}
-		builtSizes := 0
+
+		// Every size is made in every format
+		formats := ctx.ThumbnailFormats()
+		thumbnailsToDo := make([]thumbnail, 0, len(ctx.Config.MakeThumbnails.Sizes)*len(formats))
+		for _, size := range ctx.Config.MakeThumbnails.Sizes {
+			for _, format := range formats {
+				thumbnailsToDo = append(thumbnailsToDo, thumbnail{size, format})
+			}
+		}
+		builtThumbnails := 0
 
 		results := make(chan result)
 
-		for i, sizesToDo := range chunkSlice(ctx.Config.MakeThumbnails.Sizes, ctx.thumbnailersPerWork) {
-			go func(i int, sizesToDo []int, results chan result) {
-				for _, size := range sizesToDo {
+		for i, chunk := range chunkSlice(thumbnailsToDo, ctx.thumbnailersPerWork) {
+			go func(i int, chunk []thumbnail, results chan result) {
+				for _, thumb := range chunk {
+					size := thumb.size
 					ll.Debug("Making thumbnail @%d for %s#%s", size, media.RelativeSource, blockID)
-					saveTo := ctx.ComputeOutputThumbnailFilename(media, blockID, workID, size, language)
+					saveTo := ctx.ComputeOutputThumbnailFilename(media, blockID, workID, size, thumb.format, language)
 
 					if _, err := os.Stat(string(saveTo.Absolute(ctx))); err == nil && usedCache {
-						ll.Debug("Skipping thumbnail creation @%d for %s#%s because it already exists", size, media.RelativeSource, blockID)
-						results <- result{size: size, skipped: true}
+						ll.Debug("Skipping thumbnail creation @%d for %s#%s because %s already exists", size, media.RelativeSource, blockID, saveTo)
+						results <- result{thumbnail: thumb, skipped: true}
 						continue
 					}
 
 					// Create potentially missing directories
 					os.MkdirAll(filepath.Dir(saveTo.Absolute(ctx)), 0777)
 
-					ctx.Status(workID, PhaseThumbnails, string(media.RelativeSource), fmt.Sprintf("%dpx", size))
+					ctx.Status(workID, PhaseThumbnails, string(media.RelativeSource), strings.TrimSpace(fmt.Sprintf("%dpx %s", size, thumb.format)))
 
 					// Make the thumbnail
 					err := ctx.MakeThumbnail(media, size, saveTo.Absolute(ctx))
@@ -490,27 +743,52 @@ func (ctx *RunContext) HandleMedia(workID string, blockID string, embedDeclarati
 						continue
 					}
 					ll.Debug("Made thumbnail %s", saveTo)
-					results <- result{size: size}
+					results <- result{thumbnail: thumb}
 				}
-			}(i, sizesToDo, results)
+			}(i, chunk, results)
 		}
 
 		for result := range results {
 			if result.err != nil {
 				return media, anchor, usedCache, result.err
 			}
-			media.Thumbnails[result.size] = ctx.ComputeOutputThumbnailFilename(media, blockID, workID, result.size, language)
+			saveTo := ctx.ComputeOutputThumbnailFilename(media, blockID, workID, result.size, result.format, language)
+			media.ThumbnailSources.set(result.size, thumbnailFormatOf(string(saveTo)), saveTo)
+			// The thumbnails map has the first format only
+			if result.format == formats[0] {
+				media.Thumbnails[result.size] = saveTo
+			}
 			if !result.skipped {
 				media.ThumbnailsBuiltAt = time.Now()
 			}
-			builtSizes++
+			builtThumbnails++
 
-			if builtSizes >= len(ctx.Config.MakeThumbnails.Sizes) {
+			if builtThumbnails >= len(thumbnailsToDo) {
 				close(results)
 			}
 		}
 	}
 	ll.TimeTrack(thumbnailsStepStart, "HandleMedia > thumbnails", media.RelativeSource)
 
+	if strings.HasPrefix(media.ContentType, "video/") && ctx.Config.MakeGifs.Enabled {
+		gifsStepStart := time.Now()
+		media.GIFs, err = ctx.MakeGIFs(media, blockID, workID, language, usedCache)
+		if err != nil {
+			return media, anchor, usedCache, fmt.Errorf("while making animated previews for %s: %w", workID, err)
+		}
+		ll.TimeTrack(gifsStepStart, "HandleMedia > gifs", media.RelativeSource)
+	}
+
+	if strings.HasPrefix(media.ContentType, "audio/") {
+		if err = ctx.makeAudioFiles(&media, blockID, workID, language, usedCache); err != nil {
+			return media, anchor, usedCache, fmt.Errorf("while handling audio file %s: %w", media.RelativeSource, err)
+		}
+	}
+
+	// Make placeholders of media that couldn't be decoded as images from their thumbnails, such as videos
+	if ctx.needsPlaceholders(media) && len(media.ThumbnailSources) > 0 {
+		ctx.makePlaceholdersFromThumbnails(&media)
+	}
+
 	return
 }
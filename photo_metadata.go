@@ -0,0 +1,493 @@
+package ortfodb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+// PhotoMetadata is information about how a photo was taken, as recorded by the camera (or editing software) in its EXIF and XMP metadata.
+type PhotoMetadata struct {
+	// When the photo was taken, in ISO 8601 format. There's a timezone offset only if it was recorded.
+	CapturedAt   string  `json:"capturedAt"`
+	CameraMake   string  `json:"cameraMake"`
+	CameraModel  string  `json:"cameraModel"`
+	Lens         string  `json:"lens"`
+	FocalLength  float64 `json:"focalLength"`  // in millimeters
+	Aperture     float64 `json:"aperture"`     // f-number
+	ExposureTime float64 `json:"exposureTime"` // in seconds
+	ISO          int     `json:"iso"`
+	// EXIF orientation, from 1 (upright) to 8, see https://magnushoff.com/articles/jpeg-orientation/.
+	// Dimensions of the media already take it into account.
+	Orientation int             `json:"orientation"`
+	Artist      string          `json:"artist"`
+	Copyright   string          `json:"copyright"`
+	GPS         *GPSCoordinates `json:"gps"` // where the photo was taken, if recorded
+}
+
+// GPSCoordinates represents a position on Earth.
+type GPSCoordinates struct {
+	Latitude  float64 `json:"latitude"`  // in degrees, negative in the southern hemisphere
+	Longitude float64 `json:"longitude"` // in degrees, negative west of Greenwich
+	Altitude  float64 `json:"altitude"`  // in meters above sea level
+}
+
+// SwapsDimensions returns true if the orientation of the photo rotates it by 90 degrees, so that its width and height as stored in the file are swapped when displayed.
+func (m PhotoMetadata) SwapsDimensions() bool {
+	return m.Orientation >= 5 && m.Orientation <= 8
+}
+
+// withoutPrivateInformation returns a copy of the metadata without the information that stripping private metadata removes.
+func (m PhotoMetadata) withoutPrivateInformation() PhotoMetadata {
+	m.GPS = nil
+	m.Artist = ""
+	return m
+}
+
+const (
+	xmpNamespaceEXIF       = "http://ns.adobe.com/exif/1.0/"
+	xmpNamespaceEXIFAux    = "http://ns.adobe.com/exif/1.0/aux/"
+	xmpNamespaceEXIFEX     = "http://cipa.jp/exif/1.0/"
+	xmpNamespaceTIFF       = "http://ns.adobe.com/tiff/1.0/"
+	xmpNamespaceXMP        = "http://ns.adobe.com/xap/1.0/"
+	xmpNamespacePhotoshop  = "http://ns.adobe.com/photoshop/1.0/"
+	xmpNamespaceDublinCore = "http://purl.org/dc/elements/1.1/"
+	xmpNamespaceRDF        = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+)
+
+// ExtractPhotoMetadata reads the EXIF and XMP metadata of the image at filename.
+// Values missing from the EXIF metadata are taken from the XMP metadata. It returns nil if the image has neither.
+func ExtractPhotoMetadata(filename string) (*PhotoMetadata, error) {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("while reading %s: %w", filename, err)
+	}
+
+	metadata := PhotoMetadata{}
+	found := false
+	// Decoding errors that are not critical still give usable metadata
+	if decoded, err := exif.Decode(bytes.NewReader(content)); err == nil || (decoded != nil && !exif.IsCriticalError(err)) {
+		metadata.fillFromEXIF(decoded)
+		found = true
+	}
+	if packet := findXMPPacket(content); packet != nil {
+		metadata.fillFromXMP(xmpProperties(packet))
+		found = true
+	}
+
+	if !found {
+		return nil, nil
+	}
+	return &metadata, nil
+}
+
+func (m *PhotoMetadata) fillFromEXIF(x *exif.Exif) {
+	text := func(name exif.FieldName) string {
+		tag, err := x.Get(name)
+		if err != nil {
+			return ""
+		}
+		value, err := tag.StringVal()
+		if err != nil {
+			return ""
+		}
+		return strings.TrimSpace(strings.TrimRight(value, "\x00"))
+	}
+	rational := func(name exif.FieldName) float64 {
+		tag, err := x.Get(name)
+		if err != nil {
+			return 0
+		}
+		numerator, denominator, err := tag.Rat2(0)
+		if err != nil || denominator == 0 {
+			return 0
+		}
+		return float64(numerator) / float64(denominator)
+	}
+	integer := func(name exif.FieldName) int {
+		tag, err := x.Get(name)
+		if err != nil {
+			return 0
+		}
+		value, err := tag.Int(0)
+		if err != nil {
+			return 0
+		}
+		return value
+	}
+
+	m.CapturedAt = exifDateTime(text(exif.DateTimeOriginal))
+	if m.CapturedAt == "" {
+		m.CapturedAt = exifDateTime(text(exif.DateTime))
+	}
+	m.CameraMake = text(exif.Make)
+	m.CameraModel = text(exif.Model)
+	m.Lens = text(exif.LensModel)
+	m.FocalLength = rational(exif.FocalLength)
+	m.Aperture = rational(exif.FNumber)
+	m.ExposureTime = rational(exif.ExposureTime)
+	m.ISO = integer(exif.ISOSpeedRatings)
+	m.Orientation = integer(exif.Orientation)
+	m.Artist = text(exif.Artist)
+	m.Copyright = text(exif.Copyright)
+
+	if latitude, longitude, err := x.LatLong(); err == nil && !math.IsNaN(latitude) && !math.IsNaN(longitude) {
+		m.GPS = &GPSCoordinates{Latitude: latitude, Longitude: longitude, Altitude: rational(exif.GPSAltitude)}
+		// Altitude reference 1 means below sea level
+		if integer(exif.GPSAltitudeRef) == 1 {
+			m.GPS.Altitude = -m.GPS.Altitude
+		}
+	}
+}
+
+// fillFromXMP sets the fields that are still empty from the given XMP properties, as returned by xmpProperties.
+func (m *PhotoMetadata) fillFromXMP(properties map[string]string) {
+	get := func(namespace string, names ...string) string {
+		for _, name := range names {
+			if value := properties[namespace+" "+name]; value != "" {
+				return value
+			}
+		}
+		return ""
+	}
+	setText := func(field *string, value string) {
+		if *field == "" {
+			*field = value
+		}
+	}
+	setNumber := func(field *float64, value string) {
+		if *field == 0 {
+			*field = xmpNumber(value)
+		}
+	}
+
+	setText(&m.CapturedAt, get(xmpNamespaceEXIF, "DateTimeOriginal"))
+	setText(&m.CapturedAt, get(xmpNamespacePhotoshop, "DateCreated"))
+	setText(&m.CapturedAt, get(xmpNamespaceXMP, "CreateDate"))
+	setText(&m.CameraMake, get(xmpNamespaceTIFF, "Make"))
+	setText(&m.CameraModel, get(xmpNamespaceTIFF, "Model"))
+	setText(&m.Lens, get(xmpNamespaceEXIFEX, "LensModel"))
+	setText(&m.Lens, get(xmpNamespaceEXIFAux, "Lens"))
+	setNumber(&m.FocalLength, get(xmpNamespaceEXIF, "FocalLength"))
+	setNumber(&m.Aperture, get(xmpNamespaceEXIF, "FNumber"))
+	setNumber(&m.ExposureTime, get(xmpNamespaceEXIF, "ExposureTime"))
+	if m.ISO == 0 {
+		m.ISO = int(xmpNumber(get(xmpNamespaceEXIF, "ISOSpeedRatings", "PhotographicSensitivity")))
+	}
+	if m.Orientation == 0 {
+		m.Orientation = int(xmpNumber(get(xmpNamespaceTIFF, "Orientation")))
+	}
+	setText(&m.Artist, get(xmpNamespaceDublinCore, "creator"))
+	setText(&m.Artist, get(xmpNamespaceTIFF, "Artist"))
+	setText(&m.Copyright, get(xmpNamespaceDublinCore, "rights"))
+	setText(&m.Copyright, get(xmpNamespaceTIFF, "Copyright"))
+
+	if m.GPS == nil {
+		latitude, latitudeOK := xmpGPSCoordinate(get(xmpNamespaceEXIF, "GPSLatitude"))
+		longitude, longitudeOK := xmpGPSCoordinate(get(xmpNamespaceEXIF, "GPSLongitude"))
+		if latitudeOK && longitudeOK {
+			m.GPS = &GPSCoordinates{Latitude: latitude, Longitude: longitude, Altitude: xmpNumber(get(xmpNamespaceEXIF, "GPSAltitude"))}
+			if get(xmpNamespaceEXIF, "GPSAltitudeRef") == "1" {
+				m.GPS.Altitude = -m.GPS.Altitude
+			}
+		}
+	}
+}
+
+// exifDateTime converts an EXIF date ("2006:01:02 15:04:05") to ISO 8601. Invalid dates give an empty string.
+func exifDateTime(value string) string {
+	parsed, err := time.Parse("2006:01:02 15:04:05", value)
+	if err != nil {
+		return ""
+	}
+	return parsed.Format("2006-01-02T15:04:05")
+}
+
+// findXMPPacket returns the XMP packet embedded in content, or nil if there's none.
+// XMP packets are stored as-is in every file format that supports them, so there's no need to parse the file's structure.
+func findXMPPacket(content []byte) []byte {
+	start := bytes.Index(content, []byte("<x:xmpmeta"))
+	if start == -1 {
+		return nil
+	}
+	end := bytes.Index(content[start:], []byte("</x:xmpmeta>"))
+	if end == -1 {
+		return nil
+	}
+	return content[start : start+end+len("</x:xmpmeta>")]
+}
+
+// xmpProperties returns the values of the properties of an XMP packet, keyed by their namespace URI and local name, separated by a space.
+// Properties can be written as attributes or elements. For arrays (such as the authors in dc:creator), the first item is used.
+func xmpProperties(packet []byte) map[string]string {
+	properties := make(map[string]string)
+	set := func(name xml.Name, value string) {
+		key := name.Space + " " + name.Local
+		if _, ok := properties[key]; !ok && value != "" {
+			properties[key] = value
+		}
+	}
+
+	decoder := xml.NewDecoder(bytes.NewReader(packet))
+	var elements []xml.Name
+	var text strings.Builder
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			break
+		}
+		switch token := token.(type) {
+		case xml.StartElement:
+			for _, attribute := range token.Attr {
+				if attribute.Name.Space != "" && attribute.Name.Space != "xmlns" && attribute.Name.Space != xmpNamespaceRDF {
+					set(attribute.Name, strings.TrimSpace(attribute.Value))
+				}
+			}
+			elements = append(elements, token.Name)
+			text.Reset()
+		case xml.CharData:
+			text.Write(token)
+		case xml.EndElement:
+			if len(elements) == 0 {
+				continue
+			}
+			elements = elements[:len(elements)-1]
+			value := strings.TrimSpace(text.String())
+			text.Reset()
+			// Items of arrays (rdf:li inside rdf:Seq, rdf:Bag or rdf:Alt) are values of the property that contains the array
+			name := token.Name
+			for i := len(elements) - 1; i >= 0 && name.Space == xmpNamespaceRDF; i-- {
+				name = elements[i]
+			}
+			if name.Space != xmpNamespaceRDF {
+				set(name, value)
+			}
+		}
+	}
+	return properties
+}
+
+// xmpNumber parses XMP numbers, which are either decimal or rational ("28/10"). Invalid numbers give 0.
+func xmpNumber(value string) float64 {
+	if numerator, denominator, ok := strings.Cut(value, "/"); ok {
+		n, err := strconv.ParseFloat(numerator, 64)
+		if err != nil {
+			return 0
+		}
+		d, err := strconv.ParseFloat(denominator, 64)
+		if err != nil || d == 0 {
+			return 0
+		}
+		return n / d
+	}
+	parsed, _ := strconv.ParseFloat(value, 64)
+	return parsed
+}
+
+// xmpGPSCoordinate parses XMP GPS coordinates, written as "DDD,MM.mmk" or "DDD,MM,SSk", k being N, S, E or W.
+func xmpGPSCoordinate(value string) (float64, bool) {
+	if len(value) < 2 {
+		return 0, false
+	}
+	direction := value[len(value)-1]
+	parts := strings.Split(value[:len(value)-1], ",")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, false
+	}
+	coordinate := 0.0
+	for i, part := range parts {
+		number, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return 0, false
+		}
+		coordinate += number / math.Pow(60, float64(i))
+	}
+	switch direction {
+	case 'S', 's', 'W', 'w':
+		return -coordinate, true
+	case 'N', 'n', 'E', 'e':
+		return coordinate, true
+	}
+	return 0, false
+}
+
+// EXIF tags that identify the photographer, their devices or their computer, removed when stripping private metadata.
+var privateEXIFTags = map[uint16]string{
+	0x013B: "Artist",
+	0x013C: "HostComputer",
+	0x9286: "UserComment",
+	0x927C: "MakerNote",
+	0x9C9C: "XPComment",
+	0x9C9D: "XPAuthor",
+	0xA420: "ImageUniqueID",
+	0xA430: "CameraOwnerName",
+	0xA431: "BodySerialNumber",
+	0xA435: "LensSerialNumber",
+}
+
+const (
+	exifGPSDirectoryTag  = 0x8825
+	exifEXIFDirectoryTag = 0x8769
+)
+
+// stripPrivateJPEGMetadata copies the JPEG file at source to destination without GPS coordinates and personal information in its metadata.
+// The EXIF GPS directory and the tags of privateEXIFTags are blanked, so that other tags (such as the orientation) are kept as-is. XMP and IPTC metadata are removed entirely.
+func stripPrivateJPEGMetadata(source string, destination string) error {
+	content, err := os.ReadFile(source)
+	if err != nil {
+		return fmt.Errorf("while reading %s: %w", source, err)
+	}
+	stripped, err := stripPrivateJPEGMetadataFrom(content)
+	if err != nil {
+		return fmt.Errorf("while stripping metadata of %s: %w", source, err)
+	}
+	return os.WriteFile(destination, stripped, 0o644)
+}
+
+// hasPrivateJPEGMetadata returns true if the JPEG file at filename has metadata that stripPrivateJPEGMetadata would remove.
+func hasPrivateJPEGMetadata(filename string) (bool, error) {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return false, fmt.Errorf("while reading %s: %w", filename, err)
+	}
+	stripped, err := stripPrivateJPEGMetadataFrom(content)
+	if err != nil {
+		return false, fmt.Errorf("while stripping metadata of %s: %w", filename, err)
+	}
+	return !bytes.Equal(content, stripped), nil
+}
+
+func stripPrivateJPEGMetadataFrom(content []byte) ([]byte, error) {
+	if len(content) < 2 || content[0] != 0xFF || content[1] != 0xD8 {
+		return nil, fmt.Errorf("not a JPEG file")
+	}
+	var output bytes.Buffer
+	output.Write(content[:2])
+	position := 2
+	for position+1 < len(content) {
+		if content[position] != 0xFF {
+			return nil, fmt.Errorf("invalid JPEG marker at offset %d", position)
+		}
+		marker := content[position+1]
+		switch {
+		case marker == 0xFF:
+			// Fill byte
+			output.WriteByte(0xFF)
+			position++
+			continue
+		case marker == 0xDA || marker == 0xD9:
+			// Start of scan or end of image: there are no more metadata segments
+			output.Write(content[position:])
+			return output.Bytes(), nil
+		case marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7):
+			// Markers without a segment
+			output.Write(content[position : position+2])
+			position += 2
+			continue
+		}
+
+		if position+4 > len(content) {
+			return nil, fmt.Errorf("truncated JPEG segment at offset %d", position)
+		}
+		end := position + 2 + int(binary.BigEndian.Uint16(content[position+2:]))
+		if end > len(content) {
+			return nil, fmt.Errorf("truncated JPEG segment at offset %d", position)
+		}
+		segment := content[position:end]
+		payload := segment[4:]
+		switch {
+		case marker == 0xE1 && bytes.HasPrefix(payload, []byte("Exif\x00\x00")):
+			segment = bytes.Clone(segment)
+			if err := blankPrivateEXIFTags(segment[4+6:]); err != nil {
+				// Better lose all of the EXIF metadata than leak private information
+				segment = nil
+			}
+		case marker == 0xE1 && (bytes.HasPrefix(payload, []byte(xmpNamespaceXMP+"\x00")) || bytes.HasPrefix(payload, []byte("http://ns.adobe.com/xmp/extension/\x00"))):
+			segment = nil
+		case marker == 0xED && bytes.HasPrefix(payload, []byte("Photoshop 3.0\x00")):
+			segment = nil
+		}
+		output.Write(segment)
+		position = end
+	}
+	output.Write(content[position:])
+	return output.Bytes(), nil
+}
+
+// blankPrivateEXIFTags modifies the EXIF metadata (a TIFF structure) in place: entries of the GPS directory and values of private tags are zeroed.
+func blankPrivateEXIFTags(tiff []byte) error {
+	if len(tiff) < 8 {
+		return fmt.Errorf("EXIF data is too short")
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return fmt.Errorf("invalid EXIF byte order %q", tiff[:2])
+	}
+
+	// Sizes in bytes of TIFF field types, indexed by type
+	typeSizes := []int{0, 1, 1, 2, 4, 8, 1, 1, 2, 4, 8, 4, 8}
+
+	var blankDirectory func(offset uint32, blankEverything bool, depth int) error
+	blankDirectory = func(offset uint32, blankEverything bool, depth int) error {
+		if depth > 2 || int(offset)+2 > len(tiff) {
+			return fmt.Errorf("invalid EXIF directory offset %d", offset)
+		}
+		count := int(order.Uint16(tiff[offset:]))
+		entries := int(offset) + 2
+		if entries+12*count > len(tiff) {
+			return fmt.Errorf("truncated EXIF directory at offset %d", offset)
+		}
+		for i := 0; i < count; i++ {
+			entry := tiff[entries+12*i : entries+12*(i+1)]
+			tag, fieldType, valuesCount := order.Uint16(entry), order.Uint16(entry[2:]), order.Uint32(entry[4:])
+
+			switch {
+			case !blankEverything && tag == exifGPSDirectoryTag:
+				if err := blankDirectory(order.Uint32(entry[8:]), true, depth+1); err != nil {
+					return err
+				}
+				continue
+			case !blankEverything && tag == exifEXIFDirectoryTag:
+				if err := blankDirectory(order.Uint32(entry[8:]), false, depth+1); err != nil {
+					return err
+				}
+				continue
+			}
+			if _, private := privateEXIFTags[tag]; !private && !blankEverything {
+				continue
+			}
+
+			if int(fieldType) >= len(typeSizes) {
+				return fmt.Errorf("unknown EXIF field type %d", fieldType)
+			}
+			size := uint64(typeSizes[fieldType]) * uint64(valuesCount)
+			if size <= 4 {
+				clear(entry[8:])
+			} else if valueOffset := uint64(order.Uint32(entry[8:])); valueOffset+size <= uint64(len(tiff)) {
+				clear(tiff[valueOffset : valueOffset+size])
+			} else {
+				return fmt.Errorf("invalid EXIF value offset %d", valueOffset)
+			}
+		}
+		if blankEverything {
+			// Leave an empty directory behind
+			clear(tiff[entries : entries+12*count])
+			order.PutUint16(tiff[offset:], 0)
+		}
+		return nil
+	}
+	return blankDirectory(order.Uint32(tiff[4:]), false, 0)
+}
@@ -0,0 +1,203 @@
+package ortfodb
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/disintegration/imaging"
+	ll "github.com/ewen-lbh/label-logger-go"
+)
+
+const (
+	// PlaceholderBlurHash is a BlurHash string, see https://blurha.sh.
+	PlaceholderBlurHash = "blurhash"
+	// PlaceholderLQIP is a low-quality image placeholder: a tiny version of the media, as a data: URL.
+	PlaceholderLQIP = "lqip"
+)
+
+// DefaultLQIPSize is the size of the largest side of LQIP images, when make placeholders.size is not set in the configuration.
+const DefaultLQIPSize = 16
+
+// blurHashSampleSize is the size of the image BlurHash components are computed from: the media is scaled down first, since placeholders are blurry anyway.
+const blurHashSampleSize = 64
+
+const base83Characters = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+type MakePlaceholdersConfiguration struct {
+	Enabled bool
+	// Placeholders to make: "blurhash" for a BlurHash string, "lqip" for a tiny base64-encoded WebP image. Defaults to both.
+	Kinds []string `yaml:"kinds,omitempty"`
+	// Size of the largest side of LQIP images, in pixels. Defaults to 16.
+	Size int `yaml:"size,omitempty"`
+}
+
+// makesPlaceholder returns true if placeholders of the given kind should be made.
+func (ctx *RunContext) makesPlaceholder(kind string) bool {
+	config := ctx.Config.MakePlaceholders
+	return config.Enabled && (len(config.Kinds) == 0 || slices.Contains(config.Kinds, kind))
+}
+
+// needsPlaceholders returns true if some of the placeholders that should be made are missing from media.
+func (ctx *RunContext) needsPlaceholders(media Media) bool {
+	return (ctx.makesPlaceholder(PlaceholderBlurHash) && media.BlurHash == "") || (ctx.makesPlaceholder(PlaceholderLQIP) && media.LQIP == "")
+}
+
+// makePlaceholders decodes the image at filename, and returns its placeholders.
+func (ctx *RunContext) makePlaceholders(filename string) (blurHash string, lqip string, err error) {
+	img, err := imaging.Open(filename, imaging.AutoOrientation(true))
+	if err != nil {
+		return "", "", fmt.Errorf("while decoding %s: %w", filename, err)
+	}
+	return ctx.placeholdersOf(img)
+}
+
+// placeholdersOf returns the placeholders of img, leaving empty the ones that should not be made.
+func (ctx *RunContext) placeholdersOf(img image.Image) (blurHash string, lqip string, err error) {
+	if ctx.makesPlaceholder(PlaceholderBlurHash) {
+		xComponents, yComponents := 4, 3
+		if img.Bounds().Dy() > img.Bounds().Dx() {
+			xComponents, yComponents = 3, 4
+		}
+		blurHash, err = BlurHash(imaging.Fit(img, blurHashSampleSize, blurHashSampleSize, imaging.Box), xComponents, yComponents)
+		if err != nil {
+			return "", "", fmt.Errorf("while computing BlurHash: %w", err)
+		}
+	}
+
+	if ctx.makesPlaceholder(PlaceholderLQIP) {
+		size := ctx.Config.MakePlaceholders.Size
+		if size == 0 {
+			size = DefaultLQIPSize
+		}
+		var encoded bytes.Buffer
+		if err := EncodeWebP(&encoded, imaging.Fit(img, size, size, imaging.Lanczos)); err != nil {
+			return "", "", fmt.Errorf("while encoding LQIP: %w", err)
+		}
+		lqip = "data:image/webp;base64," + base64.StdEncoding.EncodeToString(encoded.Bytes())
+	}
+	return
+}
+
+// makePlaceholdersFromThumbnails sets the missing placeholders of media from its smallest thumbnail that can be decoded.
+// This is used for media that can't be decoded as images, such as videos (their thumbnail is their first frame), PDFs or SVGs.
+func (ctx *RunContext) makePlaceholdersFromThumbnails(media *Media) {
+	for _, size := range media.ThumbnailSources.Sizes() {
+		for _, format := range mapKeys(media.ThumbnailSources[size]) {
+			thumbnail := media.ThumbnailSources[size][format].Absolute(ctx)
+			blurHash, lqip, err := ctx.makePlaceholders(thumbnail)
+			if err != nil {
+				ll.Debug("Could not make placeholders of %s from thumbnail %s: %s", media.RelativeSource, thumbnail, err)
+				continue
+			}
+			media.BlurHash, media.LQIP = blurHash, lqip
+			return
+		}
+	}
+	ll.Debug("No decodable thumbnail to make placeholders of %s from", media.RelativeSource)
+}
+
+// BlurHash encodes img to a BlurHash string with the given number of components on each axis (from 1 to 9).
+// See https://github.com/woltapp/blurhash/blob/master/Algorithm.md.
+func BlurHash(img image.Image, xComponents, yComponents int) (string, error) {
+	if xComponents < 1 || xComponents > 9 || yComponents < 1 || yComponents > 9 {
+		return "", fmt.Errorf("BlurHash components must be between 1 and 9, not %dx%d", xComponents, yComponents)
+	}
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width == 0 || height == 0 {
+		return "", fmt.Errorf("cannot compute the BlurHash of an empty image")
+	}
+
+	// Convert pixels to linear RGB once
+	linear := make([][3]float64, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			r, g, b, _ := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			linear[y*width+x] = [3]float64{sRGBToLinear(r >> 8), sRGBToLinear(g >> 8), sRGBToLinear(b >> 8)}
+		}
+	}
+
+	factors := make([][3]float64, 0, xComponents*yComponents)
+	for j := 0; j < yComponents; j++ {
+		for i := 0; i < xComponents; i++ {
+			normalisation := 2.0
+			if i == 0 && j == 0 {
+				normalisation = 1
+			}
+			var factor [3]float64
+			for y := 0; y < height; y++ {
+				for x := 0; x < width; x++ {
+					basis := math.Cos(math.Pi*float64(i)*float64(x)/float64(width)) * math.Cos(math.Pi*float64(j)*float64(y)/float64(height))
+					for c := 0; c < 3; c++ {
+						factor[c] += basis * linear[y*width+x][c]
+					}
+				}
+			}
+			scale := normalisation / float64(width*height)
+			factors = append(factors, [3]float64{factor[0] * scale, factor[1] * scale, factor[2] * scale})
+		}
+	}
+
+	var hash strings.Builder
+	hash.WriteString(encodeBase83((xComponents-1)+(yComponents-1)*9, 1))
+
+	dc, ac := factors[0], factors[1:]
+	maximumValue := 1.0
+	if len(ac) > 0 {
+		actualMaximum := 0.0
+		for _, factor := range ac {
+			for _, value := range factor {
+				actualMaximum = math.Max(actualMaximum, math.Abs(value))
+			}
+		}
+		quantisedMaximum := int(math.Max(0, math.Min(82, math.Floor(actualMaximum*166-0.5))))
+		maximumValue = float64(quantisedMaximum+1) / 166
+		hash.WriteString(encodeBase83(quantisedMaximum, 1))
+	} else {
+		hash.WriteString(encodeBase83(0, 1))
+	}
+
+	hash.WriteString(encodeBase83(linearToSRGB(dc[0])<<16+linearToSRGB(dc[1])<<8+linearToSRGB(dc[2]), 4))
+	for _, factor := range ac {
+		quantised := [3]int{}
+		for c, value := range factor {
+			quantised[c] = int(math.Max(0, math.Min(18, math.Floor(signedPow(value/maximumValue, 0.5)*9+9.5))))
+		}
+		hash.WriteString(encodeBase83(quantised[0]*19*19+quantised[1]*19+quantised[2], 2))
+	}
+	return hash.String(), nil
+}
+
+func encodeBase83(value int, length int) string {
+	encoded := make([]byte, length)
+	for i := length - 1; i >= 0; i-- {
+		encoded[i] = base83Characters[value%83]
+		value /= 83
+	}
+	return string(encoded)
+}
+
+func sRGBToLinear(value uint32) float64 {
+	v := float64(value) / 255
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(value float64) int {
+	v := math.Max(0, math.Min(1, value))
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func signedPow(value float64, exponent float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(value), exponent), value)
+}
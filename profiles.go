@@ -0,0 +1,117 @@
+package ortfodb
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ExporterProfileOption is the key of exporter options that selects the profile of the works the exporter receives.
+// It is reserved by ortfodb: it is not validated against the exporter's options type.
+const ExporterProfileOption = "profile"
+
+// ProfileConfiguration selects which works are part of a build profile.
+// Private, work-in-progress and scheduled works are excluded unless the profile includes them.
+type ProfileConfiguration struct {
+	// Include works marked as private.
+	Private bool `yaml:"private,omitempty"`
+	// Include works marked as work in progress.
+	WIP bool `yaml:"wip,omitempty"`
+	// Include works whose publish at date is in the future.
+	Scheduled bool `yaml:"scheduled,omitempty"`
+	// Only include works that have at least one of these tags.
+	Tags []string `yaml:"tags,omitempty"`
+	// Exclude works that have any of these tags.
+	ExcludeTags []string `yaml:"exclude tags,omitempty"`
+}
+
+// Includes returns true if the work is part of the profile at the given time.
+// Works with an invalid publish at date are considered scheduled.
+func (p ProfileConfiguration) Includes(work Work, now time.Time) bool {
+	if work.Metadata.Private && !p.Private {
+		return false
+	}
+	if work.Metadata.WIP && !p.WIP {
+		return false
+	}
+	if !p.Scheduled {
+		publishAt, err := work.Metadata.PublishDate()
+		if err != nil || publishAt.After(now) {
+			return false
+		}
+	}
+	hasTag := func(tag string) bool {
+		return some(work.Metadata.Tags, func(t string) bool { return stringsLooselyMatch(t, tag) })
+	}
+	if len(p.Tags) > 0 && !some(p.Tags, hasTag) {
+		return false
+	}
+	if some(p.ExcludeTags, hasTag) {
+		return false
+	}
+	return true
+}
+
+// InProfile returns the works of the database that are part of the profile. A nil profile includes every work.
+func (db Database) InProfile(profile *ProfileConfiguration) Database {
+	if profile == nil {
+		return db
+	}
+	now := time.Now()
+	works := make(Database, len(db))
+	for id, work := range db {
+		if profile.Includes(work, now) {
+			works[id] = work
+		}
+	}
+	return works
+}
+
+// Profile returns the profile named name in the configuration, or nil if name is empty.
+func (config Configuration) Profile(name string) (*ProfileConfiguration, error) {
+	if name == "" {
+		return nil, nil
+	}
+	profile, ok := config.Profiles[name]
+	if !ok {
+		available := mapKeys(config.Profiles)
+		slices.Sort(available)
+		return nil, fmt.Errorf("no profile named %q in the configuration, available profiles are: %s", name, strings.Join(available, ", "))
+	}
+	return &profile, nil
+}
+
+// BuildProfile returns the profile selected with --profile, which decides which works end up in the database file. It is nil when no profile was selected.
+func (ctx *RunContext) BuildProfile() *ProfileConfiguration {
+	profile, _ := ctx.Config.Profile(ctx.Flags.Profile)
+	return profile
+}
+
+// ExporterProfile returns the profile of the works the exporter receives: the one set by its profile option, or the build profile.
+func (ctx *RunContext) ExporterProfile(exporter Exporter) *ProfileConfiguration {
+	name, _ := ctx.Config.Exporters[exporter.Name()][ExporterProfileOption].(string)
+	if name == "" {
+		return ctx.BuildProfile()
+	}
+	profile, _ := ctx.Config.Profile(name)
+	return profile
+}
+
+// checkProfiles makes sure that the profiles exporters ask for exist.
+func checkProfiles(config Configuration) error {
+	for exporterName, options := range config.Exporters {
+		value, ok := options[ExporterProfileOption]
+		if !ok {
+			continue
+		}
+		name, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("exporters.%s.%s must be the name of a profile", exporterName, ExporterProfileOption)
+		}
+		if _, err := config.Profile(name); err != nil {
+			return fmt.Errorf("invalid exporters.%s.%s: %w", exporterName, ExporterProfileOption, err)
+		}
+	}
+	return nil
+}
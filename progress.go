@@ -17,6 +17,7 @@ type BuildPhase string
 
 const (
 	PhaseThumbnails    BuildPhase = "Thumbnailing"
+	PhaseGIFs          BuildPhase = "Animating"
 	PhaseMediaAnalysis BuildPhase = "Analyzing"
 	PhaseBuilding      BuildPhase = "Building"
 	PhaseBuilt         BuildPhase = "Built"
@@ -34,6 +35,7 @@ func padPhaseVerb(phase BuildPhase) string {
 
 func (ctx *RunContext) StartProgressBar(total int) {
 	worksToBuildCount = total
+	builtWorksCount = 0
 	ll.StartProgressBar(total, "Building", "magenta")
 }
 
@@ -0,0 +1,244 @@
+package ortfodb
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Query selects works of a database with a small query language. See ParseQuery for the syntax.
+type Query struct {
+	// The expression the query was parsed from
+	Expression string
+	// Used to resolve tag aliases (e.g. "books" also matches works tagged "book"). Tags are matched loosely if empty.
+	TagsRepository []Tag
+	// Used to resolve technology aliases (e.g. "golang" also matches works made with "go"). Technologies are matched loosely if empty.
+	TechnologiesRepository []Technology
+
+	terms []queryTerm
+}
+
+type queryTerm struct {
+	negated  bool
+	key      string
+	operator string
+	value    string
+}
+
+var queryKeyAliases = map[string]string{
+	"tags":       "tag",
+	"tech":       "madewith",
+	"technology": "madewith",
+	"made-with":  "madewith",
+	"language":   "lang",
+}
+
+var queryFlags = []string{"wip", "private"}
+
+var queryOperators = []string{">=", "<=", ":", "=", ">", "<"}
+
+// ParseQuery parses a query expression. Expressions are made of whitespace-separated terms, that must all match a work for it to be selected.
+// Prefix a term with - to negate it, and use double quotes for values that contain spaces.
+//
+//	tag:<tag>            works tagged with <tag>
+//	madewith:<tech>      works made with <tech> (also tech:<tech>)
+//	year<op><year>       works created in, after or before <year>. <op> is one of : = > < >= <=
+//	lang:<language>      works that have content in <language>
+//	id:<pattern>         works with an ID or alias matching <pattern>, a glob pattern
+//	title:<text>         works with a title containing <text>, in any language
+//	wip                  works in progress
+//	private              private works
+//	<text>               works with an ID or title containing <text>, including terms with an unknown key such as https://example.com
+//
+// For example: tag:book madewith:go year>=2020 -wip lang:fr
+func ParseQuery(expression string) (Query, error) {
+	query := Query{Expression: expression}
+	for _, token := range tokenizeQuery(expression) {
+		term, err := parseQueryTerm(token)
+		if err != nil {
+			return Query{}, fmt.Errorf("invalid query term %q: %w", token, err)
+		}
+		query.terms = append(query.terms, term)
+	}
+	return query, nil
+}
+
+// tokenizeQuery splits expression on whitespace, except inside double quotes. Quotes are removed.
+func tokenizeQuery(expression string) []string {
+	tokens := make([]string, 0)
+	var current strings.Builder
+	quoted := false
+	for _, char := range expression {
+		switch {
+		case char == '"':
+			quoted = !quoted
+		case unicode.IsSpace(char) && !quoted:
+			if current.Len() > 0 {
+				tokens = append(tokens, current.String())
+				current.Reset()
+			}
+		default:
+			current.WriteRune(char)
+		}
+	}
+	if current.Len() > 0 {
+		tokens = append(tokens, current.String())
+	}
+	return tokens
+}
+
+func parseQueryTerm(token string) (term queryTerm, err error) {
+	if strings.HasPrefix(token, "-") && len(token) > 1 {
+		term.negated = true
+		token = token[1:]
+	}
+
+	keyLength := strings.IndexFunc(token, func(r rune) bool { return !unicode.IsLetter(r) && r != '-' })
+	if keyLength <= 0 {
+		if stringInSlice(queryFlags, strings.ToLower(token)) {
+			term.key = strings.ToLower(token)
+			term.value = "true"
+		} else {
+			term.value = token
+		}
+		return
+	}
+
+	for _, operator := range queryOperators {
+		if strings.HasPrefix(token[keyLength:], operator) {
+			term.key = strings.ToLower(token[:keyLength])
+			term.operator = operator
+			term.value = token[keyLength+len(operator):]
+			break
+		}
+	}
+	if term.operator == "" {
+		// Not a key-value term after all, e.g. "c++"
+		term.value = token
+		return
+	}
+
+	if alias, ok := queryKeyAliases[term.key]; ok {
+		term.key = alias
+	}
+
+	switch term.key {
+	case "year":
+		if _, err := strconv.Atoi(term.value); err != nil {
+			return term, fmt.Errorf("year must be a number")
+		}
+	case "tag", "madewith", "lang", "id", "title", "wip", "private":
+		if term.operator != ":" && term.operator != "=" {
+			return term, fmt.Errorf("%s can't be compared with %s, use %s:<value>", term.key, term.operator, term.key)
+		}
+		if stringInSlice(queryFlags, term.key) {
+			if _, err := strconv.ParseBool(term.value); err != nil {
+				return term, fmt.Errorf("%s must be true or false", term.key)
+			}
+		}
+		if term.key == "id" {
+			if _, err := filepath.Match(term.value, ""); err != nil {
+				return term, fmt.Errorf("invalid pattern: %w", err)
+			}
+		}
+	default:
+		// Not a key-value term after all, e.g. "https://example.com" or "note:"
+		return queryTerm{negated: term.negated, value: token}, nil
+	}
+	return
+}
+
+// Language returns the language the query selects works in (with a lang: term), or an empty string if it doesn't.
+func (q Query) Language() string {
+	for _, term := range q.terms {
+		if term.key == "lang" && !term.negated {
+			return term.value
+		}
+	}
+	return ""
+}
+
+// Matches returns true if the work matches all of the query's terms.
+func (q Query) Matches(work Work) bool {
+	for _, term := range q.terms {
+		if q.termMatches(term, work) == term.negated {
+			return false
+		}
+	}
+	return true
+}
+
+func (q Query) termMatches(term queryTerm, work Work) bool {
+	switch term.key {
+	case "tag":
+		for _, tag := range q.TagsRepository {
+			if tag.ReferredToBy(term.value) {
+				return some(work.Metadata.Tags, tag.ReferredToBy)
+			}
+		}
+		return some(work.Metadata.Tags, func(t string) bool { return stringsLooselyMatch(t, term.value) })
+	case "madewith":
+		for _, tech := range q.TechnologiesRepository {
+			if tech.ReferredToBy(term.value) {
+				return some(work.Metadata.MadeWith, tech.ReferredToBy)
+			}
+		}
+		return some(work.Metadata.MadeWith, func(t string) bool { return stringsLooselyMatch(t, term.value) })
+	case "year":
+		if !work.Metadata.Dated() {
+			return false
+		}
+		year := work.Metadata.CreatedAt().Year()
+		value, _ := strconv.Atoi(term.value)
+		switch term.operator {
+		case ">":
+			return year > value
+		case "<":
+			return year < value
+		case ">=":
+			return year >= value
+		case "<=":
+			return year <= value
+		}
+		return year == value
+	case "lang":
+		_, ok := work.Content[term.value]
+		return ok
+	case "id":
+		return some(append([]string{work.ID}, work.Metadata.Aliases...), func(id string) bool {
+			matched, _ := filepath.Match(term.value, id)
+			return matched
+		})
+	case "title":
+		return q.titleContains(work, term.value)
+	case "wip":
+		value, _ := strconv.ParseBool(term.value)
+		return work.Metadata.WIP == value
+	case "private":
+		value, _ := strconv.ParseBool(term.value)
+		return work.Metadata.Private == value
+	}
+	return strings.Contains(strings.ToLower(work.ID), strings.ToLower(term.value)) || q.titleContains(work, term.value)
+}
+
+func (q Query) titleContains(work Work, text string) bool {
+	for _, content := range work.Content {
+		if strings.Contains(strings.ToLower(content.Title.String()), strings.ToLower(text)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Query returns the works matched by query, most recent works first.
+func (db Database) Query(query Query) []Work {
+	works := make([]Work, 0)
+	for _, work := range db.WorksByDate() {
+		if query.Matches(work) {
+			works = append(works, work)
+		}
+	}
+	return works
+}
@@ -0,0 +1,295 @@
+package ortfodb
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+
+	ll "github.com/ewen-lbh/label-logger-go"
+)
+
+// DefaultRemoteMediaTimeout is the maximum time to wait for a remote media file to download, when media.remote.timeout is not set in the configuration.
+const DefaultRemoteMediaTimeout = 60 * time.Second
+
+// RemoteMediaConfiguration configures how media with an http:// or https:// source are handled.
+type RemoteMediaConfiguration struct {
+	// Path to the directory where downloaded media files are cached. Defaults to an "ortfodb/media" directory in the user's cache directory.
+	Cache string `yaml:"cache,omitempty"`
+	// Copy downloaded media files to the media directory, like local media files. Otherwise, the media's distSource is left empty, and its URL (relativeSource) should be used instead.
+	Copy bool `yaml:"copy,omitempty"`
+	// Maximum time to wait for a media file to download, in seconds. Defaults to 60.
+	Timeout int `yaml:"timeout,omitempty"`
+}
+
+// isRemoteSource returns true if the given media source is an http:// or https:// URL.
+func isRemoteSource(source FilePathInsidePortfolioFolder) bool {
+	parsed, err := url.Parse(string(source))
+	return err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != ""
+}
+
+// remoteMediaCache stores downloaded media files in a directory, named after the SHA-256 hash of their content.
+// An index file in that directory maps URLs to their file and the HTTP validators needed to revalidate them.
+type remoteMediaCache struct {
+	mu        sync.Mutex
+	directory string
+	entries   map[string]remoteMediaCacheEntry
+	// URLs already downloaded or revalidated during this run
+	fresh map[string]bool
+	// Locks to prevent downloading the same URL twice at the same time
+	downloading map[string]*sync.Mutex
+}
+
+type remoteMediaCacheEntry struct {
+	// Name of the file in the cache directory
+	File         string    `json:"file"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"lastModified,omitempty"`
+	DownloadedAt time.Time `json:"downloadedAt"`
+}
+
+func (cache *remoteMediaCache) indexFile() string {
+	return filepath.Join(cache.directory, "index.json")
+}
+
+func (cache *remoteMediaCache) load() error {
+	cache.entries = make(map[string]remoteMediaCacheEntry)
+	cache.fresh = make(map[string]bool)
+	cache.downloading = make(map[string]*sync.Mutex)
+	if err := os.MkdirAll(cache.directory, 0o755); err != nil {
+		return fmt.Errorf("while creating remote media cache directory %s: %w", cache.directory, err)
+	}
+	if !fileExists(cache.indexFile()) {
+		return nil
+	}
+	content, err := os.ReadFile(cache.indexFile())
+	if err != nil {
+		return fmt.Errorf("while reading remote media cache index: %w", err)
+	}
+	if err := json.Unmarshal(content, &cache.entries); err != nil {
+		ll.Warn("remote media cache index at %s is corrupted, all remote media will be downloaded again: %s", cache.indexFile(), err)
+		cache.entries = make(map[string]remoteMediaCacheEntry)
+	}
+	return nil
+}
+
+// save writes the index. cache.mu must be held.
+func (cache *remoteMediaCache) save() error {
+	content, err := json.MarshalIndent(cache.entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("while encoding remote media cache index: %w", err)
+	}
+	// Write to a temporary file first, so that the index is never left half-written
+	temporary := cache.indexFile() + ".tmp"
+	if err := os.WriteFile(temporary, content, 0o644); err != nil {
+		return fmt.Errorf("while writing remote media cache index: %w", err)
+	}
+	return os.Rename(temporary, cache.indexFile())
+}
+
+// lookup returns the path to the cached file for mediaURL, if it's in the cache.
+func (cache *remoteMediaCache) lookup(mediaURL string) (entry remoteMediaCacheEntry, filename string, found bool) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	entry, found = cache.entries[mediaURL]
+	if !found {
+		return
+	}
+	filename = filepath.Join(cache.directory, entry.File)
+	found = fileExists(filename)
+	return
+}
+
+func (cache *remoteMediaCache) lockURL(mediaURL string) func() {
+	cache.mu.Lock()
+	lock, ok := cache.downloading[mediaURL]
+	if !ok {
+		lock = &sync.Mutex{}
+		cache.downloading[mediaURL] = lock
+	}
+	cache.mu.Unlock()
+	lock.Lock()
+	return lock.Unlock
+}
+
+func (cache *remoteMediaCache) put(mediaURL string, entry remoteMediaCacheEntry) error {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.entries[mediaURL] = entry
+	cache.fresh[mediaURL] = true
+	return cache.save()
+}
+
+func (cache *remoteMediaCache) isFresh(mediaURL string) bool {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	return cache.fresh[mediaURL]
+}
+
+func (cache *remoteMediaCache) markFresh(mediaURL string) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.fresh[mediaURL] = true
+}
+
+// RemoteMediaCacheDirectory returns the directory where remote media files are cached.
+func (ctx *RunContext) RemoteMediaCacheDirectory() (string, error) {
+	if ctx.Config.Media.Remote.Cache != "" {
+		return ctx.Config.Media.Remote.Cache, nil
+	}
+	userCache, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("while getting the user's cache directory (set media.remote.cache in the configuration to choose one): %w", err)
+	}
+	return filepath.Join(userCache, "ortfodb", "media"), nil
+}
+
+func (ctx *RunContext) remoteMedia() (*remoteMediaCache, error) {
+	ctx.remoteMediaOnce.Do(func() {
+		directory, err := ctx.RemoteMediaCacheDirectory()
+		if err != nil {
+			ctx.remoteMediaErr = err
+			return
+		}
+		cache := &remoteMediaCache{directory: directory}
+		ctx.remoteMediaErr = cache.load()
+		ctx.remoteMediaCache = cache
+	})
+	return ctx.remoteMediaCache, ctx.remoteMediaErr
+}
+
+// DownloadRemoteMedia returns the path to a local copy of the media file at mediaURL.
+// The file is downloaded to the remote media cache if it's not in there yet. Otherwise, it is revalidated (at most once per run) using its ETag or Last-Modified date, and downloaded again only if it changed.
+// With the --offline flag, only the cache is used.
+func (ctx *RunContext) DownloadRemoteMedia(mediaURL string) (string, error) {
+	cache, err := ctx.remoteMedia()
+	if err != nil {
+		return "", err
+	}
+
+	unlock := cache.lockURL(mediaURL)
+	defer unlock()
+
+	entry, cachedFile, cached := cache.lookup(mediaURL)
+	if cached && (ctx.Flags.Offline || cache.isFresh(mediaURL)) {
+		return cachedFile, nil
+	}
+	if ctx.Flags.Offline {
+		return "", fmt.Errorf("%s is not in the remote media cache at %s, and --offline is set", mediaURL, cache.directory)
+	}
+
+	request, err := http.NewRequest("GET", mediaURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("while creating request: %w", err)
+	}
+	request.Header.Set("User-Agent", "ortfodb/"+Version)
+	if cached {
+		if entry.ETag != "" {
+			request.Header.Set("If-None-Match", entry.ETag)
+		}
+		if entry.LastModified != "" {
+			request.Header.Set("If-Modified-Since", entry.LastModified)
+		}
+	}
+
+	timeout := DefaultRemoteMediaTimeout
+	if ctx.Config.Media.Remote.Timeout > 0 {
+		timeout = time.Duration(ctx.Config.Media.Remote.Timeout) * time.Second
+	}
+	ll.Debug("Downloading remote media %s (cached: %v)", mediaURL, cached)
+	response, err := (&http.Client{Timeout: timeout}).Do(request)
+	if err != nil {
+		if cached {
+			ll.WarnDisplay("could not revalidate %s, using cached version", err, mediaURL)
+			cache.markFresh(mediaURL)
+			return cachedFile, nil
+		}
+		return "", fmt.Errorf("while downloading: %w", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusNotModified && cached {
+		ll.Debug("Remote media %s was not modified since it was cached", mediaURL)
+		cache.markFresh(mediaURL)
+		return cachedFile, nil
+	}
+	if response.StatusCode != http.StatusOK {
+		if cached {
+			ll.Warn("could not revalidate %s (server responded with %s), using cached version", mediaURL, response.Status)
+			cache.markFresh(mediaURL)
+			return cachedFile, nil
+		}
+		return "", fmt.Errorf("server responded with %s", response.Status)
+	}
+
+	filename, err := cache.store(response.Body, path.Ext(request.URL.Path))
+	if err != nil {
+		return "", err
+	}
+	err = cache.put(mediaURL, remoteMediaCacheEntry{
+		File:         filepath.Base(filename),
+		ETag:         response.Header.Get("ETag"),
+		LastModified: response.Header.Get("Last-Modified"),
+		DownloadedAt: time.Now(),
+	})
+	if err != nil {
+		return "", err
+	}
+	return filename, nil
+}
+
+// store writes content to the cache directory, in a file named after the hash of the content, with the given extension.
+func (cache *remoteMediaCache) store(content io.Reader, extension string) (string, error) {
+	temporary, err := os.CreateTemp(cache.directory, "download-*")
+	if err != nil {
+		return "", fmt.Errorf("while creating temporary file: %w", err)
+	}
+	defer os.Remove(temporary.Name())
+
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(temporary, hash), content)
+	temporary.Close()
+	if err != nil {
+		return "", fmt.Errorf("while downloading: %w", err)
+	}
+
+	filename := filepath.Join(cache.directory, hex.EncodeToString(hash.Sum(nil))+extension)
+	if err := os.Rename(temporary.Name(), filename); err != nil {
+		return "", fmt.Errorf("while moving downloaded file to the cache: %w", err)
+	}
+	return filename, nil
+}
+
+// remoteMediaDistSource returns where a downloaded remote media file should be copied to, or an empty path if they are not copied (see RemoteMediaConfiguration.Copy).
+func (ctx *RunContext) remoteMediaDistSource(workID string, downloadedFile string) FilePathInsideMediaRoot {
+	if !ctx.Config.Media.Remote.Copy {
+		return ""
+	}
+	return FilePathInsideMediaRoot(filepath.Join(workID, "remote", filepath.Base(downloadedFile)))
+}
+
+// downloadedFile returns the path to the cached download of the given remote media, or an empty string if it's not in the cache.
+func (ctx *RunContext) downloadedFile(media Media) string {
+	cache, err := ctx.remoteMedia()
+	if err != nil {
+		return ""
+	}
+	_, filename, _ := cache.lookup(string(media.RelativeSource))
+	return filename
+}
+
+// mediaFile returns the path to a local file with the contents of the given (analyzed) media: its copy in the media directory, or the cached download for remote media that are not copied.
+func (ctx *RunContext) mediaFile(media Media) string {
+	if media.DistSource == "" && media.Online {
+		return ctx.downloadedFile(media)
+	}
+	return media.DistSource.Absolute(ctx)
+}
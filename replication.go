@@ -76,10 +76,6 @@ func (ctx *RunContext) replicateLocalizedBlock(work Work, language string) (stri
 	for _, block := range content.Blocks {
 		ll.Debug("replicating %s block #%s", block.Type, block.ID)
 		switch block.Type {
-		case "media":
-			result += ctx.replicateMediaEmbed(block.Media) + end
-		case "link":
-			result += ctx.replicateLink(block.Link) + end
 		case "paragraph":
 			replicatedParagraph, err := ctx.replicateParagraph(block.Anchor, block.Paragraph)
 			if err != nil {
@@ -91,7 +87,16 @@ func (ctx *RunContext) replicateLocalizedBlock(work Work, language string) (stri
 			replicatedParagraph = ctx.transformAbbreviations(parsedHTML, replicatedParagraph)
 			replicatedParagraph = ctx.transformFootnoteReferences(replicatedParagraph)
 			result += replicatedParagraph + end
-		default: // nothing
+		default:
+			blockType, ok := FindBlockType(block.Type)
+			if !ok {
+				return "", fmt.Errorf("cannot replicate block %s: unknown block type %q", block.ID, block.Type)
+			}
+			replicated, err := blockType.Replicate(ctx, block)
+			if err != nil {
+				return "", fmt.Errorf("while replicating %s block %s: %w", block.Type, block.ID, err)
+			}
+			result += replicated + end
 		}
 	}
 	for name, content := range content.Footnotes {
@@ -193,10 +198,14 @@ func (ctx *RunContext) replicateMediaAttributesString(attributes MediaAttributes
 
 // TODO: configure whether to use >[]() syntax: never, or only for non-images
 func (ctx *RunContext) replicateMediaEmbed(media Media) string {
+	source := string(media.RelativeSource)
+	if media.Attributes.Page > 0 {
+		source += fmt.Sprintf("#page=%d", media.Attributes.Page)
+	}
 	if media.Caption != "" {
-		return fmt.Sprintf(`![%s %s](%s "%s")`, media.Alt, ctx.replicateMediaAttributesString(media.Attributes), string(media.RelativeSource), media.Caption)
+		return fmt.Sprintf(`![%s %s](%s "%s")`, media.Alt, ctx.replicateMediaAttributesString(media.Attributes), source, media.Caption)
 	}
-	return fmt.Sprintf(`![%s %s](%s)`, media.Alt, ctx.replicateMediaAttributesString(media.Attributes), string(media.RelativeSource))
+	return fmt.Sprintf(`![%s %s](%s)`, media.Alt, ctx.replicateMediaAttributesString(media.Attributes), source)
 }
 
 func (ctx *RunContext) replicateParagraph(anchor string, p Paragraph) (string, error) {
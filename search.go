@@ -0,0 +1,284 @@
+package ortfodb
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode"
+
+	jsoniter "github.com/json-iterator/go"
+	"golang.org/x/text/unicode/norm"
+)
+
+// SearchIndexVersion is the version of the format of search indexes. See /db/exporters/search.md.
+const SearchIndexVersion = 1
+
+// Fields of works that are indexed for search.
+const (
+	SearchFieldTitle        = "title"
+	SearchFieldText         = "text"
+	SearchFieldCaption      = "caption"
+	SearchFieldAlt          = "alt"
+	SearchFieldTags         = "tags"
+	SearchFieldTechnologies = "technologies"
+)
+
+// DefaultSearchWeights are the weights of fields used when none are given: the score of a work for a term is the number of times the term appears in each field, multiplied by the field's weight.
+var DefaultSearchWeights = map[string]int{
+	SearchFieldTitle:        10,
+	SearchFieldTags:         6,
+	SearchFieldTechnologies: 6,
+	SearchFieldCaption:      3,
+	SearchFieldAlt:          2,
+	SearchFieldText:         1,
+}
+
+// SearchIndex is an inverted index of the works of a database, in a single language.
+type SearchIndex struct {
+	Version  int    `json:"version"`
+	Language string `json:"language"`
+	// Language of the stemmer applied to terms, empty if terms are not stemmed.
+	Stemmer string         `json:"stemmer"`
+	Weights map[string]int `json:"weights"`
+	// Indexed works. Postings refer to them by their index in this list.
+	Documents []SearchDocument `json:"documents"`
+	// Postings of each term. Empty in the index file of sharded indexes, see Shards.
+	Terms map[string]SearchPostings `json:"terms,omitempty"`
+	// Files that contain the postings of terms, keyed by the first character of the terms, for sharded indexes.
+	Shards map[string]string `json:"shards,omitempty"`
+}
+
+// SearchDocument is a work, as indexed for search.
+type SearchDocument struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+}
+
+// SearchPostings lists the works a term appears in, as pairs of the work's index in SearchIndex.Documents and its score for the term.
+type SearchPostings [][2]int
+
+// SearchResult is a work that matches a search query.
+type SearchResult struct {
+	ID    string
+	Title string
+	Score int
+}
+
+// BuildSearchIndex indexes the works of the database in the given language. Fields without a weight are not indexed.
+func BuildSearchIndex(works []Work, lang string, weights map[string]int) SearchIndex {
+	index := SearchIndex{
+		Version:   SearchIndexVersion,
+		Language:  lang,
+		Stemmer:   stemmerLanguage(lang),
+		Weights:   weights,
+		Documents: make([]SearchDocument, 0, len(works)),
+		Terms:     make(map[string]SearchPostings),
+	}
+	for i, work := range works {
+		content := work.Content.Localize(lang)
+		index.Documents = append(index.Documents, SearchDocument{ID: work.ID, Title: content.Title.String()})
+
+		fields := map[string][]string{
+			SearchFieldTitle:        {content.Title.String()},
+			SearchFieldTags:         work.Metadata.Tags,
+			SearchFieldTechnologies: work.Metadata.MadeWith,
+		}
+		for _, block := range content.Blocks {
+			switch {
+			case block.Type.IsParagraph(), block.Type.IsHeading(), block.Type.IsQuote():
+				fields[SearchFieldText] = append(fields[SearchFieldText], block.Content.String())
+			case block.Type.IsLink():
+				fields[SearchFieldText] = append(fields[SearchFieldText], block.Text.String())
+			}
+			for _, media := range block.Mediae() {
+				fields[SearchFieldCaption] = append(fields[SearchFieldCaption], media.Caption)
+				fields[SearchFieldAlt] = append(fields[SearchFieldAlt], media.Alt)
+			}
+		}
+
+		scores := make(map[string]int)
+		for field, texts := range fields {
+			for _, text := range texts {
+				for _, term := range SearchTerms(text, lang) {
+					scores[term] += weights[field]
+				}
+			}
+		}
+		for term, score := range scores {
+			if score > 0 {
+				index.Terms[term] = append(index.Terms[term], [2]int{i, score})
+			}
+		}
+	}
+	return index
+}
+
+// SearchTerms splits text into the terms it is indexed or searched with: words, in lowercase, without diacritics, stemmed according to the language.
+func SearchTerms(text string, lang string) []string {
+	terms := make([]string, 0)
+	for _, word := range searchWords(text) {
+		terms = append(terms, Stem(word, lang))
+	}
+	return terms
+}
+
+// searchWords splits text into words, in lowercase and without diacritics. Words of a single letter are left out.
+func searchWords(text string) []string {
+	var normalized strings.Builder
+	for _, r := range norm.NFD.String(strings.ToLower(text)) {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		normalized.WriteRune(r)
+	}
+	words := strings.FieldsFunc(normalized.String(), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return slices.DeleteFunc(words, func(word string) bool {
+		return len([]rune(word)) < 2 && !unicode.IsDigit([]rune(word)[0])
+	})
+}
+
+// shardOf returns the name of the shard that contains the term.
+func shardOf(term string) string {
+	first := []rune(term)[0]
+	if (first >= 'a' && first <= 'z') || (first >= '0' && first <= '9') {
+		return string(first)
+	}
+	return fmt.Sprintf("u%04x", first)
+}
+
+// Shard moves the postings of the index into shards, one per first character of the terms. It returns the postings of each shard, keyed by the shards' file names.
+func (index *SearchIndex) Shard() map[string]map[string]SearchPostings {
+	shards := make(map[string]map[string]SearchPostings)
+	index.Shards = make(map[string]string)
+	for term, postings := range index.Terms {
+		shard := shardOf(term)
+		file := shard + ".json"
+		if shards[file] == nil {
+			shards[file] = make(map[string]SearchPostings)
+		}
+		shards[file][term] = postings
+		index.Shards[string([]rune(term)[0])] = file
+	}
+	index.Terms = nil
+	return shards
+}
+
+// WriteSearchIndex writes the index to index.json in the given directory, along with the files of its shards if shard is true.
+func WriteSearchIndex(index SearchIndex, directory string, shard bool) error {
+	if err := os.MkdirAll(directory, 0o755); err != nil {
+		return fmt.Errorf("while creating directory %s: %w", directory, err)
+	}
+	json := jsoniter.ConfigCompatibleWithStandardLibrary
+	if shard {
+		for file, terms := range index.Shard() {
+			contents, err := json.Marshal(terms)
+			if err != nil {
+				return fmt.Errorf("while encoding shard %s: %w", file, err)
+			}
+			if err := os.WriteFile(filepath.Join(directory, file), contents, 0o644); err != nil {
+				return fmt.Errorf("while writing shard %s: %w", file, err)
+			}
+		}
+	}
+	contents, err := json.Marshal(index)
+	if err != nil {
+		return fmt.Errorf("while encoding search index: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(directory, "index.json"), contents, 0o644); err != nil {
+		return fmt.Errorf("while writing search index: %w", err)
+	}
+	return nil
+}
+
+// LoadSearchIndex loads the search index written to the given directory, along with all of its shards.
+func LoadSearchIndex(directory string) (SearchIndex, error) {
+	var index SearchIndex
+	json := jsoniter.ConfigCompatibleWithStandardLibrary
+	raw, err := readFileBytes(filepath.Join(directory, "index.json"))
+	if err != nil {
+		return index, err
+	}
+	if err := json.Unmarshal(raw, &index); err != nil {
+		return index, fmt.Errorf("while decoding search index in %s: %w", directory, err)
+	}
+	if index.Version != SearchIndexVersion {
+		return index, fmt.Errorf("search index in %s has version %d, but this version of ortfodb reads version %d", directory, index.Version, SearchIndexVersion)
+	}
+	if index.Terms == nil {
+		index.Terms = make(map[string]SearchPostings)
+	}
+	loaded := make([]string, 0)
+	for _, file := range index.Shards {
+		if slices.Contains(loaded, file) {
+			continue
+		}
+		raw, err := readFileBytes(filepath.Join(directory, file))
+		if err != nil {
+			return index, err
+		}
+		var terms map[string]SearchPostings
+		if err := json.Unmarshal(raw, &terms); err != nil {
+			return index, fmt.Errorf("while decoding search index shard %s: %w", file, err)
+		}
+		for term, postings := range terms {
+			index.Terms[term] = postings
+		}
+		loaded = append(loaded, file)
+	}
+	return index, nil
+}
+
+// Search returns the works that contain every term of the query, the best matches first. The last word of the query also matches terms that start with it, so that results can be shown as the query is typed.
+// The index must have its terms loaded, see LoadSearchIndex.
+func (index SearchIndex) Search(query string) []SearchResult {
+	words := searchWords(query)
+	if len(words) == 0 {
+		return []SearchResult{}
+	}
+
+	var scores map[int]int
+	for i, word := range words {
+		wordScores := make(map[int]int)
+		add := func(postings SearchPostings) {
+			for _, posting := range postings {
+				wordScores[posting[0]] = max(wordScores[posting[0]], posting[1])
+			}
+		}
+		add(index.Terms[Stem(word, index.Language)])
+		if i == len(words)-1 {
+			for term, postings := range index.Terms {
+				if strings.HasPrefix(term, word) {
+					add(postings)
+				}
+			}
+		}
+
+		if scores == nil {
+			scores = wordScores
+			continue
+		}
+		for document := range scores {
+			if score, ok := wordScores[document]; ok {
+				scores[document] += score
+			} else {
+				delete(scores, document)
+			}
+		}
+	}
+
+	results := make([]SearchResult, 0, len(scores))
+	for document, score := range scores {
+		results = append(results, SearchResult{ID: index.Documents[document].ID, Title: index.Documents[document].Title, Score: score})
+	}
+	slices.SortFunc(results, func(a, b SearchResult) int {
+		if a.Score != b.Score {
+			return b.Score - a.Score
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+	return results
+}
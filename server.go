@@ -0,0 +1,199 @@
+package ortfodb
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	ll "github.com/ewen-lbh/label-logger-go"
+	"github.com/fsnotify/fsnotify"
+	jsoniter "github.com/json-iterator/go"
+)
+
+// DatabaseServer serves a built database file as a read-only JSON API:
+//
+//	GET /works             list works, most recent first. Filters: ?tag=, ?madewith=, ?wip=, ?private=, ?year=
+//	GET /works/{id}        get a single work, by ID or alias
+//	GET /languages         list the languages of the database
+//	GET /media/{path}      media files and thumbnails, from the media directory
+//
+// Add ?lang=<language> to /works and /works/{id} to get works with their content localized to that language.
+type DatabaseServer struct {
+	mu       sync.RWMutex
+	database Database
+
+	// Path to the database JSON file to serve
+	DatabaseFile string
+	// Path to the directory media files were copied to during the build (media.at in the configuration). No media are served if empty.
+	MediaDirectory string
+	// Don't validate the database file against the JSON schema when (re)loading it
+	SkipValidation bool
+}
+
+// NewDatabaseServer loads the database file at databaseFile and returns a server for it.
+func NewDatabaseServer(databaseFile string, mediaDirectory string, skipValidation bool) (*DatabaseServer, error) {
+	server := &DatabaseServer{
+		DatabaseFile:   databaseFile,
+		MediaDirectory: mediaDirectory,
+		SkipValidation: skipValidation,
+	}
+	return server, server.Reload()
+}
+
+// Reload loads the database file again. The previously loaded database is kept if loading fails.
+func (s *DatabaseServer) Reload() error {
+	database, err := LoadDatabase(s.DatabaseFile, s.SkipValidation)
+	if err != nil {
+		return fmt.Errorf("while loading database %s: %w", s.DatabaseFile, err)
+	}
+	s.mu.Lock()
+	s.database = database
+	s.mu.Unlock()
+	return nil
+}
+
+// Database returns the currently served database.
+func (s *DatabaseServer) Database() Database {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.database
+}
+
+// WatchDatabaseFile reloads the database every time the database file changes. It blocks until stop is closed.
+func (s *DatabaseServer) WatchDatabaseFile(stop <-chan struct{}) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("while creating filesystem watcher: %w", err)
+	}
+	defer watcher.Close()
+
+	// Watch the directory instead of the file itself, since the file might get replaced instead of written to.
+	if err := watcher.Add(filepath.Dir(s.DatabaseFile)); err != nil {
+		return fmt.Errorf("while watching %s: %w", s.DatabaseFile, err)
+	}
+
+	databaseFile, _ := filepath.Abs(s.DatabaseFile)
+	debounce := time.NewTimer(WatchDebounceDelay)
+	debounce.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return nil
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			ll.WarnDisplay("filesystem watcher reported an error", err)
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if changed, _ := filepath.Abs(event.Name); changed == databaseFile && !event.Has(fsnotify.Chmod) {
+				debounce.Reset(WatchDebounceDelay)
+			}
+		case <-debounce.C:
+			if err := s.Reload(); err != nil {
+				ll.WarnDisplay("could not reload database, still serving the previous one", err)
+				continue
+			}
+			ll.Log("Reloaded", "cyan", "database from %s", s.DatabaseFile)
+		}
+	}
+}
+
+// Handler returns the HTTP handler that serves the API.
+func (s *DatabaseServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /works", s.handleWorks)
+	mux.HandleFunc("GET /works/{id}", s.handleWork)
+	mux.HandleFunc("GET /languages", s.handleLanguages)
+	if s.MediaDirectory != "" {
+		mux.Handle("GET /media/", http.StripPrefix("/media/", http.FileServer(http.Dir(s.MediaDirectory))))
+	}
+	return mux
+}
+
+func (s *DatabaseServer) handleWorks(w http.ResponseWriter, r *http.Request) {
+	filter, err := worksFilterFromQuery(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	works := s.Database().Filter(filter)
+	if lang := r.URL.Query().Get("lang"); lang != "" {
+		localized := make([]LocalizedWork, 0, len(works))
+		for _, work := range works {
+			localized = append(localized, work.Localize(lang))
+		}
+		respondWithJSON(w, localized)
+		return
+	}
+	respondWithJSON(w, works)
+}
+
+func (s *DatabaseServer) handleWork(w http.ResponseWriter, r *http.Request) {
+	work, found := s.Database().FindWork(r.PathValue("id"))
+	if !found {
+		respondWithError(w, http.StatusNotFound, fmt.Errorf("no work with ID or alias %q", r.PathValue("id")))
+		return
+	}
+
+	if lang := r.URL.Query().Get("lang"); lang != "" {
+		respondWithJSON(w, work.Localize(lang))
+		return
+	}
+	respondWithJSON(w, work)
+}
+
+func (s *DatabaseServer) handleLanguages(w http.ResponseWriter, r *http.Request) {
+	respondWithJSON(w, s.Database().Languages())
+}
+
+// worksFilterFromQuery builds a WorksFilter from the request's query parameters.
+func worksFilterFromQuery(r *http.Request) (filter WorksFilter, err error) {
+	query := r.URL.Query()
+	filter.Tags = query["tag"]
+	filter.MadeWith = append(query["madewith"], query["madeWith"]...)
+
+	for name, target := range map[string]**bool{"wip": &filter.WIP, "private": &filter.Private} {
+		if raw := query.Get(name); raw != "" {
+			value, err := strconv.ParseBool(raw)
+			if err != nil {
+				return filter, fmt.Errorf("invalid value %q for %s: must be true or false", raw, name)
+			}
+			*target = &value
+		}
+	}
+
+	if raw := query.Get("year"); raw != "" {
+		filter.Year, err = strconv.Atoi(raw)
+		if err != nil {
+			return filter, fmt.Errorf("invalid value %q for year: must be a number", raw)
+		}
+	}
+	return
+}
+
+func respondWithJSON(w http.ResponseWriter, data any) {
+	encoded, err := jsoniter.ConfigFastest.Marshal(data)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Write(encoded)
+}
+
+func respondWithError(w http.ResponseWriter, status int, err error) {
+	encoded, _ := jsoniter.ConfigFastest.Marshal(map[string]string{"error": err.Error()})
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.WriteHeader(status)
+	w.Write(encoded)
+}
@@ -0,0 +1,416 @@
+package ortfodb
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+// Columns of spreadsheets of work metadata, besides titles and custom fields.
+const (
+	SpreadsheetColumnID              = "id"
+	SpreadsheetColumnDescriptionHash = "description hash"
+	SpreadsheetColumnStarted         = "started"
+	SpreadsheetColumnFinished        = "finished"
+	SpreadsheetColumnTags            = "tags"
+	SpreadsheetColumnMadeWith        = "made with"
+	SpreadsheetColumnWIP             = "wip"
+	SpreadsheetColumnPrivate         = "private"
+)
+
+// Formats of spreadsheets of work metadata.
+const (
+	SpreadsheetFormatCSV = "csv"
+	SpreadsheetFormatTSV = "tsv"
+)
+
+// SpreadsheetDelimiter returns the delimiter of cells of the given spreadsheet format.
+func SpreadsheetDelimiter(format string) (rune, error) {
+	switch format {
+	case SpreadsheetFormatCSV:
+		return ',', nil
+	case SpreadsheetFormatTSV:
+		return '\t', nil
+	}
+	return 0, fmt.Errorf("unknown spreadsheet format %q, must be csv or tsv", format)
+}
+
+// SpreadsheetListSeparator separates items of lists in spreadsheet cells.
+const SpreadsheetListSeparator = ", "
+
+// spreadsheetListColumns are the built-in columns whose values are lists.
+var spreadsheetListColumns = []string{SpreadsheetColumnTags, SpreadsheetColumnMadeWith}
+
+// spreadsheetBooleanColumns are the built-in columns whose values are booleans.
+var spreadsheetBooleanColumns = []string{SpreadsheetColumnWIP, SpreadsheetColumnPrivate}
+
+// SpreadsheetImportReport tells what importing a spreadsheet did to each work.
+type SpreadsheetImportReport struct {
+	// Works whose description file was changed (or would be, for dry runs)
+	Updated []string
+	// Works whose metadata in the spreadsheet is the same as in their description file
+	Unchanged []string
+	// Works whose description file changed since the spreadsheet was exported. They are not updated unless forced.
+	Conflicts []string
+	// Works of the spreadsheet that don't exist
+	Unknown []string
+}
+
+// DescriptionHash returns the hash of the contents of a description file, as stored in Work.DescriptionHash.
+func DescriptionHash(descriptionRaw string) string {
+	hash := md5.Sum([]byte(descriptionRaw))
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+// titleColumn returns the name of the column of the work's title in the given language.
+func titleColumn(lang string) string {
+	if lang == "default" {
+		return "title"
+	}
+	return fmt.Sprintf("title (%s)", lang)
+}
+
+// SpreadsheetColumns returns the columns of spreadsheets of work metadata: the work's ID, the hash of its description file, its title in each language, its dates, tags, technologies, whether it is a work in progress or private, and the custom fields declared in the configuration.
+func SpreadsheetColumns(languages []string, customFields CustomFields) []string {
+	columns := []string{SpreadsheetColumnID, SpreadsheetColumnDescriptionHash}
+	languages = slices.Clone(languages)
+	slices.SortFunc(languages, func(a, b string) int {
+		if a == "default" || b == "default" {
+			return strings.Compare(titleColumn(a), titleColumn(b))
+		}
+		return strings.Compare(a, b)
+	})
+	for _, lang := range languages {
+		columns = append(columns, titleColumn(lang))
+	}
+	columns = append(columns, SpreadsheetColumnStarted, SpreadsheetColumnFinished, SpreadsheetColumnTags, SpreadsheetColumnMadeWith, SpreadsheetColumnWIP, SpreadsheetColumnPrivate)
+	return append(columns, customFields.names()...)
+}
+
+// SpreadsheetRow returns the cells of the work for the given columns (see SpreadsheetColumns).
+func SpreadsheetRow(work Work, columns []string) []string {
+	row := make([]string, 0, len(columns))
+	for _, column := range columns {
+		var value any
+		switch column {
+		case SpreadsheetColumnID:
+			value = work.ID
+		case SpreadsheetColumnDescriptionHash:
+			value = work.DescriptionHash
+		case SpreadsheetColumnStarted:
+			value = work.Metadata.Started
+		case SpreadsheetColumnFinished:
+			value = work.Metadata.Finished
+		case SpreadsheetColumnTags:
+			value = work.Metadata.Tags
+		case SpreadsheetColumnMadeWith:
+			value = work.Metadata.MadeWith
+		case SpreadsheetColumnWIP:
+			value = work.Metadata.WIP
+		case SpreadsheetColumnPrivate:
+			value = work.Metadata.Private
+		default:
+			value = work.Metadata.AdditionalMetadata[column]
+			for _, lang := range mapKeys(work.Content) {
+				if column == titleColumn(lang) {
+					value = work.Content[lang].Title.String()
+				}
+			}
+		}
+		row = append(row, spreadsheetCell(value))
+	}
+	return row
+}
+
+// spreadsheetCell formats a metadata value as the contents of a cell.
+func spreadsheetCell(value any) string {
+	switch value := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	case bool:
+		return strconv.FormatBool(value)
+	case time.Time:
+		return value.Format(time.DateOnly)
+	case []string:
+		return strings.Join(value, SpreadsheetListSeparator)
+	case []any:
+		items := make([]string, 0, len(value))
+		for _, item := range value {
+			items = append(items, spreadsheetCell(item))
+		}
+		return strings.Join(items, SpreadsheetListSeparator)
+	}
+	return fmt.Sprint(value)
+}
+
+// WriteSpreadsheet writes a row of metadata per work, as CSV with the given delimiter (usually , or a tab). There is a title column for every language works are written in.
+func WriteSpreadsheet(out io.Writer, works []Work, customFields CustomFields, delimiter rune) error {
+	writer := csv.NewWriter(out)
+	writer.Comma = delimiter
+	languages := make([]string, 0)
+	for _, work := range works {
+		for lang := range work.Content {
+			if !slices.Contains(languages, lang) {
+				languages = append(languages, lang)
+			}
+		}
+	}
+	columns := SpreadsheetColumns(languages, customFields)
+	if err := writer.Write(columns); err != nil {
+		return fmt.Errorf("while writing header row: %w", err)
+	}
+	for _, work := range works {
+		if err := writer.Write(SpreadsheetRow(work, columns)); err != nil {
+			return fmt.Errorf("while writing row of %s: %w", work.ID, err)
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// ImportSpreadsheet applies the metadata of the spreadsheet (as written by WriteSpreadsheet) to the front matter of the description files of its works. Only values that differ from the description file are written, and the rest of the file is left untouched. Titles can't be imported, as they are part of the markdown content.
+// Works whose description file changed since the spreadsheet was exported (their description hash differs) are conflicts, and are left as-is unless force is true.
+// With dryRun, no file is written.
+func (ctx *RunContext) ImportSpreadsheet(in io.Reader, delimiter rune, force bool, dryRun bool) (SpreadsheetImportReport, error) {
+	report := SpreadsheetImportReport{
+		Updated:   make([]string, 0),
+		Unchanged: make([]string, 0),
+		Conflicts: make([]string, 0),
+		Unknown:   make([]string, 0),
+	}
+	reader := csv.NewReader(in)
+	reader.Comma = delimiter
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return report, fmt.Errorf("while reading spreadsheet: %w", err)
+	}
+	if len(records) == 0 {
+		return report, nil
+	}
+
+	columns := records[0]
+	if !slices.Contains(columns, SpreadsheetColumnID) {
+		return report, fmt.Errorf("the spreadsheet has no %s column", SpreadsheetColumnID)
+	}
+	for _, column := range columns {
+		if !ctx.importableColumn(column) && column != SpreadsheetColumnID && column != SpreadsheetColumnDescriptionHash && !strings.HasPrefix(column, "title") {
+			return report, fmt.Errorf("unknown column %q: it is neither a built-in column nor a custom field declared in the configuration", column)
+		}
+	}
+
+	for i, record := range records[1:] {
+		cells := make(map[string]string)
+		for j, column := range columns {
+			if j < len(record) {
+				cells[column] = record[j]
+			}
+		}
+		workID := cells[SpreadsheetColumnID]
+		if workID == "" {
+			continue
+		}
+
+		filename := ctx.DescriptionFilename(ctx.DatabaseDirectory, workID)
+		if !fileExists(filename) {
+			report.Unknown = append(report.Unknown, workID)
+			continue
+		}
+		raw, err := readFile(filename)
+		if err != nil {
+			return report, fmt.Errorf("while reading description file of %s: %w", workID, err)
+		}
+		if hash := cells[SpreadsheetColumnDescriptionHash]; hash != "" && hash != DescriptionHash(raw) && !force {
+			report.Conflicts = append(report.Conflicts, workID)
+			continue
+		}
+
+		values := make(map[string]any)
+		current := frontMatterValues(raw)
+		for _, column := range columns {
+			if !ctx.importableColumn(column) {
+				continue
+			}
+			value, err := ctx.spreadsheetValue(column, cells[column])
+			if err != nil {
+				return report, fmt.Errorf("row %d (%s), column %s: %w", i+2, workID, column, err)
+			}
+			if ctx.spreadsheetCellOf(column, value) == ctx.spreadsheetCellOf(column, frontMatterValue(current, column)) {
+				continue
+			}
+			values[column] = value
+		}
+
+		if len(values) == 0 {
+			report.Unchanged = append(report.Unchanged, workID)
+			continue
+		}
+		updated, err := SetFrontMatter(raw, values)
+		if err != nil {
+			return report, fmt.Errorf("while updating front matter of %s: %w", workID, err)
+		}
+		if !dryRun {
+			if err := os.WriteFile(filename, []byte(updated), 0o644); err != nil {
+				return report, fmt.Errorf("while writing description file of %s: %w", workID, err)
+			}
+		}
+		report.Updated = append(report.Updated, workID)
+	}
+	return report, nil
+}
+
+// importableColumn returns true if values of the column are imported to front matters.
+func (ctx *RunContext) importableColumn(column string) bool {
+	switch column {
+	case SpreadsheetColumnStarted, SpreadsheetColumnFinished, SpreadsheetColumnTags, SpreadsheetColumnMadeWith, SpreadsheetColumnWIP, SpreadsheetColumnPrivate:
+		return true
+	}
+	_, ok := ctx.Config.CustomFields[column]
+	return ok
+}
+
+// spreadsheetCellOf formats a value of the column as the contents of a cell, as it is when exported: missing booleans are false, and missing custom fields have their default value.
+func (ctx *RunContext) spreadsheetCellOf(column string, value any) string {
+	if value != nil {
+		return spreadsheetCell(value)
+	}
+	if slices.Contains(spreadsheetBooleanColumns, column) {
+		return spreadsheetCell(false)
+	}
+	return spreadsheetCell(ctx.Config.CustomFields[column].Default)
+}
+
+// spreadsheetValue parses the contents of a cell of the column into the value to write to the front matter. Empty cells remove the value, and are returned as nil.
+func (ctx *RunContext) spreadsheetValue(column string, cell string) (any, error) {
+	cell = strings.TrimSpace(cell)
+	if cell == "" {
+		return nil, nil
+	}
+	splitList := func() []any {
+		items := make([]any, 0)
+		for _, item := range strings.Split(cell, strings.TrimSpace(SpreadsheetListSeparator)) {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		return items
+	}
+	switch {
+	case slices.Contains(spreadsheetListColumns, column):
+		return splitList(), nil
+	case slices.Contains(spreadsheetBooleanColumns, column):
+		value, err := coerceCustomFieldValue(CustomFieldBoolean, cell)
+		if value == false {
+			// Works are not work in progress or private unless told otherwise
+			return nil, err
+		}
+		return value, err
+	case column == SpreadsheetColumnStarted || column == SpreadsheetColumnFinished:
+		return coerceCustomFieldValue(CustomFieldDate, cell)
+	}
+	field := ctx.Config.CustomFields[column]
+	if field.List {
+		return field.coerce(splitList())
+	}
+	return field.coerce(cell)
+}
+
+// frontMatterValues decodes the YAML front matter of a description file.
+func frontMatterValues(descriptionRaw string) map[string]any {
+	values := make(map[string]any)
+	_, start, end := frontMatterBounds(descriptionRaw)
+	if start >= 0 {
+		lines := strings.Split(descriptionRaw, "\n")
+		yaml.Unmarshal([]byte(strings.Join(lines[start+1:end], "\n")), &values)
+	}
+	return values
+}
+
+// frontMatterValue returns the value of key in a front matter, whose key can also be written with underscores instead of spaces.
+func frontMatterValue(values map[string]any, key string) any {
+	if value, ok := values[key]; ok {
+		return value
+	}
+	return values[strings.ReplaceAll(key, " ", "_")]
+}
+
+// SetFrontMatter returns the description file with the given keys of its YAML front matter set to the given values, or removed for nil values. Comments and the order of other keys are kept, and the markdown content is left untouched.
+// A front matter is added if the description has none, and removed if no keys are left in it.
+func SetFrontMatter(descriptionRaw string, values map[string]any) (string, error) {
+	lines, start, end := frontMatterBounds(descriptionRaw)
+	var document yaml.Node
+	if start >= 0 {
+		if err := yaml.Unmarshal([]byte(strings.Join(lines[start+1:end], "\n")), &document); err != nil {
+			return "", fmt.Errorf("while parsing front matter: %w", err)
+		}
+	}
+	if len(document.Content) == 0 {
+		document = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+	mapping := document.Content[0]
+	if mapping.Kind != yaml.MappingNode {
+		return "", fmt.Errorf("front matter is not a YAML object")
+	}
+
+	keys := mapKeys(values)
+	slices.Sort(keys)
+	for _, key := range keys {
+		index := -1
+		for i := 0; i+1 < len(mapping.Content); i += 2 {
+			if name := mapping.Content[i].Value; name == key || name == strings.ReplaceAll(key, " ", "_") {
+				index = i
+				break
+			}
+		}
+		if values[key] == nil {
+			if index >= 0 {
+				mapping.Content = slices.Delete(mapping.Content, index, index+2)
+			}
+			continue
+		}
+		var value yaml.Node
+		if err := value.Encode(values[key]); err != nil {
+			return "", fmt.Errorf("while encoding %s: %w", key, err)
+		}
+		if _, err := time.Parse(time.DateOnly, value.Value); err == nil && value.Tag == "!!str" {
+			// Write dates as YAML timestamps, like description files usually do, instead of quoted strings
+			value.Tag = "!!timestamp"
+			value.Style = 0
+		}
+		if index >= 0 {
+			value.Style |= mapping.Content[index+1].Style & yaml.FlowStyle
+			value.LineComment = mapping.Content[index+1].LineComment
+			mapping.Content[index+1] = &value
+		} else {
+			mapping.Content = append(mapping.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, &value)
+		}
+	}
+
+	rest := lines
+	if start >= 0 {
+		rest = lines[end+1:]
+	}
+	if len(mapping.Content) == 0 {
+		return strings.Join(rest, "\n"), nil
+	}
+
+	var header strings.Builder
+	encoder := yaml.NewEncoder(&header)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&document); err != nil {
+		return "", fmt.Errorf("while encoding front matter: %w", err)
+	}
+	encoder.Close()
+	return "---\n" + header.String() + "---\n" + strings.Join(rest, "\n"), nil
+}
@@ -0,0 +1,97 @@
+package ortfodb
+
+import (
+	"slices"
+	"strings"
+)
+
+// stemmers are light stemmers, that remove the most common inflectional and derivational suffixes of words of a language. Words have no diacritics and are in lowercase.
+// They are keyed by language code (without region).
+var stemmers = map[string]func(word string) string{
+	"en": stemEnglish,
+	"fr": stemFrench,
+	"es": stemSpanish,
+	"de": stemGerman,
+}
+
+// StemmerLanguages returns the languages for which words are stemmed.
+func StemmerLanguages() []string {
+	languages := mapKeys(stemmers)
+	slices.Sort(languages)
+	return languages
+}
+
+// stemmerLanguage returns the language of the stemmer used for the given language code (such as en or en-US), or an empty string if words in that language are not stemmed.
+func stemmerLanguage(lang string) string {
+	base := strings.ToLower(strings.SplitN(strings.ReplaceAll(lang, "_", "-"), "-", 2)[0])
+	if _, ok := stemmers[base]; ok {
+		return base
+	}
+	return ""
+}
+
+// Stem returns the stem of a word (in lowercase and without diacritics) of the given language. Words of languages without a stemmer are returned as-is.
+func Stem(word string, lang string) string {
+	stemmer, ok := stemmers[stemmerLanguage(lang)]
+	if !ok {
+		return word
+	}
+	return stemmer(word)
+}
+
+// stripSuffix removes the first of suffixes that word ends with, if what remains has at least minimum letters. replacements replace the suffix with the same index, if any.
+func stripSuffix(word string, minimum int, suffixes []string, replacements ...string) (string, bool) {
+	for i, suffix := range suffixes {
+		if strings.HasSuffix(word, suffix) && len(word)-len(suffix) >= minimum {
+			stem := strings.TrimSuffix(word, suffix)
+			if i < len(replacements) {
+				stem += replacements[i]
+			}
+			return stem, true
+		}
+	}
+	return word, false
+}
+
+func stemEnglish(word string) string {
+	// Plurals, see Harman's S-stemmer
+	switch {
+	case strings.HasSuffix(word, "ies") && !strings.HasSuffix(word, "eies") && !strings.HasSuffix(word, "aies") && len(word) > 4:
+		word = strings.TrimSuffix(word, "ies") + "y"
+	case strings.HasSuffix(word, "es") && !strings.HasSuffix(word, "aes") && !strings.HasSuffix(word, "ees") && !strings.HasSuffix(word, "oes") && len(word) > 3:
+		word = strings.TrimSuffix(word, "s")
+	case strings.HasSuffix(word, "s") && !strings.HasSuffix(word, "us") && !strings.HasSuffix(word, "ss") && len(word) > 3:
+		word = strings.TrimSuffix(word, "s")
+	}
+	word, _ = stripSuffix(word, 3, []string{"izations", "ization", "ations", "ation", "nesses", "ness", "ments", "ment", "ingly", "edly", "ing", "ed", "ly", "ity", "ive", "ful", "able", "ible", "er", "est", "al"})
+	return strings.TrimSuffix(word, "e")
+}
+
+func stemFrench(word string) string {
+	// Plurals
+	if stem, ok := stripSuffix(word, 3, []string{"aux"}, "al"); ok {
+		word = stem
+	} else if len(word) > 3 && (strings.HasSuffix(word, "s") || strings.HasSuffix(word, "x")) {
+		word = word[:len(word)-1]
+	}
+	word, _ = stripSuffix(word, 3, []string{"issements", "issement", "ements", "ement", "ations", "ation", "atrices", "atrice", "ateurs", "ateur", "iques", "ique", "ismes", "isme", "istes", "iste", "ables", "able", "euses", "euse", "eurs", "eur", "ites", "ite", "ives", "ive", "ees", "ee", "er", "ez", "es", "e"})
+	return word
+}
+
+func stemSpanish(word string) string {
+	// Plurals
+	if stem, ok := stripSuffix(word, 3, []string{"ces"}, "z"); ok {
+		word = stem
+	} else if stem, ok := stripSuffix(word, 3, []string{"es", "s"}); ok {
+		word = stem
+	}
+	word, _ = stripSuffix(word, 3, []string{"amientos", "amiento", "imientos", "imiento", "aciones", "acion", "adoras", "adora", "adores", "ador", "ancias", "ancia", "encias", "encia", "mente", "idades", "idad", "ables", "able", "ibles", "ible", "istas", "ista", "ismos", "ismo", "osos", "oso", "osas", "osa", "ar", "er", "ir", "o", "a", "e"})
+	return word
+}
+
+func stemGerman(word string) string {
+	word = strings.ReplaceAll(word, "ß", "ss")
+	word, _ = stripSuffix(word, 3, []string{"ungen", "ung", "heiten", "heit", "keiten", "keit", "lichen", "liche", "lich", "ischen", "ische", "isch"})
+	word, _ = stripSuffix(word, 3, []string{"ern", "em", "en", "er", "es", "e", "s", "n"})
+	return word
+}
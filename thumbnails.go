@@ -6,21 +6,19 @@ import (
 	ll "github.com/ewen-lbh/label-logger-go"
 	"io"
 	"io/ioutil"
+	"math"
 	"os"
 	"os/exec"
 	"path"
 	"path/filepath"
+	"slices"
+	"sort"
 	"strings"
 )
 
 var ThumbnailableContentTypes = []string{"image/*", "video/*", "application/pdf"}
 
 func (m Media) Thumbnailable() bool {
-	// TODO
-	if m.Online {
-		return false
-	}
-
 	for _, contentTypePattern := range ThumbnailableContentTypes {
 		match, err := filepath.Match(contentTypePattern, m.ContentType)
 		if err != nil {
@@ -37,7 +35,15 @@ func (m Media) Thumbnailable() bool {
 // It returns the path where the thumbnail has been written to.
 // saveTo should be relative to cwd.
 func (ctx *RunContext) MakeThumbnail(media Media, targetSize int, saveTo string) error {
-	ll.Debug("Making thumbnail for %s at size %d to %s", media.DistSource.Absolute(ctx), targetSize, saveTo)
+	backend, err := ctx.thumbnailBackend(media, saveTo)
+	if err != nil {
+		return err
+	}
+	ll.Debug("Making thumbnail for %s at size %d to %s with %s backend", ctx.mediaFile(media), targetSize, saveTo, backend)
+	if backend == ThumbnailBackendNative {
+		return ctx.makeNativeThumbnail(media, targetSize, saveTo)
+	}
+
 	if media.ContentType == "image/gif" {
 		return ctx.makeGifThumbnail(media, targetSize, saveTo)
 	}
@@ -47,24 +53,34 @@ func (ctx *RunContext) MakeThumbnail(media Media, targetSize int, saveTo string)
 	}
 
 	if strings.HasPrefix(media.ContentType, "image/") {
-		return run("magick", media.DistSource.Absolute(ctx), "-resize", fmt.Sprint(targetSize), saveTo)
+		// Rotate according to the EXIF orientation, since thumbnails are not guaranteed to keep it
+		args := []string{ctx.mediaFile(media), "-auto-orient", "-resize", fmt.Sprint(targetSize)}
+		if ctx.Config.Media.StripPrivateMetadata {
+			args = append(args, "-strip")
+		}
+		return run("magick", append(args, ctx.magickOutputArgs(saveTo)...)...)
 	}
 
 	if strings.HasPrefix(media.ContentType, "video/") {
-		return run("ffmpegthumbnailer", "-i"+media.DistSource.Absolute(ctx), "-o"+saveTo, fmt.Sprintf("-s%d", targetSize))
+		args := []string{"-i" + ctx.mediaFile(media), "-o" + saveTo, fmt.Sprintf("-s%d", targetSize)}
+		if quality := ctx.thumbnailQuality(saveTo); quality > 0 {
+			// ffmpegthumbnailer's quality goes from 0 to 10
+			args = append(args, fmt.Sprintf("-q%d", quality/10))
+		}
+		return run("ffmpegthumbnailer", args...)
 	}
 
 	if media.ContentType == "application/pdf" {
 		return ctx.makePdfThumbnail(media, targetSize, saveTo)
 	}
 
-	return fmt.Errorf("cannot make a thumbnail for %s: unsupported content type %s", media.DistSource.Absolute(ctx), media.ContentType)
+	return fmt.Errorf("cannot make a thumbnail for %s: unsupported content type %s", ctx.mediaFile(media), media.ContentType)
 
 }
 
 func (ctx *RunContext) makeSvgThumbnail(media Media, targetSize int, saveTo string) error {
 	// Use resvg instead of magick, because magick delegates to inkscape which is not reliable in parallel (see https://gitlab.com/inkscape/inkscape/-/issues/4716)
-	return run("resvg", "--width", fmt.Sprint(targetSize), "--height", fmt.Sprint(targetSize), media.DistSource.Absolute(ctx), saveTo)
+	return run("resvg", "--width", fmt.Sprint(targetSize), "--height", fmt.Sprint(targetSize), ctx.mediaFile(media), saveTo)
 }
 
 func (ctx *RunContext) makePdfThumbnail(media Media, targetSize int, saveTo string) error {
@@ -75,12 +91,28 @@ func (ctx *RunContext) makePdfThumbnail(media Media, targetSize int, saveTo stri
 		return err
 	}
 	// TODO: (maybe) update media.Dimensions now that we have an image of the PDF though this will only be representative when all pages of the PDF have the same dimensions.
+	args := []string{"-singlefile", "-png"}
+	if page := media.Attributes.Page; page > 0 {
+		if media.Pages > 0 && page > media.Pages {
+			ll.Warn("%s has %d pages, cannot make thumbnails of page %d: using the first page instead", media.RelativeSource, media.Pages, page)
+		} else {
+			args = append(args, "-f", fmt.Sprint(page), "-l", fmt.Sprint(page))
+		}
+	}
 	// pdftoppm *adds* the extension to the end of the filename even if it already has it... smh.
-	err = run("pdftoppm", "-singlefile", "-png", media.DistSource.Absolute(ctx), strings.TrimSuffix(temporaryPng.Name(), ".png"))
+	err = run("pdftoppm", append(args, ctx.mediaFile(media), strings.TrimSuffix(temporaryPng.Name(), ".png"))...)
 	if err != nil {
 		return err
 	}
-	return run("magick", temporaryPng.Name(), "-thumbnail", fmt.Sprint(targetSize), saveTo)
+	return run("magick", append([]string{temporaryPng.Name(), "-thumbnail", fmt.Sprint(targetSize)}, ctx.magickOutputArgs(saveTo)...)...)
+}
+
+// magickOutputArgs returns the last arguments to pass to magick: the configured quality if any, then the output file.
+func (ctx *RunContext) magickOutputArgs(saveTo string) []string {
+	if quality := ctx.thumbnailQuality(saveTo); quality > 0 {
+		return []string{"-quality", fmt.Sprint(quality), saveTo}
+	}
+	return []string{saveTo}
 }
 
 func (ctx *RunContext) makeGifThumbnail(media Media, targetSize int, saveTo string) error {
@@ -90,7 +122,7 @@ func (ctx *RunContext) makeGifThumbnail(media Media, targetSize int, saveTo stri
 	} else {
 		dimensionToResize = "height"
 	}
-	source, err := os.Open(media.DistSource.Absolute(ctx))
+	source, err := os.Open(ctx.mediaFile(media))
 	if err != nil {
 		return fmt.Errorf("while opening source media: %w", err)
 	}
@@ -109,7 +141,7 @@ func (ctx *RunContext) makeGifThumbnail(media Media, targetSize int, saveTo stri
 	}
 
 	if strings.HasSuffix(saveTo, ".webp") {
-		err = convertGifToWebp(tempGif.Name(), saveTo)
+		err = convertGifToWebp(tempGif.Name(), saveTo, ctx.thumbnailQuality(saveTo))
 		if err != nil {
 			return fmt.Errorf("while converting temporary processed GIF file to webp: %w", err)
 		}
@@ -161,7 +193,10 @@ func runWithStdoutStdin(command string, stdin io.Reader, stdout io.Writer, args
 	return nil
 }
 
-func convertGifToWebp(source string, destination string) error {
+func convertGifToWebp(source string, destination string, quality int) error {
+	if quality > 0 {
+		return run("gif2webp", "-quiet", "-lossy", "-q", fmt.Sprint(quality), source, "-o", destination)
+	}
 	return run("gif2webp", "-quiet", source, "-o", destination)
 }
 
@@ -203,16 +238,180 @@ func run(command string, args ...string) error {
 //	<block id>            the media’s id
 //	<size>                the current thumbnail size
 //	<extension>           the media’s extension
+//	<format>              the thumbnail’s format
 //	<lang>                the current language.
-func (ctx *RunContext) ComputeOutputThumbnailFilename(media Media, blockID string, projectID string, targetSize int, lang string) FilePathInsideMediaRoot {
-	computed := ctx.Config.MakeThumbnails.FileNameTemplate
+//
+// When format is not empty and the template has no <format> placeholder, the format replaces the template’s extension.
+func (ctx *RunContext) ComputeOutputThumbnailFilename(media Media, blockID string, projectID string, targetSize int, format string, lang string) FilePathInsideMediaRoot {
+	return ctx.computeOutputFilename(ctx.Config.MakeThumbnails.FileNameTemplate, media, blockID, projectID, targetSize, format, lang)
+}
+
+// computeOutputFilename replaces placeholders in a file name template, see ComputeOutputThumbnailFilename.
+func (ctx *RunContext) computeOutputFilename(template string, media Media, blockID string, projectID string, targetSize int, format string, lang string) FilePathInsideMediaRoot {
+	computed := template
 	computed = strings.ReplaceAll(computed, "<project id>", projectID)
 	computed = strings.ReplaceAll(computed, "<work id>", projectID)
-	computed = strings.ReplaceAll(computed, "<basename>", path.Base(media.DistSource.Absolute(ctx)))
+	computed = strings.ReplaceAll(computed, "<basename>", path.Base(ctx.mediaFile(media)))
 	computed = strings.ReplaceAll(computed, "<block id>", blockID)
 	computed = strings.ReplaceAll(computed, "<size>", fmt.Sprint(targetSize))
-	computed = strings.ReplaceAll(computed, "<extension>", strings.Replace(filepath.Ext(media.DistSource.Absolute(ctx)), ".", "", 1))
+	computed = strings.ReplaceAll(computed, "<extension>", strings.Replace(filepath.Ext(ctx.mediaFile(media)), ".", "", 1))
 	computed = strings.ReplaceAll(computed, "<lang>", lang)
 	computed = strings.ReplaceAll(computed, "<media directory>", ctx.Config.Media.At)
+	if strings.Contains(computed, "<format>") {
+		computed = strings.ReplaceAll(computed, "<format>", format)
+	} else if format != "" {
+		computed = strings.TrimSuffix(computed, filepath.Ext(computed)) + "." + format
+	}
 	return FilePathInsideMediaRoot(computed)
 }
+
+// ThumbnailFormats returns the formats to make thumbnails in.
+// When none are configured, the file name template alone decides of the format, and the returned slice only has the empty string (or "webp" if the template has a <format> placeholder).
+func (ctx *RunContext) ThumbnailFormats() []string {
+	if len(ctx.Config.MakeThumbnails.Formats) > 0 {
+		return ctx.Config.MakeThumbnails.Formats
+	}
+	if strings.Contains(ctx.Config.MakeThumbnails.FileNameTemplate, "<format>") {
+		return []string{"webp"}
+	}
+	return []string{""}
+}
+
+// thumbnailQuality returns the quality configured for the format of the thumbnail at saveTo, or 0 if none is configured.
+func (ctx *RunContext) thumbnailQuality(saveTo string) int {
+	format := thumbnailFormatOf(saveTo)
+	for configured, quality := range ctx.Config.MakeThumbnails.Quality {
+		configured = normalizeThumbnailFormat(configured)
+		if configured == format || (isJPEGFormat(configured) && isJPEGFormat(format)) {
+			return quality
+		}
+	}
+	return 0
+}
+
+// normalizeThumbnailFormat returns format as a lowercase file extension, without the leading dot.
+func normalizeThumbnailFormat(format string) string {
+	return strings.ToLower(strings.TrimPrefix(format, "."))
+}
+
+// thumbnailFormatOf returns the format of the thumbnail file at path, from its extension.
+func thumbnailFormatOf(path string) string {
+	return normalizeThumbnailFormat(filepath.Ext(path))
+}
+
+func isJPEGFormat(format string) bool {
+	return format == "jpg" || format == "jpeg"
+}
+
+// ThumbnailSourcesMap maps thumbnail sizes to the thumbnail files of that size, keyed by format (their extension, such as "avif" or "webp").
+type ThumbnailSourcesMap map[int]map[string]FilePathInsideMediaRoot
+
+func (sources ThumbnailSourcesMap) set(size int, format string, path FilePathInsideMediaRoot) {
+	if sources[size] == nil {
+		sources[size] = make(map[string]FilePathInsideMediaRoot)
+	}
+	sources[size][format] = path
+}
+
+// Sizes returns the sizes of the thumbnails, smallest first.
+func (sources ThumbnailSourcesMap) Sizes() []int {
+	sizes := make([]int, 0, len(sources))
+	for size := range sources {
+		sizes = append(sizes, size)
+	}
+	sort.Ints(sizes)
+	return sizes
+}
+
+// Formats returns the formats thumbnails are available in, sorted alphabetically.
+func (sources ThumbnailSourcesMap) Formats() []string {
+	formats := make([]string, 0)
+	for _, files := range sources {
+		for format := range files {
+			if !slices.Contains(formats, format) {
+				formats = append(formats, format)
+			}
+		}
+	}
+	sort.Strings(formats)
+	return formats
+}
+
+// In returns the thumbnails in the given format.
+func (sources ThumbnailSourcesMap) In(format string) ThumbnailsMap {
+	thumbnails := make(ThumbnailsMap)
+	format = normalizeThumbnailFormat(format)
+	for size, files := range sources {
+		if file, ok := files[format]; ok {
+			thumbnails[size] = file
+		}
+	}
+	return thumbnails
+}
+
+// Closest returns the thumbnail to display at size (CSS) pixels on a screen with the given device pixel ratio, and its format.
+// The thumbnail is in the first of formats it exists in, or in any format if formats is empty.
+// The largest thumbnail that is not larger than size × pixelRatio is picked, or the smallest one if they are all larger.
+func (sources ThumbnailSourcesMap) Closest(size int, pixelRatio float64, formats ...string) (FilePathInsideMediaRoot, string) {
+	if pixelRatio <= 0 {
+		pixelRatio = 1
+	}
+	target := int(math.Ceil(float64(size) * pixelRatio))
+	if len(formats) == 0 {
+		formats = sources.Formats()
+	}
+	for _, format := range formats {
+		thumbnails := sources.In(format)
+		if len(thumbnails) == 0 {
+			continue
+		}
+		closest, smallest := 0, 0
+		for thumbnailSize := range thumbnails {
+			if thumbnailSize > closest && thumbnailSize <= target {
+				closest = thumbnailSize
+			}
+			if smallest == 0 || thumbnailSize < smallest {
+				smallest = thumbnailSize
+			}
+		}
+		if closest == 0 {
+			closest = smallest
+		}
+		return thumbnails[closest], normalizeThumbnailFormat(format)
+	}
+	return "", ""
+}
+
+// ThumbnailWidth returns the width in pixels of the media's thumbnail of the given size. Thumbnails fit in a size×size square, and are never larger than the media itself.
+func (m Media) ThumbnailWidth(size int) int {
+	width, height := m.Dimensions.Width, m.Dimensions.Height
+	if width == 0 || height == 0 {
+		return size
+	}
+	if width <= size && height <= size {
+		return width
+	}
+	if width >= height {
+		return size
+	}
+	return int(math.Round(float64(width) * float64(size) / float64(height)))
+}
+
+// SrcSet returns the value of a srcset attribute listing the media's thumbnails in the given format, with their widths.
+// URLs are made by joining baseURL (the URL the media directory is served at) with the thumbnails' paths.
+func (m Media) SrcSet(format string, baseURL string) string {
+	thumbnails := m.ThumbnailSources.In(format)
+	candidates := make([]string, 0, len(thumbnails))
+	for _, size := range m.ThumbnailSources.Sizes() {
+		file, ok := thumbnails[size]
+		if !ok {
+			continue
+		}
+		url := filepath.ToSlash(string(file))
+		if baseURL != "" {
+			url = strings.TrimSuffix(baseURL, "/") + "/" + strings.TrimPrefix(url, "/")
+		}
+		candidates = append(candidates, fmt.Sprintf("%s %dw", url, m.ThumbnailWidth(size)))
+	}
+	return strings.Join(candidates, ", ")
+}
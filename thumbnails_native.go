@@ -0,0 +1,249 @@
+package ortfodb
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/disintegration/imaging"
+	ll "github.com/ewen-lbh/label-logger-go"
+)
+
+const (
+	// ThumbnailBackendNative makes thumbnails in-process, without any external tool.
+	ThumbnailBackendNative = "native"
+	// ThumbnailBackendExternal makes thumbnails with external tools (ImageMagick, resvg, gifsicle, ffmpegthumbnailer, pdftoppm).
+	ThumbnailBackendExternal = "external"
+)
+
+// NativeThumbnailContentTypes are the content types of media that the native thumbnail backend can make thumbnails of.
+var NativeThumbnailContentTypes = []string{"image/jpeg", "image/png", "image/gif", "image/webp", "image/bmp", "image/tiff"}
+
+// NativeThumbnailExtensions are the file extensions of thumbnails that the native thumbnail backend can write.
+var NativeThumbnailExtensions = []string{".jpg", ".jpeg", ".png", ".gif", ".webp"}
+
+// nativeThumbnailJPEGQuality is the quality of JPEG thumbnails made by the native backend, when make thumbnails.quality does not set one.
+const nativeThumbnailJPEGQuality = 85
+
+// thumbnailBackend returns the backend to use to make a thumbnail of media to saveTo.
+// The backend configured for the media's content type is used, the native backend being the default.
+// If the native backend does not support the media's content type or the thumbnail's format, the external backend is used.
+func (ctx *RunContext) thumbnailBackend(media Media, saveTo string) (string, error) {
+	configured, pattern := "", ""
+	backends := ctx.Config.MakeThumbnails.Backends
+	if backend, ok := backends[media.ContentType]; ok {
+		configured, pattern = backend, media.ContentType
+	} else {
+		// Try more specific patterns first
+		patterns := mapKeys(backends)
+		sort.Slice(patterns, func(i, j int) bool {
+			if len(patterns[i]) != len(patterns[j]) {
+				return len(patterns[i]) > len(patterns[j])
+			}
+			return patterns[i] < patterns[j]
+		})
+		for _, candidate := range patterns {
+			if match, _ := filepath.Match(candidate, media.ContentType); match {
+				configured, pattern = backends[candidate], candidate
+				break
+			}
+		}
+	}
+
+	switch configured {
+	case ThumbnailBackendExternal:
+		return ThumbnailBackendExternal, nil
+	case "", ThumbnailBackendNative:
+		if nativeThumbnailSupported(media.ContentType, saveTo) {
+			return ThumbnailBackendNative, nil
+		}
+		if configured == ThumbnailBackendNative {
+			ll.Debug("Native thumbnail backend does not support making %s thumbnails of %s media, falling back to external tools", filepath.Ext(saveTo), media.ContentType)
+		}
+		return ThumbnailBackendExternal, nil
+	default:
+		return "", fmt.Errorf("unknown thumbnail backend %q for %q in make thumbnails.backends, must be %q or %q", configured, pattern, ThumbnailBackendNative, ThumbnailBackendExternal)
+	}
+}
+
+func nativeThumbnailSupported(contentType string, saveTo string) bool {
+	return slices.Contains(NativeThumbnailContentTypes, contentType) && slices.Contains(NativeThumbnailExtensions, strings.ToLower(filepath.Ext(saveTo)))
+}
+
+// makeNativeThumbnail resizes the media with a Lanczos filter, respecting its EXIF orientation, and encodes it according to the extension of saveTo.
+func (ctx *RunContext) makeNativeThumbnail(media Media, targetSize int, saveTo string) error {
+	if media.ContentType == "image/gif" {
+		return ctx.makeNativeGifThumbnail(media, targetSize, saveTo)
+	}
+
+	source, err := imaging.Open(ctx.mediaFile(media), imaging.AutoOrientation(true))
+	if err != nil {
+		return fmt.Errorf("while decoding %s: %w", ctx.mediaFile(media), err)
+	}
+	return writeThumbnailImage(imaging.Fit(source, targetSize, targetSize, imaging.Lanczos), saveTo, ctx.thumbnailQuality(saveTo))
+}
+
+// makeNativeGifThumbnail resizes every frame of the GIF. Animated thumbnails are made for GIF and WebP outputs, other formats get the first frame only.
+func (ctx *RunContext) makeNativeGifThumbnail(media Media, targetSize int, saveTo string) error {
+	source, err := os.Open(ctx.mediaFile(media))
+	if err != nil {
+		return fmt.Errorf("while opening source media: %w", err)
+	}
+	defer source.Close()
+
+	animation, err := gif.DecodeAll(source)
+	if err != nil {
+		return fmt.Errorf("while decoding %s: %w", ctx.mediaFile(media), err)
+	}
+
+	frames := composeGifFrames(animation)
+	for i, frame := range frames {
+		frames[i] = imaging.Fit(frame, targetSize, targetSize, imaging.Lanczos)
+	}
+
+	extension := strings.ToLower(filepath.Ext(saveTo))
+	if len(frames) == 1 || (extension != ".gif" && extension != ".webp") {
+		return writeThumbnailImage(frames[0], saveTo, ctx.thumbnailQuality(saveTo))
+	}
+
+	palettes := make([]color.Palette, len(animation.Image))
+	for i, frame := range animation.Image {
+		palettes[i] = gifFramePalette(frame)
+	}
+	return writeAnimation(saveTo, frames, animation.Delay, animation.LoopCount, palettes)
+}
+
+// writeAnimation encodes frames to saveTo as an animated GIF or WebP image, depending on its extension.
+// Like in GIF files, delays are in hundredths of a second, and a loopCount of 0 loops forever.
+// palettes are the colors of each frame in GIF files, frames without one use palette.Plan9.
+func writeAnimation(saveTo string, frames []image.Image, delays []int, loopCount int, palettes []color.Palette) error {
+	return writeThumbnailFile(saveTo, func(file *os.File) error {
+		switch strings.ToLower(filepath.Ext(saveTo)) {
+		case ".webp":
+			milliseconds := make([]int, len(delays))
+			for i, delay := range delays {
+				milliseconds[i] = delay * 10
+			}
+			return EncodeAnimatedWebP(file, frames, milliseconds, webpLoopCount(loopCount))
+		case ".gif":
+			animation := &gif.GIF{LoopCount: loopCount, Delay: delays}
+			for i, frame := range frames {
+				framePalette := color.Palette(palette.Plan9)
+				if i < len(palettes) && palettes[i] != nil {
+					framePalette = palettes[i]
+				}
+				paletted := image.NewPaletted(frame.Bounds(), framePalette)
+				draw.FloydSteinberg.Draw(paletted, frame.Bounds(), frame, image.Point{})
+				animation.Image = append(animation.Image, paletted)
+				// Frames are whole, composited images: clear the previous one so that its pixels don't show through transparent ones
+				animation.Disposal = append(animation.Disposal, gif.DisposalBackground)
+			}
+			return gif.EncodeAll(file, animation)
+		}
+		return fmt.Errorf("unsupported animation format %s", filepath.Ext(saveTo))
+	})
+}
+
+// composeGifFrames returns the frames of the animation as they are displayed, by drawing each frame over the previous ones according to their disposal method.
+func composeGifFrames(animation *gif.GIF) []image.Image {
+	canvas := image.NewNRGBA(image.Rect(0, 0, animation.Config.Width, animation.Config.Height))
+	frames := make([]image.Image, 0, len(animation.Image))
+	for i, frame := range animation.Image {
+		var disposal byte
+		if i < len(animation.Disposal) {
+			disposal = animation.Disposal[i]
+		}
+
+		var previous *image.NRGBA
+		if disposal == gif.DisposalPrevious {
+			previous = imaging.Clone(canvas)
+		}
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		frames = append(frames, imaging.Clone(canvas))
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+	return frames
+}
+
+// gifFramePalette returns the palette of the frame, with a transparent color added if it has none and there's room for it.
+func gifFramePalette(frame *image.Paletted) color.Palette {
+	palette := slices.Clone(frame.Palette)
+	for _, c := range palette {
+		if _, _, _, alpha := c.RGBA(); alpha == 0 {
+			return palette
+		}
+	}
+	if len(palette) < 256 {
+		palette = append(palette, color.Transparent)
+	}
+	return palette
+}
+
+// webpLoopCount converts a GIF loop count (0 for infinite, -1 for no looping, n to loop n times after the first play) to a WebP one (0 for infinite, n for n plays in total).
+func webpLoopCount(gifLoopCount int) int {
+	switch {
+	case gifLoopCount == 0:
+		return 0
+	case gifLoopCount < 0:
+		return 1
+	default:
+		return gifLoopCount + 1
+	}
+}
+
+// writeThumbnailImage encodes img to saveTo, in the format corresponding to its extension.
+// quality only applies to JPEG thumbnails (WebP ones are lossless), 0 meaning the default quality.
+func writeThumbnailImage(img image.Image, saveTo string, quality int) error {
+	if quality == 0 {
+		quality = nativeThumbnailJPEGQuality
+	}
+	return writeThumbnailFile(saveTo, func(file *os.File) error {
+		switch strings.ToLower(filepath.Ext(saveTo)) {
+		case ".jpg", ".jpeg":
+			// JPEG has no transparency, so transparent pixels are made white instead of black
+			bounds := img.Bounds()
+			flattened := imaging.Overlay(imaging.New(bounds.Dx(), bounds.Dy(), color.White), img, image.Point{}, 1)
+			return jpeg.Encode(file, flattened, &jpeg.Options{Quality: quality})
+		case ".png":
+			return png.Encode(file, img)
+		case ".gif":
+			return gif.Encode(file, img, nil)
+		case ".webp":
+			return EncodeWebP(file, img)
+		}
+		return fmt.Errorf("unsupported thumbnail format %s", filepath.Ext(saveTo))
+	})
+}
+
+// writeThumbnailFile creates saveTo and calls encode with it. The file is removed if encode fails, so that no half-written thumbnail is left behind.
+func writeThumbnailFile(saveTo string, encode func(file *os.File) error) error {
+	file, err := os.Create(saveTo)
+	if err != nil {
+		return fmt.Errorf("while creating thumbnail file: %w", err)
+	}
+	err = encode(file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(saveTo)
+		return fmt.Errorf("while writing thumbnail to %s: %w", saveTo, err)
+	}
+	return nil
+}
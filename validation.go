@@ -4,11 +4,16 @@ import (
 	"encoding/json"
 
 	ll "github.com/ewen-lbh/label-logger-go"
+	"github.com/invopop/jsonschema"
 	"github.com/xeipuuv/gojsonschema"
 )
 
 func ValidateAsJSONSchema(typ any, yaml bool, values any) []gojsonschema.ResultError {
-	schema := makeJSONSchema(typ, true)
+	return ValidateAgainstJSONSchema(makeJSONSchema(typ, true), values)
+}
+
+// ValidateAgainstJSONSchema validates values against the given JSON schema.
+func ValidateAgainstJSONSchema(schema *jsonschema.Schema, values any) []gojsonschema.ResultError {
 	jsonOpts, _ := json.Marshal(values)
 	if jsonOpts == nil {
 		return []gojsonschema.ResultError{}
@@ -0,0 +1,301 @@
+package ortfodb
+
+import (
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	ll "github.com/ewen-lbh/label-logger-go"
+	"github.com/fsnotify/fsnotify"
+)
+
+// WatchDebounceDelay is the time to wait after the last filesystem event before rebuilding affected works.
+// Editors tend to write files in multiple steps (truncate, write, rename…), this prevents rebuilding the same work several times.
+var WatchDebounceDelay = 300 * time.Millisecond
+
+// watchedDirectoriesToSkip are directory names that are never watched, since they are both huge and never part of a portfolio.
+var watchedDirectoriesToSkip = []string{".git", "node_modules", ".venv"}
+
+// Watch watches the projects directory (and the directories of media files referenced by works) and rebuilds works as their description files or media change.
+// works is the database that was built before starting to watch, it is updated in-place and written to outputFilename after every rebuild.
+// Only works matching the include pattern (see BuildSome) are rebuilt.
+// The build lock is expected to be held by the caller, and is not released by Watch. Exporters are closed (see CloseExporters) when Watch returns.
+// Watch blocks until stop is closed or the watcher fails.
+func (ctx *RunContext) Watch(works Database, include string, outputFilename string, flags Flags, stop <-chan struct{}) error {
+	defer ctx.CloseExporters()
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("while creating filesystem watcher: %w", err)
+	}
+	defer watcher.Close()
+
+	if err := ctx.addWatches(watcher, works); err != nil {
+		return err
+	}
+
+	ll.Log("Watching", "cyan", "for changes in %s", ctx.DatabaseDirectory)
+
+	pending := make(map[string]bool)
+	debounce := time.NewTimer(WatchDebounceDelay)
+	debounce.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return nil
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			ll.WarnDisplay("filesystem watcher reported an error", err)
+
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if ctx.ignoredByWatcher(event.Name, outputFilename) {
+				continue
+			}
+			ll.Debug("watch: got event %s", event)
+
+			// Newly created directories need to be watched too (new works, new media folders, scattered mode folders…)
+			if event.Has(fsnotify.Create) {
+				if stat, err := os.Stat(event.Name); err == nil && stat.IsDir() {
+					if err := ctx.watchRecursively(watcher, event.Name); err != nil {
+						ll.WarnDisplay("could not watch new directory %s", err, event.Name)
+					}
+				}
+			}
+
+			for _, workID := range ctx.worksAffectedBy(event.Name, works) {
+				included, err := matchesIncludePattern(include, workID)
+				if err != nil {
+					return err
+				}
+				if included {
+					pending[workID] = true
+				}
+			}
+
+			if len(pending) > 0 {
+				debounce.Reset(WatchDebounceDelay)
+			}
+
+		case <-debounce.C:
+			workIDs := mapKeys(pending)
+			pending = make(map[string]bool)
+			ctx.RebuildWorks(works, workIDs, outputFilename, flags)
+			// Media referenced by the rebuilt works might live in directories we don't watch yet.
+			if err := ctx.addWatches(watcher, works); err != nil {
+				ll.WarnDisplay("could not watch media directories", err)
+			}
+		}
+	}
+}
+
+// RebuildWorks rebuilds the given works, runs exporters on them, writes the updated database to outputFilename and runs the exporters' after hooks with the changes.
+// Works whose description file has been removed are removed from the database.
+// Errors are displayed but do not stop the rebuild of the other works.
+func (ctx *RunContext) RebuildWorks(works Database, workIDs []string, outputFilename string, flags Flags) {
+	ctx.previousBuiltDatabase.mu.Lock()
+	previous := maps.Clone(works)
+	ctx.previousBuiltDatabase.mu.Unlock()
+
+	ctx.StartProgressBar(len(workIDs))
+	for _, workID := range workIDs {
+		descriptionFilename := ctx.DescriptionFilename(ctx.DatabaseDirectory, workID)
+		descriptionRaw, err := os.ReadFile(descriptionFilename)
+		if os.IsNotExist(err) {
+			ll.Log("Removed", "yellow", "%s", workID)
+			ctx.previousBuiltDatabase.mu.Lock()
+			delete(works, workID)
+			ctx.previousBuiltDatabase.mu.Unlock()
+			ctx.IncrementProgress()
+			continue
+		} else if err != nil {
+			ll.ErrorDisplay("while reading description file %s", err, descriptionFilename)
+			ctx.IncrementProgress()
+			continue
+		}
+
+		ctx.Status(workID, PhaseBuilding)
+		newWork, usedCache, err := ctx.Build(string(descriptionRaw), outputFilename, workID)
+		if err != nil {
+			ll.ErrorDisplay("while building %s", err, workID)
+			ctx.IncrementProgress()
+			continue
+		}
+
+		if err := ctx.RunExporters(&newWork); err != nil {
+			ll.ErrorDisplay("while running exporters on %s", err, workID)
+		}
+
+		ctx.previousBuiltDatabase.mu.Lock()
+		works[workID] = newWork
+		ctx.previousBuiltDatabase.mu.Unlock()
+
+		if usedCache {
+			ctx.Status(workID, PhaseUnchanged)
+		} else {
+			ctx.Status(workID, PhaseBuilt)
+		}
+	}
+
+	ctx.WriteDatabase(works, flags, outputFilename, false)
+	ctx.RunAfterHooks(previous, works)
+}
+
+// worksAffectedBy returns the IDs of the works that need to be rebuilt when the file at path changes.
+func (ctx *RunContext) worksAffectedBy(path string, works Database) []string {
+	affected := make([]string, 0)
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return affected
+	}
+
+	databaseDirectory, _ := filepath.Abs(ctx.DatabaseDirectory)
+	if relativePath, err := filepath.Rel(databaseDirectory, absPath); err == nil && relativePath != "." && !strings.HasPrefix(relativePath, "..") {
+		workID := strings.Split(relativePath, string(os.PathSeparator))[0]
+		if _, built := works[workID]; built || fileExists(ctx.DescriptionFilename(ctx.DatabaseDirectory, workID)) {
+			affected = append(affected, workID)
+		}
+	}
+
+	// Media files can live outside of the work's folder
+	for workID, work := range works {
+		for _, source := range ctx.referencedMediaFiles(workID, work) {
+			if absPath == source || strings.HasPrefix(absPath, source+string(os.PathSeparator)) {
+				affected = append(affected, workID)
+			}
+		}
+	}
+
+	return noDuplicates(affected)
+}
+
+// referencedMediaFiles returns the absolute paths to all media files referenced by the given work, in all languages.
+func (ctx *RunContext) referencedMediaFiles(workID string, work Work) []string {
+	files := make([]string, 0)
+	for _, content := range work.Content {
+		for _, block := range content.Blocks {
+			for _, media := range block.Mediae() {
+				if media.Online {
+					continue
+				}
+				source := string(media.RelativeSource)
+				if !filepath.IsAbs(source) {
+					source, _ = filepath.Abs(filepath.Join(ctx.PathToWorkFolder(workID), source))
+				}
+				files = append(files, source)
+			}
+		}
+	}
+	return noDuplicates(files)
+}
+
+// ignoredByWatcher returns true if the given path is written to by ortfodb itself, and should thus not trigger rebuilds.
+func (ctx *RunContext) ignoredByWatcher(path string, outputFilename string) bool {
+	absPath, _ := filepath.Abs(path)
+	basename := filepath.Base(path)
+
+	// Editor swap & backup files
+	if strings.HasSuffix(basename, "~") || strings.HasSuffix(basename, ".swp") || strings.HasPrefix(basename, ".#") {
+		return true
+	}
+
+	for _, ownFile := range []string{outputFilename, BuildLockFilepath(outputFilename), ctx.ProgressInfoFile} {
+		if ownFile == "" {
+			continue
+		}
+		if absOwnFile, _ := filepath.Abs(ownFile); absOwnFile == absPath {
+			return true
+		}
+	}
+
+	if ctx.Config.Media.At == "" {
+		return false
+	}
+	mediaDirectory, _ := filepath.Abs(ctx.Config.Media.At)
+	return absPath == mediaDirectory || strings.HasPrefix(absPath, mediaDirectory+string(os.PathSeparator))
+}
+
+// addWatches watches the projects directory, every work folder (recursively) and the directories containing media files referenced by works.
+// Directories already watched are skipped.
+func (ctx *RunContext) addWatches(watcher *fsnotify.Watcher, works Database) error {
+	if err := ctx.watch(watcher, ctx.DatabaseDirectory); err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(ctx.DatabaseDirectory)
+	if err != nil {
+		return fmt.Errorf("while listing projects directory: %w", err)
+	}
+	for _, entry := range entries {
+		path := filepath.Join(ctx.DatabaseDirectory, entry.Name())
+		if stat, err := os.Stat(path); err != nil || !stat.IsDir() || stringInSlice(watchedDirectoriesToSkip, entry.Name()) {
+			continue
+		}
+		// In scattered mode, watch the project folder itself to notice the creation of the scattered mode folder.
+		if err := ctx.watch(watcher, path); err != nil {
+			return err
+		}
+		if err := ctx.watchRecursively(watcher, ctx.PathToWorkFolder(entry.Name())); err != nil {
+			return err
+		}
+	}
+
+	for workID, work := range works {
+		for _, source := range ctx.referencedMediaFiles(workID, work) {
+			directory := source
+			if stat, err := os.Stat(source); err != nil || !stat.IsDir() {
+				directory = filepath.Dir(source)
+			}
+			if err := ctx.watch(watcher, directory); err != nil {
+				ll.Debug("watch: could not watch media directory %s: %s", directory, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// watchRecursively watches root and all of its subdirectories. root not existing is not an error.
+func (ctx *RunContext) watchRecursively(watcher *fsnotify.Watcher, root string) error {
+	if !fileExists(root) {
+		return nil
+	}
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if path != root && stringInSlice(watchedDirectoriesToSkip, d.Name()) {
+			return fs.SkipDir
+		}
+		if ctx.ignoredByWatcher(path, ctx.OutputDatabaseFile) {
+			return fs.SkipDir
+		}
+		return ctx.watch(watcher, path)
+	})
+}
+
+func (ctx *RunContext) watch(watcher *fsnotify.Watcher, directory string) error {
+	for _, watched := range watcher.WatchList() {
+		if watched == directory {
+			return nil
+		}
+	}
+	ll.Debug("watch: watching %s", directory)
+	if err := watcher.Add(directory); err != nil {
+		return fmt.Errorf("while watching %s: %w", directory, err)
+	}
+	return nil
+}
@@ -0,0 +1,245 @@
+package ortfodb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	ll "github.com/ewen-lbh/label-logger-go"
+)
+
+// DefaultWaveformPeaks is the number of peaks of waveforms, when make waveforms.peaks is not set in the configuration.
+const DefaultWaveformPeaks = 200
+
+// waveformSampleRate is the sample rate audio is decoded at to compute waveforms with ffmpeg: peaks don't need more precision.
+const waveformSampleRate = 8000
+
+type MakeWaveformsConfiguration struct {
+	Enabled bool
+	// Number of peaks of waveforms. Defaults to 200.
+	Peaks int `yaml:"peaks,omitempty"`
+	// Where to save SVG images of waveforms, relative to the media directory, with the same placeholders as make thumbnails.file name template. No images are made if this is not set.
+	FileNameTemplate string `yaml:"file name template,omitempty"`
+}
+
+func (ctx *RunContext) waveformPeaksCount() int {
+	if ctx.Config.MakeWaveforms.Peaks > 0 {
+		return ctx.Config.MakeWaveforms.Peaks
+	}
+	return DefaultWaveformPeaks
+}
+
+// needsWaveform returns true if the waveform of the audio media should be computed: it has none, or it has a different number of peaks than configured.
+func (ctx *RunContext) needsWaveform(media Media) bool {
+	return ctx.Config.MakeWaveforms.Enabled && strings.HasPrefix(media.ContentType, "audio/") && len(media.Waveform) != ctx.waveformPeaksCount()
+}
+
+// MakeWaveform decodes the audio file at filename, and returns count peaks: the maximum amplitude (from 0 to 1) of each of the count parts of equal duration of the audio.
+// WAV files with PCM samples are decoded natively, other formats are decoded with ffmpeg.
+func MakeWaveform(filename string, count int) ([]float64, error) {
+	defer ll.TimeTrack(time.Now(), "MakeWaveform", filename)
+	peaks, err := wavWaveformPeaks(filename, count)
+	if err == nil {
+		return peaks, nil
+	}
+	ll.Debug("Decoding %s with ffmpeg to compute its waveform: %s", filename, err)
+	samples, err := decodeAudioSamples(filename)
+	if err != nil {
+		return nil, fmt.Errorf("while decoding %s: %w", filename, err)
+	}
+	if len(samples) == 0 {
+		return nil, fmt.Errorf("%s has no audio samples", filename)
+	}
+	return waveformPeaks(samples, count), nil
+}
+
+// waveformPeaks returns the maximum absolute value of samples in each of the count parts of samples, rounded to 3 decimals.
+func waveformPeaks(samples []float64, count int) []float64 {
+	accumulator := newPeaksAccumulator(count, int64(len(samples)))
+	for _, sample := range samples {
+		accumulator.add(sample)
+	}
+	return accumulator.peaks()
+}
+
+// peaksAccumulator computes the peaks of a waveform from its samples, given one after the other, so that they don't have to be kept in memory.
+type peaksAccumulator struct {
+	maximums     []float64
+	samplesCount int64
+	index        int64
+	part         int
+}
+
+// newPeaksAccumulator returns an accumulator for count peaks of samplesCount samples.
+func newPeaksAccumulator(count int, samplesCount int64) *peaksAccumulator {
+	return &peaksAccumulator{maximums: make([]float64, count), samplesCount: samplesCount}
+}
+
+func (a *peaksAccumulator) add(sample float64) {
+	// Part i has samples from i*samplesCount/count (included) to (i+1)*samplesCount/count (excluded)
+	for a.part < len(a.maximums)-1 && a.index >= int64(a.part+1)*a.samplesCount/int64(len(a.maximums)) {
+		a.part++
+	}
+	a.maximums[a.part] = math.Max(a.maximums[a.part], math.Abs(sample))
+	a.index++
+}
+
+// peaks returns the maximum absolute value of the samples of each part, rounded to 3 decimals.
+func (a *peaksAccumulator) peaks() []float64 {
+	peaks := make([]float64, len(a.maximums))
+	for i, maximum := range a.maximums {
+		peaks[i] = math.Round(math.Min(1, maximum)*1000) / 1000
+	}
+	return peaks
+}
+
+// decodeAudioSamples decodes the audio file with ffmpeg to mono samples, from -1 to 1.
+func decodeAudioSamples(filename string) ([]float64, error) {
+	var output bytes.Buffer
+	err := runWithStdoutStdin("ffmpeg", nil, &output,
+		"-v", "error", "-nostdin",
+		"-i", filename,
+		"-vn", "-ac", "1", "-ar", fmt.Sprint(waveformSampleRate),
+		"-f", "s16le", "-",
+	)
+	if err != nil {
+		return nil, err
+	}
+	raw := output.Bytes()
+	samples := make([]float64, len(raw)/2)
+	for i := range samples {
+		samples[i] = float64(int16(binary.LittleEndian.Uint16(raw[2*i:]))) / 32768
+	}
+	return samples, nil
+}
+
+// wavWaveformPeaks computes count peaks of WAV files with integer (8, 16, 24 or 32 bits) or floating-point (32 bits) PCM samples, averaging channels to get mono samples from -1 to 1.
+// Samples are read in chunks, so that long recordings are not loaded in memory entirely.
+func wavWaveformPeaks(filename string, count int) ([]float64, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(file, header); err != nil || audioContainer(header) != "wav" {
+		return nil, fmt.Errorf("not a WAV file")
+	}
+	format, dataOffset, dataSize, err := readWAVChunks(file, func(string, []byte) {})
+	if err != nil {
+		return nil, err
+	}
+	// 0xFFFE is WAVE_FORMAT_EXTENSIBLE, which is PCM in practice
+	floating := format.encoding == 3
+	if format.encoding != 1 && format.encoding != 0xFFFE && !(floating && format.bitsPerSample == 32) {
+		return nil, fmt.Errorf("unsupported WAV encoding %#x", format.encoding)
+	}
+	bytesPerSample := int(format.bitsPerSample) / 8
+	if format.channels == 0 || bytesPerSample < 1 || bytesPerSample > 4 || format.bitsPerSample%8 != 0 {
+		return nil, fmt.Errorf("unsupported WAV format: %d channels of %d bits", format.channels, format.bitsPerSample)
+	}
+
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	// The size of the data chunk can't be trusted: it is bogus in files that were cut, or that were written while recording
+	dataSize = min(dataSize, max(0, stat.Size()-dataOffset))
+	frameSize := bytesPerSample * int(format.channels)
+	framesCount := dataSize / int64(frameSize)
+	if framesCount == 0 {
+		return nil, fmt.Errorf("%s has no audio samples", filename)
+	}
+	if _, err := file.Seek(dataOffset, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	accumulator := newPeaksAccumulator(count, framesCount)
+	chunk := make([]byte, 4096*frameSize)
+	for remaining := framesCount; remaining > 0; {
+		data := chunk[:min(int64(len(chunk)), remaining*int64(frameSize))]
+		if _, err := io.ReadFull(file, data); err != nil {
+			return nil, fmt.Errorf("while reading samples: %w", err)
+		}
+		for frame := 0; frame < len(data); frame += frameSize {
+			sum := 0.0
+			for channel := 0; channel < int(format.channels); channel++ {
+				raw := data[frame+channel*bytesPerSample:]
+				switch {
+				case floating:
+					sum += float64(math.Float32frombits(binary.LittleEndian.Uint32(raw)))
+				case bytesPerSample == 1:
+					// 8-bit samples are unsigned
+					sum += (float64(raw[0]) - 128) / 128
+				case bytesPerSample == 2:
+					sum += float64(int16(binary.LittleEndian.Uint16(raw))) / (1 << 15)
+				case bytesPerSample == 3:
+					sum += float64(int32(uint32(raw[0])<<8|uint32(raw[1])<<16|uint32(raw[2])<<24)>>8) / (1 << 23)
+				case bytesPerSample == 4:
+					sum += float64(int32(binary.LittleEndian.Uint32(raw))) / (1 << 31)
+				}
+			}
+			accumulator.add(sum / float64(format.channels))
+		}
+		remaining -= int64(len(data) / frameSize)
+	}
+	return accumulator.peaks(), nil
+}
+
+// WaveformSVG renders peaks to an SVG image of vertical bars centered on a horizontal axis, one per peak.
+// Bars are drawn with the current color, so that it can be changed with CSS when the image is inlined in a page.
+func WaveformSVG(peaks []float64) string {
+	var path strings.Builder
+	for i, peak := range peaks {
+		// Silent parts still get a small bar
+		height := math.Max(peak*100, 1)
+		fmt.Fprintf(&path, "M%d %sv%s", 2*i+1, strconv.FormatFloat(50-height/2, 'f', -1, 64), strconv.FormatFloat(height, 'f', -1, 64))
+	}
+	return fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d 100" preserveAspectRatio="none"><path d="%s" stroke="currentColor" stroke-width="1"/></svg>`, 2*len(peaks), path.String())
+}
+
+// makeAudioFiles extracts the cover art of the audio media to the media directory, and renders its waveform to an SVG image if make waveforms.file name template is set.
+func (ctx *RunContext) makeAudioFiles(media *Media, blockID string, workID string, language string, usedCache bool) error {
+	if media.Audio != nil && media.Audio.Cover != "" {
+		saveTo := media.Audio.Cover.Absolute(ctx)
+		if !usedCache || !fileExists(saveTo) {
+			os.MkdirAll(filepath.Dir(saveTo), 0o755)
+			if err := writeAudioCover(ctx.mediaFile(*media), saveTo); err != nil {
+				return fmt.Errorf("while extracting cover art: %w", err)
+			}
+			ll.Debug("Extracted cover art of %s to %s", media.RelativeSource, media.Audio.Cover)
+		}
+	}
+
+	if template := ctx.Config.MakeWaveforms.FileNameTemplate; ctx.Config.MakeWaveforms.Enabled && template != "" && len(media.Waveform) > 0 {
+		media.WaveformImage = ctx.computeOutputFilename(template, *media, blockID, workID, len(media.Waveform), "", language)
+		saveTo := media.WaveformImage.Absolute(ctx)
+		os.MkdirAll(filepath.Dir(saveTo), 0o755)
+		if err := os.WriteFile(saveTo, []byte(WaveformSVG(media.Waveform)), 0o644); err != nil {
+			return fmt.Errorf("while writing waveform image: %w", err)
+		}
+		ll.Debug("Rendered waveform of %s to %s", media.RelativeSource, media.WaveformImage)
+	}
+	return nil
+}
+
+// audioCoverPath returns where to extract the cover art of the audio media at distSource, next to it in the media directory.
+func audioCoverPath(distSource FilePathInsideMediaRoot, contentType string) FilePathInsideMediaRoot {
+	extension := strings.TrimPrefix(contentType, "image/")
+	switch extension {
+	case "jpeg", "jpg", "pjpeg":
+		extension = "jpg"
+	case "png", "gif", "webp", "bmp":
+	default:
+		return ""
+	}
+	return FilePathInsideMediaRoot(strings.TrimSuffix(string(distSource), filepath.Ext(string(distSource))) + ".cover." + extension)
+}
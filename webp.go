@@ -0,0 +1,621 @@
+package ortfodb
+
+// A lossless WebP encoder, so that thumbnails can be made without external tools.
+// It uses the subtract green and predictor transforms and backward references, but no color cache or meta prefix codes.
+// See https://developers.google.com/speed/webp/docs/webp_lossless_bitstream_specification and https://developers.google.com/speed/webp/docs/riff_container
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+	"sort"
+)
+
+const (
+	webpMaxDimension      = 1 << 14
+	webpMaxCodeLength     = 15
+	webpMaxMatchLength    = 4096
+	webpMinMatchLength    = 3
+	webpMaxDistance       = 1<<20 - 120
+	webpPredictorBits     = 5
+	webpLengthPrefixCodes = 24
+	webpDistancePrefixes  = 40
+)
+
+// codeLengthCodeOrder is the order in which the lengths of the code length code are written.
+var codeLengthCodeOrder = [19]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// Predictor modes tried for each block of the image. Only those that don't depend on the top-right pixel are used.
+var webpPredictorModes = []int{1, 2, 7, 12}
+
+// EncodeWebP writes img to w as a lossless WebP image.
+func EncodeWebP(w io.Writer, img image.Image) error {
+	// Transparency is signaled in the VP8L header itself, so no VP8X chunk is needed
+	bitstream, _, err := encodeVP8L(img)
+	if err != nil {
+		return err
+	}
+	var body bytes.Buffer
+	body.WriteString("WEBP")
+	writeRIFFChunk(&body, "VP8L", bitstream)
+	return writeRIFF(w, body.Bytes())
+}
+
+// EncodeAnimatedWebP writes frames to w as a lossless animated WebP image. Frames must all have the same size.
+// delays are in milliseconds. A loopCount of 0 means the animation loops forever.
+func EncodeAnimatedWebP(w io.Writer, frames []image.Image, delays []int, loopCount int) error {
+	if len(frames) == 0 {
+		return fmt.Errorf("animation has no frames")
+	}
+	bounds := frames[0].Bounds()
+	var body bytes.Buffer
+	body.WriteString("WEBP")
+
+	encodedFrames := make([][]byte, 0, len(frames))
+	anyAlpha := false
+	for i, frame := range frames {
+		if frame.Bounds().Dx() != bounds.Dx() || frame.Bounds().Dy() != bounds.Dy() {
+			return fmt.Errorf("frame %d is %dx%d, but the first one is %dx%d", i, frame.Bounds().Dx(), frame.Bounds().Dy(), bounds.Dx(), bounds.Dy())
+		}
+		bitstream, hasAlpha, err := encodeVP8L(frame)
+		if err != nil {
+			return fmt.Errorf("while encoding frame %d: %w", i, err)
+		}
+		anyAlpha = anyAlpha || hasAlpha
+		encodedFrames = append(encodedFrames, bitstream)
+	}
+
+	vp8x := make([]byte, 10)
+	vp8x[0] = 0x02 // animation
+	if anyAlpha {
+		vp8x[0] |= 0x10
+	}
+	putUint24(vp8x[4:], uint32(bounds.Dx()-1))
+	putUint24(vp8x[7:], uint32(bounds.Dy()-1))
+	writeRIFFChunk(&body, "VP8X", vp8x)
+
+	anim := make([]byte, 6)
+	// Background color is left transparent
+	binary.LittleEndian.PutUint16(anim[4:], uint16(loopCount))
+	writeRIFFChunk(&body, "ANIM", anim)
+
+	for i, bitstream := range encodedFrames {
+		var frame bytes.Buffer
+		header := make([]byte, 16)
+		// Frames cover the whole canvas, so their offset is 0
+		putUint24(header[6:], uint32(bounds.Dx()-1))
+		putUint24(header[9:], uint32(bounds.Dy()-1))
+		delay := 100
+		if i < len(delays) {
+			delay = delays[i]
+		}
+		putUint24(header[12:], uint32(delay))
+		header[15] = 0x02 // do not blend with the previous frame, do not dispose
+		frame.Write(header)
+		writeRIFFChunk(&frame, "VP8L", bitstream)
+		writeRIFFChunk(&body, "ANMF", frame.Bytes())
+	}
+	return writeRIFF(w, body.Bytes())
+}
+
+func writeRIFF(w io.Writer, body []byte) error {
+	header := make([]byte, 8)
+	copy(header, "RIFF")
+	binary.LittleEndian.PutUint32(header[4:], uint32(len(body)))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(body)
+	return err
+}
+
+func writeRIFFChunk(w *bytes.Buffer, fourCC string, data []byte) {
+	header := make([]byte, 8)
+	copy(header, fourCC)
+	binary.LittleEndian.PutUint32(header[4:], uint32(len(data)))
+	w.Write(header)
+	w.Write(data)
+	if len(data)%2 == 1 {
+		w.WriteByte(0)
+	}
+}
+
+func putUint24(b []byte, value uint32) {
+	b[0] = byte(value)
+	b[1] = byte(value >> 8)
+	b[2] = byte(value >> 16)
+}
+
+// encodeVP8L returns the VP8L bitstream of img, and whether it has transparent pixels.
+func encodeVP8L(img image.Image) (bitstream []byte, hasAlpha bool, err error) {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width < 1 || height < 1 || width > webpMaxDimension || height > webpMaxDimension {
+		return nil, false, fmt.Errorf("cannot encode a %dx%d image to WebP: dimensions must be between 1 and %d", width, height, webpMaxDimension)
+	}
+
+	nrgba, ok := img.(*image.NRGBA)
+	if !ok || nrgba.Bounds().Min != (image.Point{}) {
+		nrgba = image.NewNRGBA(image.Rect(0, 0, width, height))
+		draw.Draw(nrgba, nrgba.Bounds(), img, bounds.Min, draw.Src)
+	}
+
+	pixels := make([]uint32, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			i := nrgba.PixOffset(x, y)
+			r, g, b, a := nrgba.Pix[i], nrgba.Pix[i+1], nrgba.Pix[i+2], nrgba.Pix[i+3]
+			if a != 0xff {
+				hasAlpha = true
+			}
+			// Subtract green transform
+			pixels[y*width+x] = uint32(a)<<24 | uint32(r-g)<<16 | uint32(g)<<8 | uint32(b-g)
+		}
+	}
+
+	w := &bitWriter{}
+	w.writeBits(0x2f, 8)
+	w.writeBits(uint32(width-1), 14)
+	w.writeBits(uint32(height-1), 14)
+	if hasAlpha {
+		w.writeBits(1, 1)
+	} else {
+		w.writeBits(0, 1)
+	}
+	w.writeBits(0, 3) // version
+
+	// Subtract green transform (already applied)
+	w.writeBits(1, 1)
+	w.writeBits(2, 2)
+
+	// Predictor transform
+	modes, modesWidth := choosePredictorModes(pixels, width, height)
+	w.writeBits(1, 1)
+	w.writeBits(0, 2)
+	w.writeBits(webpPredictorBits-2, 3)
+	modesImage := make([]uint32, len(modes))
+	for i, mode := range modes {
+		modesImage[i] = 0xff000000 | uint32(mode)<<8
+	}
+	writeEntropyCodedImage(w, modesImage, modesWidth, false)
+	residuals := predictorResiduals(pixels, width, height, modes, modesWidth)
+
+	// No more transforms
+	w.writeBits(0, 1)
+
+	writeEntropyCodedImage(w, residuals, width, true)
+	return w.bytes(), hasAlpha, nil
+}
+
+// choosePredictorModes returns the predictor mode to use for each block of the image, picking the one with the smallest residuals.
+func choosePredictorModes(pixels []uint32, width, height int) (modes []int, modesWidth int) {
+	blockSize := 1 << webpPredictorBits
+	modesWidth = (width + blockSize - 1) / blockSize
+	modesHeight := (height + blockSize - 1) / blockSize
+	modes = make([]int, modesWidth*modesHeight)
+	for by := 0; by < modesHeight; by++ {
+		for bx := 0; bx < modesWidth; bx++ {
+			bestCost := -1
+			for _, mode := range webpPredictorModes {
+				cost := 0
+				for y := by * blockSize; y < min(height, (by+1)*blockSize); y++ {
+					for x := bx * blockSize; x < min(width, (bx+1)*blockSize); x++ {
+						residual := subtractPixels(pixels[y*width+x], predictPixel(pixels, width, x, y, mode))
+						for shift := 0; shift < 32; shift += 8 {
+							value := int(residual>>shift) & 0xff
+							cost += min(value, 256-value)
+						}
+					}
+				}
+				if bestCost == -1 || cost < bestCost {
+					bestCost = cost
+					modes[by*modesWidth+bx] = mode
+				}
+			}
+		}
+	}
+	return
+}
+
+func predictorResiduals(pixels []uint32, width, height int, modes []int, modesWidth int) []uint32 {
+	residuals := make([]uint32, len(pixels))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			mode := modes[(y>>webpPredictorBits)*modesWidth+(x>>webpPredictorBits)]
+			residuals[y*width+x] = subtractPixels(pixels[y*width+x], predictPixel(pixels, width, x, y, mode))
+		}
+	}
+	return residuals
+}
+
+// predictPixel returns the prediction of the pixel at x, y, following the rules of the predictor transform.
+func predictPixel(pixels []uint32, width, x, y, mode int) uint32 {
+	switch {
+	case x == 0 && y == 0:
+		return 0xff000000
+	case y == 0:
+		return pixels[x-1]
+	case x == 0:
+		return pixels[(y-1)*width]
+	}
+	left := pixels[y*width+x-1]
+	top := pixels[(y-1)*width+x]
+	topLeft := pixels[(y-1)*width+x-1]
+	switch mode {
+	case 1:
+		return left
+	case 2:
+		return top
+	case 7:
+		return mapChannels(func(l, t, _ int) int { return (l + t) / 2 }, left, top, 0)
+	case 12:
+		return mapChannels(func(l, t, tl int) int { return max(0, min(255, l+t-tl)) }, left, top, topLeft)
+	}
+	panic(fmt.Sprintf("unsupported predictor mode %d", mode))
+}
+
+func mapChannels(f func(a, b, c int) int, a, b, c uint32) uint32 {
+	var result uint32
+	for shift := 0; shift < 32; shift += 8 {
+		result |= uint32(f(int(a>>shift)&0xff, int(b>>shift)&0xff, int(c>>shift)&0xff)&0xff) << shift
+	}
+	return result
+}
+
+// subtractPixels subtracts each channel of b from a, modulo 256.
+func subtractPixels(a, b uint32) uint32 {
+	return mapChannels(func(a, b, _ int) int { return a - b }, a, b, 0)
+}
+
+// webpSymbol is either a literal pixel, or a backward reference if length is not 0.
+type webpSymbol struct {
+	pixel    uint32
+	length   int
+	distance int
+}
+
+// backwardReferences finds repeated sequences of pixels, trying the previous pixel, the pixel above and the last position with the same two pixels.
+func backwardReferences(pixels []uint32, width int) []webpSymbol {
+	const hashBits = 16
+	symbols := make([]webpSymbol, 0, len(pixels)/2)
+	lastSeen := make([]int, 1<<hashBits)
+	hash := func(i int) int {
+		if i+1 >= len(pixels) {
+			return int((pixels[i] * 0x1e35a7bd) >> (32 - hashBits))
+		}
+		return int(((pixels[i] * 0x1e35a7bd) ^ (pixels[i+1] * 0x9e3779b1)) >> (32 - hashBits))
+	}
+	matchLength := func(i, candidate int) int {
+		length := 0
+		for i+length < len(pixels) && length < webpMaxMatchLength && pixels[candidate+length] == pixels[i+length] {
+			length++
+		}
+		return length
+	}
+
+	for i := 0; i < len(pixels); {
+		bestLength, bestDistance := 0, 0
+		for _, candidate := range []int{i - 1, i - width, lastSeen[hash(i)] - 1} {
+			if candidate < 0 || candidate >= i || i-candidate > webpMaxDistance {
+				continue
+			}
+			if length := matchLength(i, candidate); length > bestLength {
+				bestLength, bestDistance = length, i-candidate
+			}
+		}
+		if bestLength < webpMinMatchLength {
+			symbols = append(symbols, webpSymbol{pixel: pixels[i]})
+			lastSeen[hash(i)] = i + 1
+			i++
+			continue
+		}
+		symbols = append(symbols, webpSymbol{length: bestLength, distance: bestDistance})
+		for j := i; j < i+bestLength; j++ {
+			lastSeen[hash(j)] = j + 1
+		}
+		i += bestLength
+	}
+	return symbols
+}
+
+// prefixEncode splits value into a prefix symbol and extra bits, as done for backward reference lengths and distances.
+func prefixEncode(value int) (prefix int, extraBitsCount uint, extraBits uint32) {
+	value--
+	if value < 4 {
+		return value, 0, 0
+	}
+	highestBit := 0
+	for value>>(highestBit+1) != 0 {
+		highestBit++
+	}
+	secondHighestBit := (value >> (highestBit - 1)) & 1
+	extraBitsCount = uint(highestBit - 1)
+	return 2*highestBit + secondHighestBit, extraBitsCount, uint32(value) & (1<<extraBitsCount - 1)
+}
+
+// writeEntropyCodedImage writes pixels as an entropy-coded image. The main image (the one that is not a transform's data) has an extra bit for meta prefix codes.
+func writeEntropyCodedImage(w *bitWriter, pixels []uint32, width int, main bool) {
+	symbols := backwardReferences(pixels, width)
+
+	w.writeBits(0, 1) // no color cache
+	if main {
+		w.writeBits(0, 1) // no meta prefix codes
+	}
+
+	green := make([]int, 256+webpLengthPrefixCodes)
+	red := make([]int, 256)
+	blue := make([]int, 256)
+	alpha := make([]int, 256)
+	distance := make([]int, webpDistancePrefixes)
+	for _, symbol := range symbols {
+		if symbol.length == 0 {
+			green[(symbol.pixel>>8)&0xff]++
+			red[(symbol.pixel>>16)&0xff]++
+			blue[symbol.pixel&0xff]++
+			alpha[symbol.pixel>>24]++
+			continue
+		}
+		lengthPrefix, _, _ := prefixEncode(symbol.length)
+		green[256+lengthPrefix]++
+		distancePrefix, _, _ := prefixEncode(symbol.distance + 120)
+		distance[distancePrefix]++
+	}
+
+	codes := make([]prefixCode, 0, 5)
+	for _, histogram := range [][]int{green, red, blue, alpha, distance} {
+		code := newPrefixCode(histogram, webpMaxCodeLength)
+		code.writeHeader(w)
+		codes = append(codes, code)
+	}
+
+	for _, symbol := range symbols {
+		if symbol.length == 0 {
+			codes[0].write(w, int(symbol.pixel>>8)&0xff)
+			codes[1].write(w, int(symbol.pixel>>16)&0xff)
+			codes[2].write(w, int(symbol.pixel)&0xff)
+			codes[3].write(w, int(symbol.pixel>>24))
+			continue
+		}
+		prefix, extraBitsCount, extraBits := prefixEncode(symbol.length)
+		codes[0].write(w, 256+prefix)
+		w.writeBits(extraBits, extraBitsCount)
+		prefix, extraBitsCount, extraBits = prefixEncode(symbol.distance + 120)
+		codes[4].write(w, prefix)
+		w.writeBits(extraBits, extraBitsCount)
+	}
+}
+
+// prefixCode is a canonical Huffman code.
+type prefixCode struct {
+	lengths []uint8
+	// Codes, bit-reversed since they are written starting from their most significant bit
+	codes []uint32
+	// A code with a single symbol takes no bits to write
+	single bool
+}
+
+func newPrefixCode(histogram []int, maxLength int) prefixCode {
+	lengths := huffmanCodeLengths(histogram, maxLength)
+	code := prefixCode{lengths: lengths, codes: make([]uint32, len(lengths))}
+
+	used := 0
+	var countPerLength [webpMaxCodeLength + 1]int
+	for _, length := range lengths {
+		if length > 0 {
+			countPerLength[length]++
+			used++
+		}
+	}
+	code.single = used == 1
+
+	var nextCode [webpMaxCodeLength + 1]int
+	next := 0
+	for length := 1; length <= webpMaxCodeLength; length++ {
+		next = (next + countPerLength[length-1]) << 1
+		nextCode[length] = next
+	}
+	for symbol, length := range lengths {
+		if length == 0 {
+			continue
+		}
+		value := nextCode[length]
+		nextCode[length]++
+		var reversed uint32
+		for i := uint8(0); i < length; i++ {
+			reversed = reversed<<1 | uint32(value>>i)&1
+		}
+		code.codes[symbol] = reversed
+	}
+	return code
+}
+
+func (c prefixCode) write(w *bitWriter, symbol int) {
+	if c.single {
+		return
+	}
+	w.writeBits(c.codes[symbol], uint(c.lengths[symbol]))
+}
+
+func (c prefixCode) writeHeader(w *bitWriter) {
+	used := make([]int, 0, 2)
+	for symbol, length := range c.lengths {
+		if length > 0 {
+			used = append(used, symbol)
+		}
+	}
+
+	if len(used) <= 2 && (len(used) == 0 || used[len(used)-1] < 256) {
+		// Simple code
+		if len(used) == 0 {
+			used = append(used, 0)
+		}
+		w.writeBits(1, 1)
+		w.writeBits(uint32(len(used)-1), 1)
+		if used[0] < 2 {
+			w.writeBits(0, 1)
+			w.writeBits(uint32(used[0]), 1)
+		} else {
+			w.writeBits(1, 1)
+			w.writeBits(uint32(used[0]), 8)
+		}
+		if len(used) == 2 {
+			w.writeBits(uint32(used[1]), 8)
+		}
+		return
+	}
+
+	// Normal code: code lengths are run-length encoded, then prefix coded with the code length code
+	w.writeBits(0, 1)
+	tokens := codeLengthTokens(c.lengths)
+	histogram := make([]int, len(codeLengthCodeOrder))
+	for _, token := range tokens {
+		histogram[token.code]++
+	}
+	codeLengthCode := newPrefixCode(histogram, 7)
+	count := len(codeLengthCodeOrder)
+	for count > 4 && codeLengthCode.lengths[codeLengthCodeOrder[count-1]] == 0 {
+		count--
+	}
+	w.writeBits(uint32(count-4), 4)
+	for _, symbol := range codeLengthCodeOrder[:count] {
+		w.writeBits(uint32(codeLengthCode.lengths[symbol]), 3)
+	}
+	w.writeBits(0, 1) // all symbols have their length written
+	for _, token := range tokens {
+		codeLengthCode.write(w, token.code)
+		w.writeBits(token.extraBits, token.extraBitsCount)
+	}
+}
+
+type codeLengthToken struct {
+	code           int
+	extraBits      uint32
+	extraBitsCount uint
+}
+
+// codeLengthTokens run-length encodes code lengths: 16 repeats the previous length, 17 and 18 repeat zeros.
+func codeLengthTokens(lengths []uint8) []codeLengthToken {
+	tokens := make([]codeLengthToken, 0)
+	for i := 0; i < len(lengths); {
+		value := lengths[i]
+		run := 1
+		for i+run < len(lengths) && lengths[i+run] == value {
+			run++
+		}
+		i += run
+
+		if value == 0 {
+			for run >= 11 {
+				n := min(run, 138)
+				tokens = append(tokens, codeLengthToken{18, uint32(n - 11), 7})
+				run -= n
+			}
+			if run >= 3 {
+				tokens = append(tokens, codeLengthToken{17, uint32(run - 3), 3})
+				run = 0
+			}
+		} else {
+			tokens = append(tokens, codeLengthToken{code: int(value)})
+			run--
+			for run >= 3 {
+				n := min(run, 6)
+				tokens = append(tokens, codeLengthToken{16, uint32(n - 3), 2})
+				run -= n
+			}
+		}
+		for ; run > 0; run-- {
+			tokens = append(tokens, codeLengthToken{code: int(value)})
+		}
+	}
+	return tokens
+}
+
+// huffmanCodeLengths returns the code length of each symbol, none of them being longer than maxLength.
+// When the optimal code is too deep, rare symbols are made more frequent until it fits.
+func huffmanCodeLengths(histogram []int, maxLength int) []uint8 {
+	type node struct {
+		weight      int
+		symbol      int
+		left, right *node
+	}
+
+	lengths := make([]uint8, len(histogram))
+	for minimumCount := 1; ; minimumCount *= 2 {
+		leaves := make([]*node, 0)
+		for symbol, count := range histogram {
+			if count > 0 {
+				leaves = append(leaves, &node{weight: max(count, minimumCount), symbol: symbol})
+			}
+		}
+		switch len(leaves) {
+		case 0:
+			return lengths
+		case 1:
+			lengths[leaves[0].symbol] = 1
+			return lengths
+		}
+		sort.SliceStable(leaves, func(i, j int) bool { return leaves[i].weight < leaves[j].weight })
+
+		// Two-queues construction: internal nodes are created in increasing weight order
+		internal := make([]*node, 0, len(leaves))
+		nextLeaf, nextInternal := 0, 0
+		pop := func() *node {
+			if nextInternal >= len(internal) || (nextLeaf < len(leaves) && leaves[nextLeaf].weight <= internal[nextInternal].weight) {
+				nextLeaf++
+				return leaves[nextLeaf-1]
+			}
+			nextInternal++
+			return internal[nextInternal-1]
+		}
+		for (len(leaves) - nextLeaf + len(internal) - nextInternal) > 1 {
+			a, b := pop(), pop()
+			internal = append(internal, &node{weight: a.weight + b.weight, left: a, right: b})
+		}
+
+		tooDeep := false
+		var assign func(n *node, depth int)
+		assign = func(n *node, depth int) {
+			if n.left == nil {
+				if depth > maxLength {
+					tooDeep = true
+				}
+				lengths[n.symbol] = uint8(min(depth, 255))
+				return
+			}
+			assign(n.left, depth+1)
+			assign(n.right, depth+1)
+		}
+		assign(internal[len(internal)-1], 0)
+		if !tooDeep {
+			return lengths
+		}
+	}
+}
+
+// bitWriter writes bits starting from the least significant bit of each byte.
+type bitWriter struct {
+	buffer []byte
+	bits   uint64
+	count  uint
+}
+
+func (w *bitWriter) writeBits(value uint32, count uint) {
+	w.bits |= uint64(value) << w.count
+	w.count += count
+	for w.count >= 8 {
+		w.buffer = append(w.buffer, byte(w.bits))
+		w.bits >>= 8
+		w.count -= 8
+	}
+}
+
+func (w *bitWriter) bytes() []byte {
+	if w.count > 0 {
+		w.buffer = append(w.buffer, byte(w.bits))
+		w.bits, w.count = 0, 0
+	}
+	return w.buffer
+}